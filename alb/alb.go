@@ -29,6 +29,8 @@ type ALB struct {
 	name   string
 	port   int
 	conf   config.ALB
+	// relaxLimits disables the payload size limits of lambda targets.
+	relaxLimits bool
 }
 
 type mockdata struct {
@@ -96,6 +98,8 @@ func New(subrouter *mux.Router, lambs lambstack.LambdaFactory, conf config.ALB,
 		lambs:  lambs,
 		port:   port,
 		conf:   conf,
+
+		relaxLimits: stack.RelaxLimits,
 	}
 	for s, dat := range stack.MockData {
 		intro, _ := json.Marshal(dat.Introspection)
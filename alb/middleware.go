@@ -2,6 +2,7 @@ package alb
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -18,6 +19,19 @@ func FixedResponseHandler(body, contentType string) http.HandlerFunc {
 	}
 }
 
+// maxLambdaPayload is the size limit of the request body and response of lambda targets.
+const maxLambdaPayload = 1024 * 1024
+
+// errorResponse writes the html error page of the load balancer.
+func errorResponse(w http.ResponseWriter, status int) {
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<html>\r\n<head><title>%s</title></head>\r\n<body>\r\n<center><h1>%s</h1></center>\r\n</body>\r\n</html>\r\n", title, title)
+}
+
+// LambdaProxy invokes the lambda target with the request, the request body and the response are limited to
+// 1 MB unless the limits are relaxed.
 func (alb *ALB) LambdaProxy(arn string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		headers := make(map[string]string)
@@ -35,6 +49,11 @@ func (alb *ALB) LambdaProxy(arn string) http.HandlerFunc {
 			return
 		}
 		defer r.Body.Close()
+		if !alb.relaxLimits && len(body) > maxLambdaPayload {
+			log.Info().Str("arn", arn).Int("size", len(body)).Msg("request body over the lambda target limit")
+			errorResponse(w, http.StatusRequestEntityTooLarge)
+			return
+		}
 		payload := events.ALBTargetGroupRequest{
 			HTTPMethod:            r.Method,
 			Path:                  r.URL.Path,
@@ -48,6 +67,11 @@ func (alb *ALB) LambdaProxy(arn string) http.HandlerFunc {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if !alb.relaxLimits && len(b) > maxLambdaPayload {
+			log.Error().Str("arn", arn).Int("size", len(b)).Msg("lambda response over the lambda target limit")
+			errorResponse(w, http.StatusBadGateway)
+			return
+		}
 		var resp events.ALBTargetGroupResponse
 		if err = json.Unmarshal(b, &resp); err != nil {
 			log.Error().Err(err).Str("arn", arn).Msg("unable to unmarshal lambda response")
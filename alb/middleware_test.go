@@ -0,0 +1,55 @@
+package alb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFactory struct {
+	lambstack.LambdaFactory
+	body string
+}
+
+func (m mockFactory) Invoke(_ string, _ any) ([]byte, error) {
+	return json.Marshal(events.ALBTargetGroupResponse{StatusCode: http.StatusOK, Body: m.body})
+}
+
+func TestALB_LambdaProxyLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		response string
+		status   int
+		relaxed  int
+	}{
+		{name: "within the limits", request: "rex", response: "ok", status: http.StatusOK, relaxed: http.StatusOK},
+		{name: "request over the limit", request: strings.Repeat("a", maxLambdaPayload+1), response: "ok", status: http.StatusRequestEntityTooLarge, relaxed: http.StatusOK},
+		{name: "response over the limit", request: "rex", response: strings.Repeat("a", maxLambdaPayload), status: http.StatusBadGateway, relaxed: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, relax := range []bool{false, true} {
+				lb := &ALB{lambs: mockFactory{body: tt.response}, relaxLimits: relax}
+				w := httptest.NewRecorder()
+				lb.LambdaProxy("arn:aws:lambda:us-east-1:123456789012:function:target")(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.request)))
+				if relax {
+					assert.Equal(t, tt.relaxed, w.Code)
+					continue
+				}
+				assert.Equal(t, tt.status, w.Code)
+				if tt.status != http.StatusOK {
+					assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+					assert.Contains(t, w.Body.String(), fmt.Sprintf("<center><h1>%d %s</h1></center>", tt.status, http.StatusText(tt.status)))
+				}
+			}
+		})
+	}
+}
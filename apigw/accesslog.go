@@ -0,0 +1,246 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iwarapter/gostack/internal/mw"
+	"github.com/iwarapter/gostack/internal/vtl"
+)
+
+const accessLogContext contextKey = "access-log"
+
+// accessLogVariableRx matches the $context variables of an access log format, such as $context.identity.sourceIp.
+var accessLogVariableRx = regexp.MustCompile(`\$context\.[A-Za-z0-9_]+(?:\.[A-Za-z0-9_]+)*`)
+
+// accessLog writes a line in the access log format of the stage for every request.
+type accessLog struct {
+	format string
+	out    *accessLogWriter
+}
+
+// accessLogWriter serialises the lines written to a destination shared by several stages.
+type accessLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *accessLogWriter) writeLine(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = io.WriteString(w.w, line+"\n")
+}
+
+var (
+	accessLogWritersMu sync.Mutex
+	accessLogWriters   = map[string]*accessLogWriter{}
+)
+
+// newAccessLog creates the access log of a stage, the destination is stdout (the default), stderr or the path
+// of a file that is appended to.
+func newAccessLog(format, destination string) (*accessLog, error) {
+	if format == "" {
+		return nil, nil
+	}
+	if destination == "" {
+		destination = "stdout"
+	}
+	accessLogWritersMu.Lock()
+	defer accessLogWritersMu.Unlock()
+	out, ok := accessLogWriters[destination]
+	if !ok {
+		switch destination {
+		case "stdout":
+			out = &accessLogWriter{w: os.Stdout}
+		case "stderr":
+			out = &accessLogWriter{w: os.Stderr}
+		default:
+			f, err := os.OpenFile(destination, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open access log %s error: %w", destination, err)
+			}
+			out = &accessLogWriter{w: f}
+		}
+		accessLogWriters[destination] = out
+	}
+	return &accessLog{format: format, out: out}, nil
+}
+
+// accessLogEntry collects the values of a request that are only known to the handlers serving it.
+type accessLogEntry struct {
+	mu                 sync.Mutex
+	request            *http.Request
+	integrationLatency time.Duration
+	integrationStatus  int
+	errorMessage       string
+	errorResponseType  string
+	authorizerError    string
+}
+
+func accessLogEntryFrom(r *http.Request) (*accessLogEntry, bool) {
+	e, ok := r.Context().Value(accessLogContext).(*accessLogEntry)
+	return e, ok
+}
+
+// recordRequest keeps the request as seen by the innermost handler, so the route, authorizer and identity
+// of the request are available to the access log.
+func recordRequest(r *http.Request) {
+	if e, ok := accessLogEntryFrom(r); ok {
+		e.mu.Lock()
+		e.request = r
+		e.mu.Unlock()
+	}
+}
+
+// recordGatewayError keeps the gateway response of the request, authorization failures are also the
+// authorizer error.
+func recordGatewayError(r *http.Request, responseType gatewayResponseType, message string) {
+	e, ok := accessLogEntryFrom(r)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errorMessage, e.errorResponseType = message, string(responseType)
+	switch responseType {
+	case unauthorized, accessDenied, expiredToken, authorizerFailure, authorizerConfigurationError:
+		e.authorizerError = message
+	}
+}
+
+// clearGatewayError forgets the gateway response of the request, once another security alternative
+// has authorized it.
+func clearGatewayError(r *http.Request) {
+	if e, ok := accessLogEntryFrom(r); ok {
+		e.mu.Lock()
+		e.errorMessage, e.errorResponseType, e.authorizerError = "", "", ""
+		e.mu.Unlock()
+	}
+}
+
+// recordIntegration measures the latency and status of the integration for the access log.
+func recordIntegration(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := accessLogEntryFrom(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		recordRequest(r)
+		start := time.Now()
+		lw := &accessLogResponseWriter{ResponseWriter: mw.ResponseWriter{ResponseWriter: w}}
+		h.ServeHTTP(lw, r)
+		e.mu.Lock()
+		e.integrationLatency = time.Since(start)
+		e.integrationStatus = lw.status()
+		e.mu.Unlock()
+	}
+}
+
+// accessLogResponseWriter records the status and length of the response.
+type accessLogResponseWriter struct {
+	mw.ResponseWriter
+	statusCode int
+	length     int
+}
+
+func (lw *accessLogResponseWriter) WriteHeader(code int) {
+	if lw.statusCode == 0 {
+		lw.statusCode = code
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if lw.statusCode == 0 {
+		lw.statusCode = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.length += n
+	return n, err
+}
+
+func (lw *accessLogResponseWriter) status() int {
+	if lw.statusCode == 0 {
+		return http.StatusOK
+	}
+	return lw.statusCode
+}
+
+// accessLogMiddleware writes the access log line of the stage once the request has been served, the request
+// id and time are set here so the line matches the request id given to integrations.
+func (api *API) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stage := api.stageFromRequest(r)
+		if stage.accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ctx := context.WithValue(r.Context(), requestIDContext, newRequestID())
+		ctx = context.WithValue(ctx, requestTimeContext, start)
+		e := &accessLogEntry{}
+		ctx = context.WithValue(ctx, accessLogContext, e)
+		r = r.WithContext(ctx)
+		e.request = r
+		lw := &accessLogResponseWriter{ResponseWriter: mw.ResponseWriter{ResponseWriter: w}}
+		next.ServeHTTP(lw, r)
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		vars := api.contextVariables(e.request)
+		vars["routeKey"] = routeKey(e.request)
+		vars["status"] = lw.status()
+		vars["responseLength"] = lw.length
+		vars["responseLatency"] = time.Since(start).Milliseconds()
+		integration := map[string]any{}
+		if e.integrationStatus != 0 {
+			vars["integrationLatency"] = e.integrationLatency.Milliseconds()
+			vars["integrationStatus"] = e.integrationStatus
+			integration["latency"] = e.integrationLatency.Milliseconds()
+			integration["status"] = e.integrationStatus
+		}
+		vars["integration"] = integration
+		vars["error"] = map[string]any{"message": e.errorMessage, "responseType": e.errorResponseType}
+		authorizer, _ := vars["authorizer"].(map[string]any)
+		if authorizer == nil {
+			authorizer = map[string]any{}
+		}
+		authorizer["error"] = e.authorizerError
+		vars["authorizer"] = authorizer
+		stage.accessLog.out.writeLine(formatAccessLog(stage.accessLog.format, vars))
+	})
+}
+
+// formatAccessLog replaces the $context variables of the format, variables without a value are logged as -.
+func formatAccessLog(format string, vars map[string]any) string {
+	return accessLogVariableRx.ReplaceAllStringFunc(format, func(match string) string {
+		var v any = vars
+		for _, key := range strings.Split(strings.TrimPrefix(match, "$context."), ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "-"
+			}
+			if v, ok = m[key]; !ok {
+				return "-"
+			}
+		}
+		switch v.(type) {
+		case map[string]any, []any:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+		if s := vtl.String(v); s != "" {
+			return s
+		}
+		return "-"
+	})
+}
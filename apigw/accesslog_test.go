@@ -0,0 +1,153 @@
+package apigw
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AccessLog(t *testing.T) {
+	var requestIDs []string
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple": func(payload any) ([]byte, error) {
+				requestIDs = append(requestIDs, payload.(events.APIGatewayProxyRequest).RequestContext.RequestID)
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "unit-test"})
+			},
+			"arn:aws:lambda:us-east-1:123456789012:function:request-auth": func(payload any) ([]byte, error) {
+				effect := "Allow"
+				if payload.(requestAuthorizerEvent).Headers["Authorization"] == "deny" {
+					effect = "Deny"
+				}
+				return json.Marshal(events.APIGatewayCustomAuthorizerResponse{
+					PrincipalID: "user",
+					PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+						Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: effect, Resource: []string{"*"}}},
+					},
+				})
+			},
+		},
+	}
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/lambda-authorizer.yml")
+	require.NoError(t, err)
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	format := `{"requestId":"$context.requestId","ip":"$context.identity.sourceIp","method":"$context.httpMethod",` +
+		`"resourcePath":"$context.resourcePath","stage":"$context.stage","status":"$context.status",` +
+		`"responseLength":"$context.responseLength","integrationStatus":"$context.integrationStatus",` +
+		`"principalId":"$context.authorizer.principalId","authorizerError":"$context.authorizer.error",` +
+		`"errorType":"$context.error.responseType"}`
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "unit-test", Stages: []config.APIStage{
+		{Name: "dev", AccessLogFormat: format, AccessLogDestination: logFile},
+	}})
+	require.NoError(t, api.Import(doc))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, tt := range []struct{ path, authorization string }{
+		{path: "/simple", authorization: "allow"},
+		{path: "/simple", authorization: "deny"},
+		{path: "/simple"},
+		{path: "/missing"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/unit-test/dev/_user_request_"+tt.path, nil)
+		require.NoError(t, err)
+		req.Host = apiHostName
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	file, err := os.Open(logFile)
+	require.NoError(t, err)
+	defer file.Close()
+	var lines []map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]string
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 4)
+	require.Len(t, requestIDs, 1)
+
+	assert.Equal(t, map[string]string{
+		"requestId":         requestIDs[0],
+		"ip":                "127.0.0.1",
+		"method":            "GET",
+		"resourcePath":      "/simple",
+		"stage":             "dev",
+		"status":            "200",
+		"responseLength":    "9",
+		"integrationStatus": "200",
+		"principalId":       "user",
+		"authorizerError":   "-",
+		"errorType":         "-",
+	}, lines[0])
+
+	assert.Equal(t, "403", lines[1]["status"])
+	assert.Equal(t, "-", lines[1]["integrationStatus"])
+	assert.Equal(t, explicitDenyMessage, lines[1]["authorizerError"])
+	assert.Equal(t, "ACCESS_DENIED", lines[1]["errorType"])
+
+	assert.Equal(t, "401", lines[2]["status"])
+	assert.Equal(t, "Unauthorized", lines[2]["authorizerError"])
+	assert.Equal(t, "UNAUTHORIZED", lines[2]["errorType"])
+
+	assert.Equal(t, "403", lines[3]["status"])
+	assert.Equal(t, "MISSING_AUTHENTICATION_TOKEN", lines[3]["errorType"])
+	assert.Equal(t, "-", lines[3]["authorizerError"])
+}
+
+func Test_FormatAccessLog(t *testing.T) {
+	vars := map[string]any{
+		"requestId":      "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		"identity":       map[string]any{"sourceIp": "127.0.0.1", "caller": ""},
+		"requestTime":    "01/Jan/2023:10:00:00 +0000",
+		"httpMethod":     "GET",
+		"resourcePath":   "/pets",
+		"protocol":       "HTTP/1.1",
+		"status":         200,
+		"responseLength": 42,
+		"authorizer":     map[string]any{"claims": map[string]any{"sub": "abc"}},
+	}
+	tests := []struct {
+		name, format, expected string
+	}{
+		{
+			name:     "clf",
+			format:   `$context.identity.sourceIp $context.identity.caller - [$context.requestTime] "$context.httpMethod $context.resourcePath $context.protocol" $context.status $context.responseLength $context.requestId`,
+			expected: `127.0.0.1 - - [01/Jan/2023:10:00:00 +0000] "GET /pets HTTP/1.1" 200 42 c6af9ac6-7b61-11e6-9a41-93e8deadbeef`,
+		},
+		{
+			name:     "csv",
+			format:   `$context.identity.sourceIp,$context.httpMethod,$context.resourcePath,$context.status,$context.integrationLatency`,
+			expected: `127.0.0.1,GET,/pets,200,-`,
+		},
+		{
+			name:     "nested values",
+			format:   `$context.authorizer.claims.sub $context.authorizer.claims`,
+			expected: `abc {"sub":"abc"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, formatAccessLog(tt.format, vars))
+		})
+	}
+}
@@ -2,20 +2,60 @@ package apigw
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"net/http"
+	"time"
 
 	cache "github.com/Code-Hex/go-generics-cache"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/cognito"
 	"github.com/iwarapter/gostack/lambstack"
 	"github.com/rs/zerolog/log"
 )
 
 type API struct {
-	ID        string
-	router    *mux.Router
+	ID string
+	// IdentityProvider validates the tokens of cognito user pool authorizers.
+	IdentityProvider *cognito.Provider
+	// UsagePlans holds the api keys and usage plans of methods requiring an api key.
+	UsagePlans *UsagePlans
+	// Credentials are the access keys accepted by methods using IAM authorization.
+	Credentials []config.Credential
+	// RelaxLimits disables the payload and header size limits and the integration timeout of API Gateway.
+	RelaxLimits bool
+
+	stages    []*Stage
+	mounts    []*Stage
+	routes    []route
 	lambs     lambstack.LambdaFactory
 	authCache *cache.Cache[string, events.APIGatewayCustomAuthorizerResponse]
+	limiter   *limiter
+	// integrationTimeout is the longest an integration may take to respond.
+	integrationTimeout time.Duration
+
+	gatewayResponses map[gatewayResponseType]*gatewayResponse
+	cors             *config.CORS
+	binaryTypes      []string
+
+	// spec and exports describe the imported configuration for exports.
+	spec        *openapi3.T
+	exports     []exportedOperation
+	diagnostics []Diagnostic
+}
+
+const (
+	routeContext       contextKey = "route"
+	requestIDContext   contextKey = "request-id"
+	requestTimeContext contextKey = "request-time"
+)
+
+type route struct {
+	method, path, name string
+	handler            http.Handler
 }
 
 // const alphaNumeric = "1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -28,17 +68,163 @@ type API struct {
 //	return string(b)
 //}
 
-func New(subrouter *mux.Router, lambs lambstack.LambdaFactory, id string) *API {
-	log.Info().Str("apid_id", id).Msg("creating api gateway")
-	router := subrouter.PathPrefix(fmt.Sprintf("/%s", id)).Subrouter()
+func New(subrouter *mux.Router, lambs lambstack.LambdaFactory, conf config.APIGW) *API {
+	log.Info().Str("apid_id", conf.ID).Msg("creating api gateway")
+	prefix := subrouter.PathPrefix(fmt.Sprintf("/%s", conf.ID))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	api := &API{
-		ID:        id,
-		router:    router,
+		ID:        conf.ID,
 		authCache: cache.NewContext[string, events.APIGatewayCustomAuthorizerResponse](ctx),
 		lambs:     lambs,
+		limiter:   newLimiter(),
+
+		integrationTimeout: defaultIntegrationTimeout,
+	}
+
+	if len(conf.Stages) == 0 {
+		// without any stages the api is served directly from its id prefix
+		api.stages = append(api.stages, &Stage{APIID: conf.ID, accessLog: stageAccessLog(conf, config.APIStage{})})
+		if err := api.Mount(prefix, ""); err != nil {
+			log.Error().Err(err).Str("apid_id", conf.ID).Msg("unable to mount the api")
+		}
+		return api
+	}
+	router := prefix.Subrouter()
+	for _, s := range conf.Stages {
+		log.Info().Str("apid_id", conf.ID).Str("stage", s.Name).Msg("adding api gateway stage")
+		api.stages = append(api.stages, &Stage{Name: s.Name, APIID: conf.ID, Variables: s.Variables, throttling: s.Throttling, accessLog: stageAccessLog(conf, s)})
+		if err := api.Mount(router.PathPrefix(fmt.Sprintf("/%s/_user_request_", s.Name)), s.Name); err != nil {
+			log.Error().Err(err).Str("apid_id", conf.ID).Str("stage", s.Name).Msg("unable to mount the api stage")
+		}
 	}
 
 	return api
 }
+
+// stageAccessLog is the access log of the stage, stages without their own settings use those of the api.
+func stageAccessLog(conf config.APIGW, s config.APIStage) *accessLog {
+	format, destination := conf.AccessLogFormat, conf.AccessLogDestination
+	if s.AccessLogFormat != "" {
+		format, destination = s.AccessLogFormat, s.AccessLogDestination
+	}
+	al, err := newAccessLog(format, destination)
+	if err != nil {
+		log.Error().Err(err).Str("apid_id", conf.ID).Str("stage", s.Name).Msg("unable to create the stage access log")
+	}
+	return al
+}
+
+// Mount serves the named stage of the api from the given route, the path of the route
+// is treated as the base path and stripped from the request path given to integrations.
+func (api *API) Mount(rt *mux.Route, stage string) error {
+	for _, s := range api.stages {
+		if s.Name != stage {
+			continue
+		}
+		basePath, err := rt.GetPathTemplate()
+		if err != nil {
+			basePath = ""
+		}
+		m := &Stage{
+			Name:       s.Name,
+			APIID:      s.APIID,
+			Variables:  s.Variables,
+			basePath:   basePath,
+			throttling: s.throttling,
+			accessLog:  s.accessLog,
+			router:     rt.Subrouter(),
+		}
+		m.router.Use(m.middleware, api.accessLogMiddleware, api.corsMiddleware)
+		// unmatched resources and methods are answered by API Gateway rather than mux
+		m.router.NotFoundHandler = m.middleware(api.accessLogMiddleware(api.corsMiddleware(http.HandlerFunc(api.missingRoute))))
+		m.router.MethodNotAllowedHandler = m.router.NotFoundHandler
+		api.mounts = append(api.mounts, m)
+		for _, r := range api.routes {
+			r.register(m.router)
+		}
+		return nil
+	}
+	return fmt.Errorf("api %s has no stage named '%s'", api.ID, stage)
+}
+
+// MountExecuteAPI serves every stage of the api from the execute-api style hostnames
+// {id}.execute-api.127.0.0.1.nip.io/{stage} and {id}.execute-api.{region}.amazonaws.com/{stage}.
+func (api *API) MountExecuteAPI(router *mux.Router) error {
+	hosts := []string{
+		fmt.Sprintf("%s.execute-api.127.0.0.1.nip.io", api.ID),
+		fmt.Sprintf("%s.execute-api.{region}.amazonaws.com", api.ID),
+	}
+	for _, host := range hosts {
+		for _, s := range api.stages {
+			rt := router.Host(host)
+			if s.Name != "" {
+				rt = rt.PathPrefix(fmt.Sprintf("/%s", s.Name))
+			}
+			if err := api.Mount(rt, s.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handle registers the handler for the operation against every mount of the api, only the
+// first mount names the route so lookups by operation id are stable.
+func (api *API) handle(method, path, name string, handler http.Handler) {
+	rt := route{method: method, path: path, name: name}
+	rt.handler = rt.middleware(api.limitRequest(api.throttle(handler)))
+	api.routes = append(api.routes, rt)
+	for i, m := range api.mounts {
+		r := rt.register(m.router)
+		if i == 0 && name != "" {
+			r.Name(name)
+		}
+	}
+}
+
+func (rt route) register(router *mux.Router) *mux.Route {
+	r := router.Path(muxPath(rt.path)).Handler(rt.handler)
+	if rt.method != anyMethod {
+		r.Methods(rt.method)
+	}
+	return r
+}
+
+func (rt route) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), routeContext, rt)
+		// the access log sets the request id and time when it is enabled
+		if _, ok := ctx.Value(requestIDContext).(string); !ok {
+			ctx = context.WithValue(ctx, requestIDContext, newRequestID())
+			ctx = context.WithValue(ctx, requestTimeContext, time.Now())
+		}
+		r = r.WithContext(ctx)
+		recordRequest(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// resourcePath is the spec path of the route matched for the request.
+func resourcePath(r *http.Request) string {
+	if rt, ok := r.Context().Value(routeContext).(route); ok {
+		return rt.path
+	}
+	return "/{proxy+}"
+}
+
+// routeKey is the HTTP API route key of the route matched for the request.
+func routeKey(r *http.Request) string {
+	if rt, ok := r.Context().Value(routeContext).(route); ok {
+		return rt.method + " " + rt.path
+	}
+	return "$default"
+}
@@ -0,0 +1,77 @@
+package apigw
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const binaryMediaTypesExtension = "x-amazon-apigateway-binary-media-types"
+
+const (
+	convertToBinary = "CONVERT_TO_BINARY"
+	convertToText   = "CONVERT_TO_TEXT"
+)
+
+// binaryMediaTypes parses the binary media types of the spec, exported specs escape the / of media
+// types as ~1 such as image~1png.
+func binaryMediaTypes(spec *openapi3.T) ([]string, error) {
+	ext, ok := spec.Extensions[binaryMediaTypesExtension]
+	if !ok {
+		return nil, nil
+	}
+	var types []string
+	if err := decodeExtension(ext, &types); err != nil {
+		return nil, fmt.Errorf("unable to parse %s extension error: %w", binaryMediaTypesExtension, err)
+	}
+	for i, t := range types {
+		types[i] = strings.ToLower(strings.ReplaceAll(t, "~1", "/"))
+	}
+	return types, nil
+}
+
+// isBinary is true when the first media type of the Content-Type or Accept header matches one of the
+// binary media types of the api, which may use * wildcards such as image/* or */*.
+func (api *API) isBinary(header string) bool {
+	mediaType := firstMediaType(header)
+	if mediaType == "" {
+		return false
+	}
+	typ, sub, _ := strings.Cut(mediaType, "/")
+	for _, bt := range api.binaryTypes {
+		btyp, bsub, _ := strings.Cut(bt, "/")
+		if (btyp == "*" || btyp == typ) && (bsub == "*" || bsub == sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstMediaType(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(first))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// convertContent applies the content handling of an integration to a payload, binary payloads are base64
+// encoded by CONVERT_TO_TEXT and text payloads are base64 decoded by CONVERT_TO_BINARY. Other payloads are
+// passed through, the returned bool is true when the result is binary.
+func convertContent(handling string, payload []byte, binary bool) ([]byte, bool, error) {
+	switch {
+	case handling == convertToText && binary:
+		return []byte(base64.StdEncoding.EncodeToString(payload)), false, nil
+	case handling == convertToBinary && !binary:
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(payload)))
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to convert the payload to binary: %w", err)
+		}
+		return decoded, true, nil
+	}
+	return payload, binary, nil
+}
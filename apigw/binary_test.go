@@ -0,0 +1,158 @@
+package apigw
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_IsBinary(t *testing.T) {
+	api := &API{binaryTypes: []string{"image/*", "application/pdf"}}
+	assert.True(t, api.isBinary("image/png"))
+	assert.True(t, api.isBinary("application/pdf; charset=binary"))
+	assert.True(t, api.isBinary("image/jpeg, application/json"))
+	assert.False(t, api.isBinary("application/json, image/jpeg"))
+	assert.False(t, api.isBinary("text/plain"))
+	assert.False(t, api.isBinary(""))
+	assert.True(t, (&API{binaryTypes: []string{"*/*"}}).isBinary("text/plain"))
+}
+
+func TestConvertContent(t *testing.T) {
+	out, binary, err := convertContent(convertToText, []byte{0xff, 0x00}, true)
+	require.NoError(t, err)
+	assert.Equal(t, "/wA=", string(out))
+	assert.False(t, binary)
+
+	out, binary, err = convertContent(convertToBinary, []byte("/wA=\n"), false)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0x00}, out)
+	assert.True(t, binary)
+
+	_, _, err = convertContent(convertToBinary, []byte("not base64!"), false)
+	assert.Error(t, err)
+
+	out, binary, err = convertContent("", []byte{0xff}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff}, out)
+	assert.True(t, binary)
+}
+
+func Test_ImportBinaryMediaTypes(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/encoded/") {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(png)))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	}))
+	defer backend.Close()
+
+	var proxyEvent events.APIGatewayProxyRequest
+	var document map[string]string
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:echo": func(payload any) ([]byte, error) {
+				proxyEvent = payload.(events.APIGatewayProxyRequest)
+				return json.Marshal(events.APIGatewayProxyResponse{
+					StatusCode:      http.StatusOK,
+					Headers:         map[string]string{"Content-Type": proxyEvent.Headers["Content-Type"]},
+					Body:            proxyEvent.Body,
+					IsBase64Encoded: proxyEvent.IsBase64Encoded,
+				})
+			},
+			"arn:aws:lambda:us-east-1:123456789012:function:document": func(payload any) ([]byte, error) {
+				require.NoError(t, json.Unmarshal(payload.(json.RawMessage), &document))
+				return []byte(`{"id":"1"}`), nil
+			},
+		},
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/binary-media-types.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "binary", Stages: []config.APIStage{
+		{Name: "dev", Variables: map[string]string{"backend": strings.TrimPrefix(backend.URL, "http://")}},
+	}})
+	require.NoError(t, api.Import(doc))
+	assert.Equal(t, []string{"image/*", "application/pdf"}, api.binaryTypes)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	do := func(method, path, contentType, accept string, body []byte) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, srv.URL+"/binary/dev/_user_request_"+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Host = apiHostName
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, b
+	}
+
+	t.Run("binary proxy requests are base64 encoded", func(t *testing.T) {
+		resp, body := do(http.MethodPost, "/upload", "image/png", "", png)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, proxyEvent.IsBase64Encoded)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(png), proxyEvent.Body)
+		assert.Equal(t, png, body)
+	})
+
+	t.Run("text proxy requests are not encoded", func(t *testing.T) {
+		resp, body := do(http.MethodPost, "/upload", "application/json", "", []byte(`{"a":1}`))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.False(t, proxyEvent.IsBase64Encoded)
+		assert.Equal(t, `{"a":1}`, string(body))
+	})
+
+	t.Run("binary requests are converted to text for mapping templates", func(t *testing.T) {
+		pdf := []byte("%PDF-1.4\x00\xff")
+		resp, body := do(http.MethodPost, "/documents", "application/pdf", "", pdf)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `{"id":"1"}`, string(body))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(pdf), document["document"])
+	})
+
+	t.Run("binary responses are passed through", func(t *testing.T) {
+		resp, body := do(http.MethodGet, "/images/cat.png", "", "image/png", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+		assert.Equal(t, png, body)
+	})
+
+	t.Run("binary responses are converted to text", func(t *testing.T) {
+		resp, body := do(http.MethodGet, "/images/cat.png/base64", "", "application/json", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `{"image": "`+base64.StdEncoding.EncodeToString(png)+`"}`, string(body))
+	})
+
+	t.Run("text responses are converted to binary", func(t *testing.T) {
+		resp, body := do(http.MethodGet, "/encoded/cat.png", "", "image/png", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+		assert.Equal(t, png, body)
+	})
+}
@@ -0,0 +1,122 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/iwarapter/gostack/internal/cognito"
+	"github.com/rs/zerolog/log"
+)
+
+const expiredTokenMessage = "The incoming token has expired"
+
+// CognitoAuthorizer validates the user pool token in the identity source of the request against the provider
+// ARNs of the authorizer. Methods with OAuth scopes require an access token with one of the scopes, otherwise
+// an id token is required. The token claims are passed as requestContext.authorizer.claims.
+func (api *API) CognitoAuthorizer(authorizer XAmazonAPIGatewayAuthorizer, scopes []string, h http.HandlerFunc) http.HandlerFunc {
+	pools := make([]string, 0, len(authorizer.ProviderARNs))
+	for _, arn := range authorizer.ProviderARNs {
+		id, err := cognito.PoolID(arn)
+		if err != nil {
+			log.Error().Err(err).Str("api_id", api.ID).Msg("ignoring cognito authorizer provider")
+			continue
+		}
+		pools = append(pools, id)
+	}
+	source := authorizer.identitySources()[0]
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-cognito-authorizer").Logger()
+		token, ok := api.parameterValue(r, source)
+		if token = strings.TrimSpace(token); !ok || token == "" {
+			subl.Info().Str("identity_source", source).Msg("missing identity source")
+			api.gatewayError(w, r, unauthorized, "Unauthorized")
+			return
+		}
+		if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+			token = strings.TrimSpace(token[7:])
+		}
+
+		provider := api.identityProvider()
+		var claims map[string]any
+		var err error
+		var pool string
+		for _, pool = range pools {
+			if claims, err = provider.Claims(pool, token); err == nil {
+				break
+			}
+		}
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			subl.Info().Msg("expired token")
+			api.gatewayError(w, r, unauthorized, expiredTokenMessage)
+			return
+		case err != nil || claims == nil:
+			subl.Info().AnErr("error", err).Msg("invalid token")
+			api.gatewayError(w, r, unauthorized, "Unauthorized")
+			return
+		}
+
+		tokenUse, _ := claims["token_use"].(string)
+		client, _ := claims["client_id"].(string)
+		if tokenUse == "id" {
+			client, _ = claims["aud"].(string)
+		}
+		switch {
+		case client != "" && !provider.ValidClient(pool, client):
+			subl.Info().Str("client_id", client).Msg("token issued to an unknown client")
+			api.gatewayError(w, r, unauthorized, "Unauthorized")
+			return
+		case len(scopes) > 0 && (tokenUse == "id" || !hasScope(claims["scope"], scopes)):
+			subl.Info().Strs("scopes", scopes).Msg("token does not have any of the method scopes")
+			api.gatewayError(w, r, unauthorized, "Unauthorized")
+			return
+		case len(scopes) == 0 && tokenUse == "access":
+			subl.Info().Msg("an id token is required for methods without scopes")
+			api.gatewayError(w, r, unauthorized, "Unauthorized")
+			return
+		}
+
+		auth := events.APIGatewayCustomAuthorizerResponse{Context: map[string]any{"claims": stringClaims(claims)}}
+		auth.PrincipalID, _ = claims["sub"].(string)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), AuthorizerContext, auth)))
+	}
+}
+
+func (api *API) identityProvider() *cognito.Provider {
+	if api.IdentityProvider == nil {
+		return cognito.New(nil, nil)
+	}
+	return api.IdentityProvider
+}
+
+// hasScope is true when the space separated scope claim contains any of the scopes.
+func hasScope(claim any, scopes []string) bool {
+	s, _ := claim.(string)
+	for _, granted := range strings.Fields(s) {
+		for _, scope := range scopes {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringClaims converts the claims to strings as API Gateway does for user pool authorizers.
+func stringClaims(claims map[string]any) map[string]any {
+	out := make(map[string]any, len(claims))
+	for k, v := range claims {
+		if s, ok := v.(string); ok {
+			out[k] = s
+			continue
+		}
+		b, _ := json.Marshal(v)
+		out[k] = string(b)
+	}
+	return out
+}
@@ -0,0 +1,158 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/cognito"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_CognitoAuthorizer(t *testing.T) {
+	const pool = "us-east-1_unitTest"
+	var proxyEvent events.APIGatewayProxyRequest
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:echo": func(payload any) ([]byte, error) {
+				proxyEvent = payload.(events.APIGatewayProxyRequest)
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "OK!"})
+			},
+		},
+	}
+	r := mux.NewRouter()
+	api := New(r, f, config.APIGW{ID: "unit-test"})
+	api.IdentityProvider = cognito.New([]config.UserPool{{ID: pool, Clients: []string{"web"}}}, map[string]config.MockData{
+		"my-mock-token": {
+			Introspection: map[string]any{"active": true, "scope": "pets/read", "client_id": "web"},
+			Userinfo:      map[string]any{"sub": "mock-user", "email": "mock@example.com"},
+		},
+		"my-inactive-token": {Introspection: map[string]any{"active": false}},
+	})
+	auth := XAmazonAPIGatewayAuthorizer{
+		Type:         "cognito_user_pools",
+		ProviderARNs: []string{"arn:aws:cognito-idp:us-east-1:123456789012:userpool/" + pool},
+	}
+	echo := api.LambdaProxy("arn:aws:lambda:us-east-1:123456789012:function:echo")
+	withoutScopes := api.CognitoAuthorizer(auth, nil, echo)
+	withScopes := api.CognitoAuthorizer(auth, []string{"pets/write", "pets/read"}, echo)
+
+	sign := func(claims jwt.MapClaims) string {
+		base := jwt.MapClaims{"iss": cognito.Issuer(pool), "exp": time.Now().Add(time.Hour).Unix(), "sub": "user-1"}
+		for k, v := range claims {
+			base[k] = v
+		}
+		token, err := cognito.Sign(base)
+		require.NoError(t, err)
+		return token
+	}
+	idToken := sign(jwt.MapClaims{"token_use": "id", "aud": "web", "email": "user@example.com"})
+	accessToken := sign(jwt.MapClaims{"token_use": "access", "client_id": "web", "scope": "openid pets/read"})
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		token    string
+		status   int
+		expected string
+	}{
+		{name: "missing token", handler: withoutScopes, status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "id token", handler: withoutScopes, token: idToken, status: http.StatusOK, expected: "OK!"},
+		{name: "bearer id token", handler: withoutScopes, token: "Bearer " + idToken, status: http.StatusOK, expected: "OK!"},
+		{name: "access token without scopes", handler: withoutScopes, token: accessToken, status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "access token with scopes", handler: withScopes, token: accessToken, status: http.StatusOK, expected: "OK!"},
+		{name: "id token with scopes", handler: withScopes, token: idToken, status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "missing scope", handler: withScopes, token: sign(jwt.MapClaims{"token_use": "access", "client_id": "web", "scope": "openid"}), status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "unknown client", handler: withoutScopes, token: sign(jwt.MapClaims{"token_use": "id", "aud": "mobile"}), status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "other pool", handler: withoutScopes, token: sign(jwt.MapClaims{"token_use": "id", "aud": "web", "iss": cognito.Issuer("us-east-1_other")}), status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "expired token", handler: withoutScopes, token: sign(jwt.MapClaims{"token_use": "id", "aud": "web", "exp": time.Now().Add(-time.Minute).Unix()}), status: http.StatusUnauthorized, expected: `{"message":"The incoming token has expired"}`},
+		{name: "tampered token", handler: withoutScopes, token: idToken + "x", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "mock data token", handler: withScopes, token: "my-mock-token", status: http.StatusOK, expected: "OK!"},
+		{name: "inactive mock data token", handler: withoutScopes, token: "my-inactive-token", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/pets", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			tt.handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.expected, rec.Body.String())
+		})
+	}
+
+	t.Run("claims are passed to the integration", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/pets", nil)
+		req.Header.Set("Authorization", "my-mock-token")
+		withoutScopes.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		claims := proxyEvent.RequestContext.Authorizer["claims"].(map[string]any)
+		assert.Equal(t, "mock-user", claims["sub"])
+		assert.Equal(t, "mock@example.com", claims["email"])
+		assert.Equal(t, "pets/read", claims["scope"])
+
+		rec = httptest.NewRecorder()
+		req.Header.Set("Authorization", idToken)
+		withoutScopes.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		claims = proxyEvent.RequestContext.Authorizer["claims"].(map[string]any)
+		assert.Equal(t, "user@example.com", claims["email"])
+		assert.Equal(t, "id", claims["token_use"])
+		assert.IsType(t, "", claims["exp"])
+	})
+}
+
+func Test_ImportCognito(t *testing.T) {
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/cognito.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	api := New(r, &mockFactory{}, config.APIGW{ID: "cognito"})
+	api.IdentityProvider = cognito.New(nil, map[string]config.MockData{
+		"reader": {Introspection: map[string]any{"active": true, "scope": "pets/read"}, Userinfo: map[string]any{"email": "reader@example.com"}},
+		"writer": {Introspection: map[string]any{"active": true, "scope": "pets/write"}},
+	})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, path, token string
+		status            int
+		expected          string
+	}{
+		{name: "claims are available to mapping templates", path: "/cognito/profile", token: "reader", status: http.StatusOK, expected: `{"email": "reader@example.com"}`},
+		{name: "method scopes are checked", path: "/cognito/pets", token: "reader", status: http.StatusOK, expected: `[]`},
+		{name: "tokens without the method scopes are unauthorized", path: "/cognito/pets", token: "writer", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "requests without a token are unauthorized", path: "/cognito/pets", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+		})
+	}
+}
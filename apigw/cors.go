@@ -0,0 +1,54 @@
+package apigw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/mw"
+)
+
+const corsExtension = "x-amazon-apigateway-cors"
+
+type XAmazonAPIGatewayCORS struct {
+	AllowOrigins     []string `json:"allowOrigins,omitempty" yaml:"allowOrigins,omitempty"`
+	AllowMethods     []string `json:"allowMethods,omitempty" yaml:"allowMethods,omitempty"`
+	AllowHeaders     []string `json:"allowHeaders,omitempty" yaml:"allowHeaders,omitempty"`
+	ExposeHeaders    []string `json:"exposeHeaders,omitempty" yaml:"exposeHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty" yaml:"allowCredentials,omitempty"`
+	MaxAge           int      `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+}
+
+// corsConfiguration reads the CORS configuration of an HTTP API, REST APIs define OPTIONS mock
+// integrations instead and have no configuration.
+func corsConfiguration(spec *openapi3.T) (*config.CORS, error) {
+	ext, ok := spec.Extensions[corsExtension]
+	if !ok {
+		return nil, nil
+	}
+	var data XAmazonAPIGatewayCORS
+	if err := decodeExtension(ext, &data); err != nil {
+		return nil, fmt.Errorf("unable to parse %s extension error: %w", corsExtension, err)
+	}
+	return &config.CORS{
+		AllowOrigins:     data.AllowOrigins,
+		AllowMethods:     data.AllowMethods,
+		AllowHeaders:     data.AllowHeaders,
+		ExposeHeaders:    data.ExposeHeaders,
+		AllowCredentials: data.AllowCredentials,
+		MaxAge:           data.MaxAge,
+	}, nil
+}
+
+// corsMiddleware applies the CORS configuration of the api, it is looked up per request as
+// the spec is imported after the api has been mounted.
+func (api *API) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.cors == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mw.CORS(*api.cors)(next).ServeHTTP(w, r)
+	})
+}
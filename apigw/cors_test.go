@@ -0,0 +1,111 @@
+package apigw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportCORS(t *testing.T) {
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+
+	rest, err := openapi3.NewLoader().LoadFromFile("examples/cors.yml")
+	require.NoError(t, err)
+	require.NoError(t, rest.Validate(context.Background()))
+	require.NoError(t, New(r, &mockFactory{}, config.APIGW{ID: "rest"}).Import(rest))
+
+	httpAPI, err := openapi3.NewLoader().LoadFromFile("examples/cors.yml")
+	require.NoError(t, err)
+	httpAPI.Extensions[corsExtension] = map[string]any{
+		"allowOrigins":     []any{"http://localhost:5173"},
+		"allowMethods":     []any{"GET", "POST"},
+		"allowHeaders":     []any{"content-type"},
+		"allowCredentials": true,
+		"maxAge":           600,
+	}
+	require.NoError(t, New(r, &mockFactory{}, config.APIGW{ID: "http"}).Import(httpAPI))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, method, path string
+		headers            map[string]string
+		status             int
+		expectedHeaders    map[string]string
+	}{
+		{
+			name:    "rest apis answer preflights with the options mock integration",
+			method:  http.MethodOptions,
+			path:    "/rest/pets",
+			headers: map[string]string{"Origin": "http://localhost:5173", "Access-Control-Request-Method": "GET"},
+			status:  http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET,OPTIONS",
+				"Access-Control-Allow-Headers": "Content-Type,Authorization",
+			},
+		},
+		{
+			name:            "rest apis do not add cors headers to other methods",
+			method:          http.MethodGet,
+			path:            "/rest/pets",
+			headers:         map[string]string{"Origin": "http://localhost:5173"},
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "http apis answer preflights from the cors configuration",
+			method:  http.MethodOptions,
+			path:    "/http/anything",
+			headers: map[string]string{"Origin": "http://localhost:5173", "Access-Control-Request-Method": "POST"},
+			status:  http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:5173",
+				"Access-Control-Allow-Methods":     "GET,POST",
+				"Access-Control-Allow-Headers":     "content-type",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name:            "http apis add cors headers to responses",
+			method:          http.MethodGet,
+			path:            "/http/pets",
+			headers:         map[string]string{"Origin": "http://localhost:5173"},
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "http://localhost:5173"},
+		},
+		{
+			name:            "http apis ignore other origins",
+			method:          http.MethodGet,
+			path:            "/http/pets",
+			headers:         map[string]string{"Origin": "https://example.com"},
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			for k, v := range tt.expectedHeaders {
+				assert.Equal(t, v, resp.Header.Get(k), k)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package apigw
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	integrationExtension = "x-amazon-apigateway-integration"
+	authorizerExtension  = "x-amazon-apigateway-authorizer"
+)
+
+// supportedRootExtensions are the extensions of the spec root that are honoured on import.
+var supportedRootExtensions = []string{
+	apiKeySourceExtension,
+	binaryMediaTypesExtension,
+	corsExtension,
+	gatewayResponsesExtension,
+	requestValidatorExtension,
+	requestValidatorsExtension,
+}
+
+// supportedAuthorizerTypes are the authorizer types that are honoured on import, other types are
+// invoked as lambda request authorizers.
+var supportedAuthorizerTypes = []string{"token", "request", "cognito_user_pools"}
+
+// supportedMethods are the http methods of API Gateway, operations of other methods are not registered.
+var supportedMethods = []string{
+	"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT",
+}
+
+// Diagnostic reports a construct of the spec that was skipped or only partially supported during import.
+type Diagnostic struct {
+	// Path and Method locate the operation within the spec, they are empty for constructs of the spec root.
+	Path        string `json:"path,omitempty"`
+	Method      string `json:"method,omitempty"`
+	OperationID string `json:"operationId,omitempty"`
+	// Feature is the extension, property or scheme that was not supported, such as x-amazon-apigateway-integration.type.
+	Feature string `json:"feature"`
+	Reason  string `json:"reason"`
+}
+
+func (d Diagnostic) String() string {
+	location := "spec"
+	if d.Path != "" {
+		location = d.Method + " " + d.Path
+	}
+	if d.OperationID != "" {
+		location += " (" + d.OperationID + ")"
+	}
+	return fmt.Sprintf("%s: %s %s", location, d.Feature, d.Reason)
+}
+
+// Diagnostics are the constructs of the imported spec that were skipped or only partially supported.
+func (api *API) Diagnostics() []Diagnostic {
+	return api.diagnostics
+}
+
+func (api *API) diagnose(path, method string, op *openapi3.Operation, feature, reason string) {
+	d := Diagnostic{Path: path, Method: method, Feature: feature, Reason: reason}
+	if op != nil {
+		d.OperationID = op.OperationID
+	}
+	log.Warn().Str("apid_id", api.ID).Str("path", path).Str("method", method).Str("operation_id", d.OperationID).
+		Str("feature", feature).Msg(reason)
+	api.diagnostics = append(api.diagnostics, d)
+}
+
+// checkRootExtensions reports the API Gateway extensions of the spec root that are not supported.
+func (api *API) checkRootExtensions(spec *openapi3.T) {
+	for _, k := range sortedKeys(spec.Extensions) {
+		if strings.HasPrefix(k, "x-amazon-apigateway-") && !contains(supportedRootExtensions, k) {
+			api.diagnose("", "", nil, k, "the extension is not supported and was ignored")
+		}
+	}
+}
+
+// checkIntegration reports the integration properties that are not supported and unknown integration types.
+func (api *API) checkIntegration(path, method string, op *openapi3.Operation, ext any, data XAmazonApigatewayIntegration) {
+	var fields map[string]any
+	if err := decodeExtension(ext, &fields); err == nil {
+		known := jsonFields(reflect.TypeOf(data))
+		for _, k := range sortedKeys(fields) {
+			if !known[k] {
+				api.diagnose(path, method, op, integrationExtension+"."+k, "the integration property is not supported and was ignored")
+			}
+		}
+	}
+	switch strings.ToLower(data.Type) {
+	case "aws", "aws_proxy", "http", "http_proxy", "mock", "":
+	default:
+		api.diagnose(path, method, op, integrationExtension+".type", fmt.Sprintf("the integration type %s is not supported and is treated as aws_proxy", data.Type))
+	}
+}
+
+// checkAuthorizer reports authorizers of types that are not supported.
+func (api *API) checkAuthorizer(path, method string, op *openapi3.Operation, name string, auth XAmazonAPIGatewayAuthorizer) {
+	if auth.Type != "" && !contains(supportedAuthorizerTypes, strings.ToLower(auth.Type)) {
+		api.diagnose(path, method, op, authorizerExtension+"."+name, fmt.Sprintf("the authorizer type %s is not supported and is invoked as a request authorizer", auth.Type))
+	}
+}
+
+// checkSecuritySchemes reports the schemes of the security requirements that are not defined by the spec, the
+// operation cannot be secured without them.
+func (api *API) checkSecuritySchemes(spec *openapi3.T, path, method string, op *openapi3.Operation, secReqs openapi3.SecurityRequirements) bool {
+	ok := true
+	for _, req := range secReqs {
+		for _, name := range sortedKeys(req) {
+			if spec.Components == nil || spec.Components.SecuritySchemes[name] == nil {
+				api.diagnose(path, method, op, "security."+name, "the security scheme is not defined in components.securitySchemes, the operation was not registered")
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// jsonFields are the json names of the fields of the struct.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = true
+	}
+	return fields
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package apigw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportDiagnostics(t *testing.T) {
+	const integration = `
+      x-amazon-apigateway-integration:
+        uri: "arn:aws:lambda:us-east-1:123456789012:function:simple"
+        type: "aws_proxy"`
+	tests := []struct {
+		name     string
+		spec     string
+		expected []Diagnostic
+		// status is the status of a GET request to /pets, operations that were not registered are not found
+		status int
+	}{
+		{
+			name: "ignored method",
+			spec: `
+paths:
+  /pets:
+    trace:
+      operationId: tracePets
+      responses:
+        '200':
+          description: OK` + integration,
+			expected: []Diagnostic{
+				{Path: "/pets", Method: "TRACE", OperationID: "tracePets", Feature: "method", Reason: "the method is not supported by API Gateway and was not registered"},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "missing security scheme",
+			spec: `
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      security:
+        - missing: []
+      responses:
+        '200':
+          description: OK` + integration,
+			expected: []Diagnostic{
+				{Path: "/pets", Method: "GET", OperationID: "listPets", Feature: "security.missing", Reason: "the security scheme is not defined in components.securitySchemes, the operation was not registered"},
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "security scheme without an authorizer",
+			spec: `
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      security:
+        - bearer: []
+      responses:
+        '200':
+          description: OK` + integration + `
+components:
+  securitySchemes:
+    bearer:
+      type: http
+      scheme: bearer`,
+			expected: []Diagnostic{
+				{Path: "/pets", Method: "GET", OperationID: "listPets", Feature: "security.bearer", Reason: "the security scheme has no authorizer and is not enforced"},
+			},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := openapi3.NewLoader().LoadFromData([]byte("openapi: 3.0.1\ninfo:\n  title: diagnostics\n  version: \"1\"" + tt.spec))
+			require.NoError(t, err)
+			f := &mockFactory{responses: map[string]func(payload any) ([]byte, error){
+				"arn:aws:lambda:us-east-1:123456789012:function:simple": func(_ any) ([]byte, error) {
+					return []byte(`{"statusCode":200}`), nil
+				},
+			}}
+			r := mux.NewRouter().Host(apiHostName).Subrouter()
+			api := New(r, f, config.APIGW{ID: "diagnostics"})
+			require.NoError(t, api.Import(doc))
+			assert.Equal(t, tt.expected, api.Diagnostics())
+
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/diagnostics/pets", nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	assert.Equal(t, "spec: x-amazon-apigateway-policy the extension is not supported and was ignored",
+		Diagnostic{Feature: "x-amazon-apigateway-policy", Reason: "the extension is not supported and was ignored"}.String())
+	assert.Equal(t, "GET /pets (listPets): security.missing is not defined",
+		Diagnostic{Path: "/pets", Method: "GET", OperationID: "listPets", Feature: "security.missing", Reason: "is not defined"}.String())
+}
@@ -0,0 +1,47 @@
+package apigw
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/rs/zerolog/log"
+)
+
+// AddDomain serves the mapped api stages from the custom domain, each mapping is mounted
+// under its base path which is stripped before the request reaches the integration.
+func AddDomain(router *mux.Router, domain config.Domain, apis map[string]*API) error {
+	mappings := make([]config.BasePathMapping, len(domain.Mappings))
+	copy(mappings, domain.Mappings)
+	// the most specific base paths have to be registered first, so they are matched before an empty base path
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return len(strings.Trim(mappings[i].BasePath, "/")) > len(strings.Trim(mappings[j].BasePath, "/"))
+	})
+	for _, m := range mappings {
+		api, ok := apis[m.API]
+		if !ok {
+			return fmt.Errorf("domain %s maps to unknown api %s", domain.Name, m.API)
+		}
+		rt := router.Host(domain.Name)
+		if basePath := strings.Trim(m.BasePath, "/"); basePath != "" && basePath != "(none)" {
+			prefix := fmt.Sprintf("/%s", basePath)
+			rt = rt.PathPrefix(prefix).MatcherFunc(segmentPrefix(prefix))
+		}
+		if err := api.Mount(rt, m.Stage); err != nil {
+			return fmt.Errorf("unable to map domain %s: %w", domain.Name, err)
+		}
+		log.Info().Str("domain", domain.Name).Str("base_path", m.BasePath).Str("apid_id", m.API).Str("stage", m.Stage).Msg("added base path mapping")
+	}
+	return nil
+}
+
+// segmentPrefix matches the base path and the paths below it, so a base path of orders does not match
+// /orders-archive and the request can reach the other mappings of the domain.
+func segmentPrefix(prefix string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		return r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")
+	}
+}
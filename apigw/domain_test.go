@@ -0,0 +1,122 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExecuteAPIAndCustomDomains(t *testing.T) {
+	var received []events.APIGatewayProxyRequest
+	handler := func(payload any) ([]byte, error) {
+		event, ok := payload.(events.APIGatewayProxyRequest)
+		require.Truef(t, ok, "event must be events.APIGatewayProxyRequest")
+		received = append(received, event)
+		return json.Marshal(&events.APIGatewayProxyResponse{Body: event.RequestContext.APIID + "/" + event.RequestContext.Stage, StatusCode: http.StatusOK})
+	}
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple-dev":  handler,
+			"arn:aws:lambda:us-east-1:123456789012:function:simple-prod": handler,
+			"arn:aws:lambda:us-east-1:123456789012:function:simple":      handler,
+		},
+	}
+
+	router := mux.NewRouter()
+	apiRouter := router.Host(apiHostName).Subrouter()
+	apis := map[string]*API{}
+	for _, tt := range []struct {
+		conf config.APIGW
+		spec string
+	}{
+		{
+			conf: config.APIGW{ID: "orders", Stages: []config.APIStage{
+				{Name: "dev", Variables: map[string]string{"fn": "simple-dev"}},
+				{Name: "prod", Variables: map[string]string{"fn": "simple-prod"}},
+			}},
+			spec: "examples/stage-variables.yml",
+		},
+		{
+			conf: config.APIGW{ID: "users"},
+			spec: "examples/simple.yml",
+		},
+		{
+			conf: config.APIGW{ID: "archive"},
+			spec: "examples/archive.yml",
+		},
+	} {
+		api := New(apiRouter, f, tt.conf)
+		require.NoError(t, api.MountExecuteAPI(router))
+		doc, err := openapi3.NewLoader().LoadFromFile(tt.spec)
+		require.NoError(t, err)
+		require.NoError(t, api.Import(doc))
+		apis[tt.conf.ID] = api
+	}
+	require.NoError(t, AddDomain(router, config.Domain{
+		Name: "example.127.0.0.1.nip.io",
+		Mappings: []config.BasePathMapping{
+			{BasePath: "/", API: "users"},
+			{BasePath: "orders", API: "orders", Stage: "prod"},
+		},
+	}, apis))
+	require.NoError(t, AddDomain(router, config.Domain{
+		Name: "boundary.127.0.0.1.nip.io",
+		Mappings: []config.BasePathMapping{
+			{BasePath: "orders", API: "orders", Stage: "prod"},
+			{BasePath: "(none)", API: "archive"},
+		},
+	}, apis))
+	require.Error(t, AddDomain(router, config.Domain{
+		Name:     "missing.127.0.0.1.nip.io",
+		Mappings: []config.BasePathMapping{{BasePath: "orders", API: "orders", Stage: "staging"}},
+	}, apis))
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	tests := []struct {
+		host, path, expected string
+		// eventPath is the path of the event, /simple when empty
+		eventPath string
+	}{
+		{host: "orders.execute-api.127.0.0.1.nip.io", path: "/dev/simple", expected: "orders/dev"},
+		{host: "orders.execute-api.eu-west-2.amazonaws.com", path: "/prod/simple", expected: "orders/prod"},
+		{host: "users.execute-api.127.0.0.1.nip.io", path: "/simple", expected: "users/"},
+		{host: "example.127.0.0.1.nip.io", path: "/orders/simple", expected: "orders/prod"},
+		{host: "example.127.0.0.1.nip.io", path: "/simple", expected: "users/"},
+		{host: "boundary.127.0.0.1.nip.io", path: "/orders/simple", expected: "orders/prod"},
+		{host: "boundary.127.0.0.1.nip.io", path: "/orders-archive/report", expected: "archive/", eventPath: "/orders-archive/report"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			received = nil
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fmt.Sprintf("%s%s", srv.URL, tt.path), nil)
+			require.NoError(t, err)
+			req.Host = tt.host
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(b))
+			require.Len(t, received, 1)
+			eventPath := tt.eventPath
+			if eventPath == "" {
+				eventPath = "/simple"
+			}
+			assert.Equal(t, eventPath, received[0].Path)
+			assert.Equal(t, tt.host, received[0].RequestContext.DomainName)
+		})
+	}
+}
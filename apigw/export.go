@@ -0,0 +1,166 @@
+package apigw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/invopop/yaml"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// diagnosticsExtension lists the diagnostics reported during import, such as constructs of the spec that
+	// were ignored or security schemes that are not enforced.
+	diagnosticsExtension = "x-gostack-diagnostics"
+	// stageExtension describes the stage of an exported api.
+	stageExtension  = "x-gostack-stage"
+	exportTypeOAS30 = "oas30"
+)
+
+// exportedOperation is an operation as it was registered, kept to export the effective configuration of the api.
+type exportedOperation struct {
+	method, path string
+	operation    *openapi3.Operation
+	parameters   openapi3.Parameters
+	integration  XAmazonApigatewayIntegration
+	validator    string
+	security     openapi3.SecurityRequirements
+}
+
+// Export is the effective configuration of a stage of the api as an OpenAPI 3 document, the operations have the
+// x-amazon-apigateway extensions of the integrations and validators they were registered with. The diagnostics of
+// the import, such as constructs of the spec that were ignored, are listed in x-gostack-diagnostics. The stage of
+// apis without stages is unnamed and matches any name.
+func (api *API) Export(stage string) (*openapi3.T, error) {
+	var s *Stage
+	for _, st := range api.stages {
+		if st.Name == stage || st.Name == "" {
+			s = st
+			break
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("api %s has no stage named '%s'", api.ID, stage)
+	}
+	components := openapi3.NewComponents()
+	doc := &openapi3.T{
+		OpenAPI:    "3.0.1",
+		Info:       &openapi3.Info{Title: api.ID},
+		Paths:      openapi3.Paths{},
+		Components: &components,
+		Extensions: map[string]any{},
+	}
+	if api.spec != nil {
+		if api.spec.Info != nil {
+			info := *api.spec.Info
+			doc.Info = &info
+		}
+		if api.spec.Components != nil {
+			doc.Components = api.spec.Components
+		}
+		for _, k := range supportedRootExtensions {
+			if v, ok := api.spec.Extensions[k]; ok {
+				doc.Extensions[k] = v
+			}
+		}
+	}
+
+	server := &openapi3.Server{URL: fmt.Sprintf("https://%s.execute-api.{region}.amazonaws.com", api.ID), Variables: map[string]*openapi3.ServerVariable{
+		"region": {Default: defaultRegion},
+	}}
+	if s.Name != "" {
+		server.URL += "/{basePath}"
+		server.Variables["basePath"] = &openapi3.ServerVariable{Default: s.Name}
+	}
+	doc.Servers = openapi3.Servers{server}
+	throttling := map[string]any{}
+	for k, t := range s.throttling {
+		throttling[k] = map[string]any{"throttlingRateLimit": t.RateLimit, "throttlingBurstLimit": t.BurstLimit}
+	}
+	doc.Extensions[stageExtension] = map[string]any{"name": s.Name, "variables": s.Variables, "throttling": throttling}
+
+	for _, e := range api.exports {
+		item, ok := doc.Paths[e.path]
+		if !ok {
+			item = &openapi3.PathItem{Extensions: map[string]any{}}
+			doc.Paths[e.path] = item
+		}
+		op := &openapi3.Operation{
+			Tags:        e.operation.Tags,
+			Summary:     e.operation.Summary,
+			Description: e.operation.Description,
+			OperationID: e.operation.OperationID,
+			Parameters:  e.parameters,
+			RequestBody: e.operation.RequestBody,
+			Responses:   e.operation.Responses,
+			Extensions:  map[string]any{integrationExtension: e.integration},
+		}
+		if op.Responses == nil {
+			op.Responses = openapi3.NewResponses()
+		}
+		if len(e.security) > 0 {
+			security := e.security
+			op.Security = &security
+		}
+		if e.validator != "" {
+			op.Extensions[requestValidatorExtension] = e.validator
+		}
+		if e.method == anyMethod {
+			item.Extensions[anyMethodExtension] = op
+			continue
+		}
+		item.SetOperation(e.method, op)
+	}
+	if len(api.diagnostics) > 0 {
+		doc.Extensions[diagnosticsExtension] = api.diagnostics
+	}
+	return doc, nil
+}
+
+// MarshalExport encodes an exported document as JSON, or as YAML when yamlFormat is set.
+func MarshalExport(doc *openapi3.T, yamlFormat bool) ([]byte, error) {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil || !yamlFormat {
+		return b, err
+	}
+	return yaml.JSONToYAML(b)
+}
+
+// ExportHandler serves the exports of the apis as the GetExport api of API Gateway does, from
+// GET /restapis/{id}/stages/{stage}/exports/oas30. Documents are YAML when application/yaml is accepted.
+func ExportHandler(apis map[string]*API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		api, ok := apis[vars["id"]]
+		if !ok {
+			writeManagementError(w, http.StatusNotFound, "NotFoundException", fmt.Sprintf("Invalid API identifier specified %s:%s", accountID, vars["id"]))
+			return
+		}
+		if vars["type"] != exportTypeOAS30 {
+			writeManagementError(w, http.StatusBadRequest, "BadRequestException", fmt.Sprintf("Export type %s is not supported", vars["type"]))
+			return
+		}
+		doc, err := api.Export(vars["stage"])
+		if err != nil {
+			writeManagementError(w, http.StatusNotFound, "NotFoundException", fmt.Sprintf("Invalid stage identifier specified %s:%s:%s", accountID, vars["id"], vars["stage"]))
+			return
+		}
+		yamlFormat := strings.Contains(r.Header.Get("Accept"), "yaml")
+		b, err := MarshalExport(doc, yamlFormat)
+		if err != nil {
+			log.Error().Err(err).Str("apid_id", api.ID).Msg("unable to marshal the export")
+			writeManagementError(w, http.StatusInternalServerError, "InternalFailure", "Internal server error")
+			return
+		}
+		contentType := defaultContentType
+		if yamlFormat {
+			contentType = "application/yaml"
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,116 @@
+package apigw
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/invopop/yaml"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Export(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/export.yml")
+	require.NoError(t, err)
+	api := New(mux.NewRouter(), &mockFactory{}, config.APIGW{ID: "export", Stages: []config.APIStage{
+		{Name: "dev", Variables: map[string]string{"env": "dev"}},
+	}})
+	require.NoError(t, api.Import(doc))
+
+	_, err = api.Export("prod")
+	assert.EqualError(t, err, "api export has no stage named 'prod'")
+
+	export, err := api.Export("dev")
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.1", export.OpenAPI)
+	assert.Equal(t, "Export Example", export.Info.Title)
+	assert.Equal(t, "https://export.execute-api.{region}.amazonaws.com/{basePath}", export.Servers[0].URL)
+	assert.Equal(t, "dev", export.Servers[0].Variables["basePath"].Default)
+	assert.Contains(t, export.Extensions, requestValidatorsExtension)
+	assert.NotContains(t, export.Extensions, "x-amazon-apigateway-policy")
+	assert.Equal(t, map[string]string{"env": "dev"}, export.Extensions[stageExtension].(map[string]any)["variables"])
+
+	get := export.Paths.Find("/pets").Get
+	require.NotNil(t, get)
+	assert.Equal(t, "params-only", get.Extensions[requestValidatorExtension])
+	assert.Equal(t, "arn:aws:lambda:us-east-1:123456789012:function:simple", get.Extensions[integrationExtension].(XAmazonApigatewayIntegration).URI)
+	post := export.Paths.Find("/pets").Post
+	require.NotNil(t, post)
+	assert.Equal(t, openapi3.SecurityRequirements{{"jwt": []string{}}}, *post.Security)
+	assert.Nil(t, export.Paths.Find("/pets").Delete)
+	anyOp, ok := export.Paths.Find("/pets/{id}").Extensions[anyMethodExtension].(*openapi3.Operation)
+	require.True(t, ok)
+	assert.Equal(t, "id", anyOp.Parameters[0].Value.Name)
+
+	assert.Equal(t, []Diagnostic{
+		{Feature: "x-amazon-apigateway-policy", Reason: "the extension is not supported and was ignored"},
+		{Path: "/pets", Method: "DELETE", OperationID: "deletePets", Feature: integrationExtension, Reason: "the operation has no integration and was not registered"},
+		{Path: "/pets", Method: "GET", OperationID: "listPets", Feature: integrationExtension + ".connectionType", Reason: "the integration property is not supported and was ignored"},
+		{Path: "/pets", Method: "POST", OperationID: "createPet", Feature: integrationExtension + ".type", Reason: "the integration type aws_vpc is not supported and is treated as aws_proxy"},
+		{Path: "/pets", Method: "POST", OperationID: "createPet", Feature: authorizerExtension + ".jwt", Reason: "the authorizer type jwt is not supported and is invoked as a request authorizer"},
+	}, export.Extensions[diagnosticsExtension])
+
+	// the export can be imported again
+	b, err := MarshalExport(export, false)
+	require.NoError(t, err)
+	reimported, err := openapi3.NewLoader().LoadFromData(b)
+	require.NoError(t, err)
+	require.NoError(t, New(mux.NewRouter(), &mockFactory{}, config.APIGW{ID: "reimported"}).Import(reimported))
+}
+
+func Test_ExportHandler(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/simple.yml")
+	require.NoError(t, err)
+	api := New(mux.NewRouter(), &mockFactory{}, config.APIGW{ID: "simple"})
+	require.NoError(t, api.Import(doc))
+	r := mux.NewRouter()
+	r.Path("/restapis/{id}/stages/{stage}/exports/{type}").Handler(ExportHandler(map[string]*API{"simple": api}))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		name, path, accept string
+		status             int
+		errorType          string
+		contentType        string
+	}{
+		{name: "json", path: "/restapis/simple/stages/dev/exports/oas30", status: http.StatusOK, contentType: "application/json"},
+		{name: "yaml", path: "/restapis/simple/stages/dev/exports/oas30", accept: "application/yaml", status: http.StatusOK, contentType: "application/yaml"},
+		{name: "unknown api", path: "/restapis/missing/stages/dev/exports/oas30", status: http.StatusNotFound, errorType: "NotFoundException"},
+		{name: "unsupported type", path: "/restapis/simple/stages/dev/exports/swagger", status: http.StatusBadRequest, errorType: "BadRequestException"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.errorType, resp.Header.Get("X-Amzn-ErrorType"))
+			if tt.contentType == "" {
+				return
+			}
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tt.contentType == "application/yaml" {
+				body, err = yaml.YAMLToJSON(body)
+				require.NoError(t, err)
+			}
+			var export map[string]any
+			require.NoError(t, json.Unmarshal(body, &export))
+			assert.Equal(t, "3.0.1", export["openapi"])
+			assert.Contains(t, export["paths"], "/simple")
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package apigw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/iwarapter/gostack/internal/vtl"
+	"github.com/rs/zerolog/log"
+)
+
+const gatewayResponsesExtension = "x-amazon-apigateway-gateway-responses"
+
+// gatewayResponseType is the type of an error response generated by API Gateway itself.
+type gatewayResponseType string
+
+const (
+	accessDenied                 gatewayResponseType = "ACCESS_DENIED"
+	apiConfigurationError        gatewayResponseType = "API_CONFIGURATION_ERROR"
+	authorizerConfigurationError gatewayResponseType = "AUTHORIZER_CONFIGURATION_ERROR"
+	authorizerFailure            gatewayResponseType = "AUTHORIZER_FAILURE"
+	badRequestParameters         gatewayResponseType = "BAD_REQUEST_PARAMETERS"
+	badRequestBody               gatewayResponseType = "BAD_REQUEST_BODY"
+	default4XX                   gatewayResponseType = "DEFAULT_4XX"
+	default5XX                   gatewayResponseType = "DEFAULT_5XX"
+	expiredToken                 gatewayResponseType = "EXPIRED_TOKEN"
+	integrationFailure           gatewayResponseType = "INTEGRATION_FAILURE"
+	integrationTimeout           gatewayResponseType = "INTEGRATION_TIMEOUT"
+	invalidAPIKey                gatewayResponseType = "INVALID_API_KEY"
+	invalidSignature             gatewayResponseType = "INVALID_SIGNATURE"
+	missingAuthenticationToken   gatewayResponseType = "MISSING_AUTHENTICATION_TOKEN"
+	quotaExceeded                gatewayResponseType = "QUOTA_EXCEEDED"
+	requestTooLarge              gatewayResponseType = "REQUEST_TOO_LARGE"
+	resourceNotFound             gatewayResponseType = "RESOURCE_NOT_FOUND"
+	throttled                    gatewayResponseType = "THROTTLED"
+	unauthorized                 gatewayResponseType = "UNAUTHORIZED"
+	unsupportedMediaType         gatewayResponseType = "UNSUPPORTED_MEDIA_TYPE"
+	wafFiltered                  gatewayResponseType = "WAF_FILTERED"
+)
+
+// gatewayResponseStatus are the default status codes of each gateway response type.
+var gatewayResponseStatus = map[gatewayResponseType]int{
+	accessDenied:                 http.StatusForbidden,
+	apiConfigurationError:        http.StatusInternalServerError,
+	authorizerConfigurationError: http.StatusInternalServerError,
+	authorizerFailure:            http.StatusInternalServerError,
+	badRequestParameters:         http.StatusBadRequest,
+	badRequestBody:               http.StatusBadRequest,
+	default4XX:                   http.StatusBadRequest,
+	default5XX:                   http.StatusInternalServerError,
+	expiredToken:                 http.StatusForbidden,
+	integrationFailure:           http.StatusGatewayTimeout,
+	integrationTimeout:           http.StatusGatewayTimeout,
+	invalidAPIKey:                http.StatusForbidden,
+	invalidSignature:             http.StatusForbidden,
+	missingAuthenticationToken:   http.StatusForbidden,
+	quotaExceeded:                http.StatusTooManyRequests,
+	requestTooLarge:              http.StatusRequestEntityTooLarge,
+	resourceNotFound:             http.StatusNotFound,
+	throttled:                    http.StatusTooManyRequests,
+	unauthorized:                 http.StatusUnauthorized,
+	unsupportedMediaType:         http.StatusUnsupportedMediaType,
+	wafFiltered:                  http.StatusForbidden,
+}
+
+// defaultGatewayTemplate is the response template of gateway responses that are not customised.
+var defaultGatewayTemplate = vtl.MustParse(`{"message":$context.error.messageString}`)
+
+type XAmazonAPIGatewayGatewayResponse struct {
+	StatusCode         string            `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	ResponseParameters map[string]string `json:"responseParameters,omitempty" yaml:"responseParameters,omitempty"`
+	ResponseTemplates  map[string]string `json:"responseTemplates,omitempty" yaml:"responseTemplates,omitempty"`
+}
+
+type gatewayResponse struct {
+	statusCode int
+	parameters map[string]string
+	templates  map[string]*vtl.Template
+}
+
+// gatewayResponses parses the gateway responses customised at the root of the spec.
+func gatewayResponses(spec *openapi3.T) (map[gatewayResponseType]*gatewayResponse, error) {
+	responses := map[gatewayResponseType]*gatewayResponse{}
+	ext, ok := spec.Extensions[gatewayResponsesExtension]
+	if !ok {
+		return responses, nil
+	}
+	data := map[string]XAmazonAPIGatewayGatewayResponse{}
+	if err := decodeExtension(ext, &data); err != nil {
+		return nil, fmt.Errorf("unable to parse %s extension error: %w", gatewayResponsesExtension, err)
+	}
+	for k, v := range data {
+		responseType := gatewayResponseType(strings.ToUpper(k))
+		if _, ok := gatewayResponseStatus[responseType]; !ok {
+			return nil, fmt.Errorf("unknown gateway response type %s", k)
+		}
+		resp := &gatewayResponse{parameters: v.ResponseParameters}
+		if v.StatusCode != "" {
+			code, err := strconv.Atoi(v.StatusCode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status code %s for gateway response %s", v.StatusCode, k)
+			}
+			resp.statusCode = code
+		}
+		templates, err := parseTemplates(v.ResponseTemplates)
+		if err != nil {
+			return nil, fmt.Errorf("gateway response %s: %w", k, err)
+		}
+		resp.templates = templates
+		responses[responseType] = resp
+	}
+	return responses, nil
+}
+
+// gatewayError writes the gateway response for the type with its default status code.
+func (api *API) gatewayError(w http.ResponseWriter, r *http.Request, responseType gatewayResponseType, message string) {
+	api.gatewayErrorStatus(w, r, responseType, gatewayResponseStatus[responseType], message)
+}
+
+// gatewayErrorStatus writes the gateway response for the type, the response customised for the type is used
+// before the DEFAULT_4XX or DEFAULT_5XX responses, and finally the API Gateway default of {"message": message}.
+// An empty message is rendered as null.
+func (api *API) gatewayErrorStatus(w http.ResponseWriter, r *http.Request, responseType gatewayResponseType, status int, message string) {
+	subl := log.With().Str("handler", "apigateway-gateway-response").Str("type", string(responseType)).Logger()
+	recordGatewayError(r, responseType, message)
+	resp, ok := api.gatewayResponses[responseType]
+	if ok && resp.statusCode != 0 {
+		status = resp.statusCode
+	}
+	if !ok {
+		fallback := default4XX
+		if status >= http.StatusInternalServerError {
+			fallback = default5XX
+		}
+		resp = api.gatewayResponses[fallback]
+	}
+	if resp == nil {
+		resp = &gatewayResponse{}
+	}
+
+	messageString := "null"
+	if message != "" {
+		b, _ := json.Marshal(message)
+		messageString = string(b)
+	}
+	vars := api.templateVariables(r, "")
+	vars["context"].(map[string]any)["error"] = map[string]any{
+		"message":       message,
+		"messageString": messageString,
+		"responseType":  string(responseType),
+	}
+
+	for k, source := range resp.parameters {
+		name, ok := strings.CutPrefix(k, "gatewayresponse.header.")
+		if !ok {
+			continue
+		}
+		if value, ok := api.parameterValue(r, source); ok {
+			w.Header().Set(name, value)
+		}
+	}
+	tmpl, contentType := responseTemplate(resp.templates, r.Header.Get("Accept"))
+	if tmpl == nil {
+		tmpl, contentType = defaultGatewayTemplate, defaultContentType
+	}
+	body, err := tmpl.Execute(vars)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to render gateway response")
+		body, _ = defaultGatewayTemplate.Execute(vars)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
+
+// missingRoute is the response to requests that do not match any resource or method of the api.
+func (api *API) missingRoute(w http.ResponseWriter, r *http.Request) {
+	api.gatewayError(w, r, missingAuthenticationToken, "Missing Authentication Token")
+}
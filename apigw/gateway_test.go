@@ -0,0 +1,116 @@
+package apigw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportMockIntegrationsAndGatewayResponses(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/gateway-responses.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, &mockFactory{}, config.APIGW{ID: "unit-test", Stages: []config.APIStage{
+		{Name: "dev", Variables: map[string]string{"name": "development"}},
+	}})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, method, path string
+		status             int
+		expected           string
+		headers            map[string]string
+	}{
+		{
+			name:     "mock integrations respond with the response template",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/health",
+			status:   http.StatusOK,
+			expected: `{"status": "ok", "stage": "dev"}`,
+			headers:  map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:     "mock integration status codes select the integration response",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/health?fail=true",
+			status:   http.StatusServiceUnavailable,
+			expected: `{"status": "unavailable"}`,
+		},
+		{
+			name:     "customised gateway responses are rendered",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/secure",
+			status:   http.StatusUnauthorized,
+			expected: `{"error": "Unauthorized", "type": "UNAUTHORIZED"}`,
+			headers:  map[string]string{"WWW-Authenticate": "Bearer", "X-Request-Path": ""},
+		},
+		{
+			name:     "unknown resources fall back to the default 4xx response",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/unknown",
+			status:   http.StatusForbidden,
+			expected: `{"error": "Missing Authentication Token"}`,
+			headers:  map[string]string{"X-Stage": "development"},
+		},
+		{
+			name:     "unknown methods fall back to the default 4xx response",
+			method:   http.MethodDelete,
+			path:     "/unit-test/dev/_user_request_/health",
+			status:   http.StatusForbidden,
+			expected: `{"error": "Missing Authentication Token"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+			for k, v := range tt.headers {
+				assert.Equal(t, v, resp.Header.Get(k))
+			}
+		})
+	}
+}
+
+func Test_gatewayErrorDefaults(t *testing.T) {
+	api := New(mux.NewRouter(), &mockFactory{}, config.APIGW{ID: "unit-test"})
+	tests := []struct {
+		responseType gatewayResponseType
+		message      string
+		status       int
+		expected     string
+	}{
+		{throttled, "Too Many Requests", http.StatusTooManyRequests, `{"message":"Too Many Requests"}`},
+		{integrationTimeout, "Endpoint request timed out", http.StatusGatewayTimeout, `{"message":"Endpoint request timed out"}`},
+		{missingAuthenticationToken, "Missing Authentication Token", http.StatusForbidden, `{"message":"Missing Authentication Token"}`},
+		{authorizerConfigurationError, "", http.StatusInternalServerError, `{"message":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.responseType), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			api.gatewayError(rec, httptest.NewRequest(http.MethodGet, "/", nil), tt.responseType, tt.message)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.expected, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package apigw
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultIntegrationTimeout is the maximum integration timeout of API Gateway.
+const defaultIntegrationTimeout = 29 * time.Second
+
+var (
+	uriPathParamRx     = regexp.MustCompile(`\{([^}]+)}`)
+	requestParameterRx = regexp.MustCompile(`^integration\.request\.(path|header|querystring)\.(.+)$`)
+)
+
+// hopHeaders are the connection specific headers that are not forwarded by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// httpIntegration is the backend of an http or http_proxy integration, the path, header and querystring
+// parameters of the integration request are mapped from the sources of the requestParameters.
+type httpIntegration struct {
+	method       string
+	uri          string
+	pathParams   map[string]string
+	headers      map[string]string
+	querystrings map[string]string
+	client       *http.Client
+}
+
+func newHTTPIntegration(integration XAmazonApigatewayIntegration, timeout time.Duration) *httpIntegration {
+	params := map[string]map[string]string{"path": {}, "header": {}, "querystring": {}}
+	for k, v := range integration.RequestParameters {
+		if match := requestParameterRx.FindStringSubmatch(k); match != nil {
+			params[match[1]][match[2]] = v
+		}
+	}
+	return &httpIntegration{
+		method:       strings.ToUpper(integration.HTTPMethod),
+		uri:          integration.URI,
+		pathParams:   params["path"],
+		headers:      params["header"],
+		querystrings: params["querystring"],
+		client: &http.Client{
+			Timeout: timeout,
+			// redirects are returned to the client as API Gateway does not follow them
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// endpoint resolves the stage variables and path parameters of the integration uri, path parameters
+// are mapped with requestParameters and default to the method path parameter of the same name.
+func (h *httpIntegration) endpoint(api *API, r *http.Request) (*url.URL, error) {
+	vars := mux.Vars(r)
+	uri := uriPathParamRx.ReplaceAllStringFunc(api.stageFromRequest(r).resolve(h.uri), func(match string) string {
+		name := strings.TrimSuffix(match[1:len(match)-1], "+")
+		value, ok := vars[name]
+		if source, mapped := h.pathParams[name]; mapped {
+			value, ok = api.parameterValue(r, source)
+		}
+		if !ok {
+			return match
+		}
+		return escapePath(value)
+	})
+	return url.Parse(uri)
+}
+
+// mapParameters sets the mapped headers and querystring parameters of the integration request, sources
+// without a value are skipped.
+func (h *httpIntegration) mapParameters(api *API, r, req *http.Request) {
+	for name, source := range h.headers {
+		if value, ok := api.parameterValue(r, source); ok {
+			req.Header.Set(name, value)
+		}
+	}
+	if len(h.querystrings) == 0 {
+		return
+	}
+	query := req.URL.Query()
+	for name, source := range h.querystrings {
+		if value, ok := api.parameterValue(r, source); ok {
+			query.Set(name, value)
+		}
+	}
+	req.URL.RawQuery = query.Encode()
+}
+
+// escapePath escapes each segment of a path parameter, so greedy parameters keep their slashes.
+func escapePath(value string) string {
+	segments := strings.Split(value, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// do sends the request to the integration endpoint, errors are written as the API Gateway response
+// and ok is false.
+func (h *httpIntegration) do(api *API, w http.ResponseWriter, r, req *http.Request, subl zerolog.Logger) (*http.Response, bool) {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			subl.Info().Err(err).Msg("integration timed out")
+			api.gatewayError(w, r, integrationTimeout, "Endpoint request timed out")
+			return nil, false
+		}
+		subl.Error().Err(err).Msg("unable to reach integration endpoint")
+		api.gatewayErrorStatus(w, r, integrationFailure, http.StatusBadGateway, "Internal server error")
+		return nil, false
+	}
+	return resp, true
+}
+
+// HTTPProxyIntegration forwards the request to the integration endpoint and returns its response as is.
+func (api *API) HTTPProxyIntegration(integration XAmazonApigatewayIntegration) (http.HandlerFunc, error) {
+	h := newHTTPIntegration(integration, api.timeout(integration))
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-http-proxy-integration").Logger()
+		endpoint, err := h.endpoint(api, r)
+		if err != nil {
+			subl.Error().Err(err).Msg("invalid integration uri")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return
+		}
+		query := endpoint.Query()
+		for k, v := range r.URL.Query() {
+			query[k] = append(query[k], v...)
+		}
+		endpoint.RawQuery = query.Encode()
+
+		method := h.method
+		if method == "" || method == anyMethod {
+			method = r.Method
+		}
+		req, err := http.NewRequestWithContext(r.Context(), method, endpoint.String(), r.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to create integration request")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return
+		}
+		req.Header = r.Header.Clone()
+		removeHopHeaders(req.Header)
+		req.ContentLength = r.ContentLength
+		h.mapParameters(api, r, req)
+
+		subl = subl.With().Str("endpoint", endpoint.String()).Logger()
+		resp, ok := h.do(api, w, r, req, subl)
+		if !ok {
+			return
+		}
+		defer resp.Body.Close()
+		output, err := api.readResponse(resp.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to read integration response")
+			api.gatewayErrorStatus(w, r, integrationFailure, http.StatusBadGateway, "Internal server error")
+			return
+		}
+		removeHopHeaders(resp.Header)
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(output)
+	}, nil
+}
+
+// HTTPIntegration sends the request produced by the request mapping templates to the integration endpoint,
+// the response is mapped back through the integration responses selected by its status code.
+func (api *API) HTTPIntegration(integration XAmazonApigatewayIntegration) (http.HandlerFunc, error) {
+	m, err := newMappings(integration)
+	if err != nil {
+		return nil, err
+	}
+	h := newHTTPIntegration(integration, api.timeout(integration))
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-http-integration").Logger()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to read body")
+			api.gatewayError(w, r, default5XX, "Internal server error")
+			return
+		}
+		defer r.Body.Close()
+
+		payload, ok := m.request(api, w, r, body, subl)
+		if !ok {
+			return
+		}
+		endpoint, err := h.endpoint(api, r)
+		if err != nil {
+			subl.Error().Err(err).Msg("invalid integration uri")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return
+		}
+		method := h.method
+		if method == "" || method == anyMethod {
+			method = r.Method
+		}
+		req, err := http.NewRequestWithContext(r.Context(), method, endpoint.String(), bytes.NewBufferString(payload))
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to create integration request")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		req.Header.Set("Content-Type", contentType)
+		h.mapParameters(api, r, req)
+
+		subl = subl.With().Str("endpoint", endpoint.String()).Logger()
+		resp, ok := h.do(api, w, r, req, subl)
+		if !ok {
+			return
+		}
+		defer resp.Body.Close()
+		output, err := api.readResponse(resp.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to read integration response")
+			api.gatewayErrorStatus(w, r, integrationFailure, http.StatusBadGateway, "Internal server error")
+			return
+		}
+		m.respond(api, w, r, strconv.Itoa(resp.StatusCode), true, output, resp.Header, api.isBinary(resp.Header.Get("Content-Type")), subl)
+	}, nil
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
@@ -0,0 +1,149 @@
+package apigw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportHTTPIntegrations(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case r.URL.Path == "/tenants":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Backend-Version", "v2")
+			_, _ = w.Write([]byte(`{"tenant":{"name":"` + r.Header.Get("tenant") + `"},"source":"` + r.Header.Get("x-source") + `","query":"` + r.URL.Query().Get("id") + "," + r.URL.Query().Get("stage") + `"}`))
+			return
+		case r.URL.Path == "/orders":
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(body), `"missing"`) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"method":"` + r.Method + `","received":` + string(body) + `}`))
+			return
+		}
+		w.Header().Set("X-Backend-Path", r.URL.EscapedPath())
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RawQuery + " " + r.Header.Get("X-Custom")))
+	}))
+	defer backend.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/http-integration.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, &mockFactory{}, config.APIGW{ID: "unit-test", Stages: []config.APIStage{
+		{Name: "dev", Variables: map[string]string{"backend": strings.TrimPrefix(backend.URL, "http://")}},
+		{Name: "down", Variables: map[string]string{"backend": strings.TrimPrefix(closed.URL, "http://")}},
+	}})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		expected                 string
+		backendPath              string
+		headers                  map[string]string
+	}{
+		{
+			name:        "path parameters are mapped with request parameters",
+			method:      http.MethodGet,
+			path:        "/unit-test/dev/_user_request_/items/a%20b?q=1",
+			status:      http.StatusAccepted,
+			expected:    "GET q=1 custom",
+			backendPath: "/v1/items/a%20b",
+		},
+		{
+			name:        "greedy path parameters keep their slashes and the request method",
+			method:      http.MethodDelete,
+			path:        "/unit-test/dev/_user_request_/proxy/a/b/c",
+			status:      http.StatusAccepted,
+			expected:    "DELETE  custom",
+			backendPath: "/a/b/c",
+		},
+		{
+			name:     "integration timeouts return 504",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/slow",
+			status:   http.StatusGatewayTimeout,
+			expected: `{"message":"Endpoint request timed out"}`,
+		},
+		{
+			name:     "connection errors return 502",
+			method:   http.MethodGet,
+			path:     "/unit-test/down/_user_request_/items/1",
+			status:   http.StatusBadGateway,
+			expected: `{"message":"Internal server error"}`,
+		},
+		{
+			name:     "http integrations apply the mapping templates",
+			method:   http.MethodPost,
+			path:     "/unit-test/dev/_user_request_/orders/42",
+			body:     `{"qty": 3}`,
+			status:   http.StatusCreated,
+			expected: `{"order": {"method":"PUT","received":{"orderId":"42","qty":3}}}`,
+		},
+		{
+			name:     "http integration responses are selected by status code",
+			method:   http.MethodPost,
+			path:     "/unit-test/dev/_user_request_/orders/missing",
+			body:     `{"qty": 1}`,
+			status:   http.StatusNotFound,
+			expected: `{"message": "order not found"}`,
+		},
+		{
+			name:     "request and response parameters are mapped",
+			method:   http.MethodGet,
+			path:     "/unit-test/dev/_user_request_/tenants/t1",
+			status:   http.StatusOK,
+			expected: `{"tenant":{"name":"acme"},"source":"gostack","query":"t1,dev"}`,
+			headers: map[string]string{
+				"Cache-Control": "max-age=60",
+				"X-Version":     "v2",
+				"X-Tenant":      "acme",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Host = apiHostName
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Custom", "custom")
+			req.Header.Set("X-Tenant", "acme")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+			if tt.backendPath != "" {
+				assert.Equal(t, tt.backendPath, resp.Header.Get("X-Backend-Path"))
+			}
+			for k, v := range tt.headers {
+				assert.Equal(t, v, resp.Header.Get(k), k)
+			}
+		})
+	}
+}
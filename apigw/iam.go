@@ -0,0 +1,66 @@
+package apigw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/iwarapter/gostack/internal/sigv4"
+	"github.com/rs/zerolog/log"
+)
+
+// IdentityContext holds the events.APIGatewayRequestIdentity of requests signed with IAM credentials.
+const IdentityContext contextKey = "identity"
+
+const executeAPIService = "execute-api"
+
+// isSigV4Scheme is true for security schemes using IAM authorization.
+func isSigV4Scheme(name string, sec *openapi3.SecuritySchemeRef) bool {
+	if sec.Value == nil {
+		return false
+	}
+	authType, _ := sec.Value.Extensions["x-amazon-apigateway-authtype"].(string)
+	return strings.EqualFold(authType, "awsSigv4") || (name == "sigv4" && authType == "")
+}
+
+// IAMAuthorizer verifies the AWS Signature Version 4 of the request against the configured credentials,
+// the signature must be scoped to the execute-api service in the region of the request. The IAM user of
+// the credentials is passed to the integration as requestContext.identity.
+func (api *API) IAMAuthorizer(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-iam-authorizer").Logger()
+		if r.Header.Get("Authorization") == "" {
+			subl.Info().Msg("missing authorization header")
+			api.gatewayError(w, r, missingAuthenticationToken, "Missing Authentication Token")
+			return
+		}
+		accessKeyID, err := sigv4.Verify(r, requestRegion(r), executeAPIService, sigv4.Secrets(api.Credentials), time.Now())
+		if err != nil {
+			subl.Info().Err(err).Msg("invalid signature")
+			var verr *sigv4.Error
+			if errors.As(err, &verr) && verr.Kind == sigv4.InvalidSignature {
+				api.gatewayError(w, r, invalidSignature, verr.Message)
+				return
+			}
+			api.gatewayErrorStatus(w, r, default4XX, http.StatusForbidden, err.Error())
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), IdentityContext, api.callerIdentity(accessKeyID))))
+	}
+}
+
+// callerIdentity is the identity of the credentials of the access key.
+func (api *API) callerIdentity(accessKeyID string) events.APIGatewayRequestIdentity {
+	id := sigv4.CallerIdentity(api.Credentials, accessKeyID, accountID)
+	return events.APIGatewayRequestIdentity{
+		AccessKey: id.AccessKey,
+		AccountID: id.AccountID,
+		Caller:    id.Caller,
+		User:      id.Caller,
+		UserArn:   id.UserARN,
+	}
+}
@@ -0,0 +1,99 @@
+package apigw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportIAM(t *testing.T) {
+	var proxyEvent events.APIGatewayProxyRequest
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:echo": func(payload any) ([]byte, error) {
+				proxyEvent = payload.(events.APIGatewayProxyRequest)
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: proxyEvent.Body})
+			},
+		},
+	}
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/iam.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	api := New(r, f, config.APIGW{ID: "iam"})
+	api.Credentials = []config.Credential{
+		{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"},
+		{AccessKeyID: "AKIDROLE", SecretAccessKey: "role-secret", Caller: "AROAEXAMPLE:session", UserARN: "arn:aws:sts::210987654321:assumed-role/example/session"},
+	}
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	request := func(accessKeyID, secret, region string, signTime time.Time) *http.Request {
+		body := `{"name":"rex"}`
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/iam/pets", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		req.Host = apiHostName
+		if accessKeyID != "" {
+			signer := v4.NewSigner(credentials.NewStaticCredentials(accessKeyID, secret, ""))
+			_, err = signer.Sign(req, bytes.NewReader([]byte(body)), "execute-api", region, signTime)
+			require.NoError(t, err)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		status   int
+		expected string
+	}{
+		{name: "signed request", req: request("AKIDEXAMPLE", "secret", "us-east-1", time.Now()), status: http.StatusOK, expected: `{"name":"rex"}`},
+		{name: "unsigned request", req: request("", "", "", time.Time{}), status: http.StatusForbidden, expected: `{"message":"Missing Authentication Token"}`},
+		{name: "unknown access key", req: request("AKIDUNKNOWN", "secret", "us-east-1", time.Now()), status: http.StatusForbidden, expected: `{"message":"The security token included in the request is invalid."}`},
+		{name: "wrong secret", req: request("AKIDEXAMPLE", "wrong", "us-east-1", time.Now()), status: http.StatusForbidden, expected: `{"message":"The request signature we calculated does not match the signature you provided. Check your AWS Secret Access Key and signing method. Consult the service documentation for details."}`},
+		{name: "wrong region", req: request("AKIDEXAMPLE", "secret", "eu-west-1", time.Now()), status: http.StatusForbidden, expected: `{"message":"Credential should be scoped to a valid region. "}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.DefaultClient.Do(tt.req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+		})
+	}
+
+	t.Run("the caller identity is passed to the integration", func(t *testing.T) {
+		resp, err := http.DefaultClient.Do(request("AKIDEXAMPLE", "secret", "us-east-1", time.Now()))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "AKIDEXAMPLE", proxyEvent.RequestContext.Identity.AccessKey)
+		assert.Equal(t, "AKIDEXAMPLE", proxyEvent.RequestContext.Identity.Caller)
+		assert.Equal(t, "arn:aws:iam::123456789012:user/AKIDEXAMPLE", proxyEvent.RequestContext.Identity.UserArn)
+
+		resp, err = http.DefaultClient.Do(request("AKIDROLE", "role-secret", "us-east-1", time.Now()))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "AROAEXAMPLE:session", proxyEvent.RequestContext.Identity.Caller)
+		assert.Equal(t, "arn:aws:sts::210987654321:assumed-role/example/session", proxyEvent.RequestContext.Identity.UserArn)
+		assert.Equal(t, "210987654321", proxyEvent.RequestContext.Identity.AccountID)
+	})
+}
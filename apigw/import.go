@@ -1,46 +1,102 @@
 package apigw
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/mitchellh/mapstructure"
 )
 
+const (
+	anyMethod          = "ANY"
+	anyMethodExtension = "x-amazon-apigateway-any-method"
+)
+
+var greedyParamRx = regexp.MustCompile(`\{([^}]+)\+}`)
+
 type XAmazonAPIGatewayAuthorizer struct {
-	Type                         string `json:"type,omitempty" yaml:"type,omitempty"`
-	AuthorizerURI                string `json:"authorizerUri,omitempty" yaml:"authorizerUri,omitempty"`
-	IdentitySource               string `json:"identitySource,omitempty" yaml:"identitySource,omitempty"`
-	AuthorizerResultTTLInSeconds int    `json:"authorizerResultTtlInSeconds,omitempty" yaml:"authorizerResultTtlInSeconds,omitempty"`
+	Type                           string   `json:"type,omitempty" yaml:"type,omitempty"`
+	AuthorizerURI                  string   `json:"authorizerUri,omitempty" yaml:"authorizerUri,omitempty"`
+	IdentitySource                 string   `json:"identitySource,omitempty" yaml:"identitySource,omitempty"`
+	AuthorizerResultTTLInSeconds   *int     `json:"authorizerResultTtlInSeconds,omitempty" yaml:"authorizerResultTtlInSeconds,omitempty"`
+	AuthorizerPayloadFormatVersion string   `json:"authorizerPayloadFormatVersion,omitempty" yaml:"authorizerPayloadFormatVersion,omitempty"`
+	EnableSimpleResponses          bool     `json:"enableSimpleResponses,omitempty" yaml:"enableSimpleResponses,omitempty"`
+	ProviderARNs                   []string `json:"providerARNs,omitempty" yaml:"providerARNs,omitempty"`
 }
 
 type XAmazonApigatewayIntegration struct {
-	Type                string `json:"type" yaml:"type"`
-	URI                 string `json:"uri" yaml:"uri"`
-	HTTPMethod          string `json:"httpMethod" yaml:"httpMethod"`
-	PassthroughBehavior string `json:"passthroughBehavior" yaml:"passthroughBehavior"`
+	Type                string                                          `json:"type" yaml:"type"`
+	URI                 string                                          `json:"uri" yaml:"uri"`
+	HTTPMethod          string                                          `json:"httpMethod" yaml:"httpMethod"`
+	PassthroughBehavior string                                          `json:"passthroughBehavior" yaml:"passthroughBehavior"`
+	RequestTemplates    map[string]string                               `json:"requestTemplates,omitempty" yaml:"requestTemplates,omitempty"`
+	Responses           map[string]XAmazonApigatewayIntegrationResponse `json:"responses,omitempty" yaml:"responses,omitempty"`
+	RequestParameters   map[string]string                               `json:"requestParameters,omitempty" yaml:"requestParameters,omitempty"`
+	TimeoutInMillis     int                                             `json:"timeoutInMillis,omitempty" yaml:"timeoutInMillis,omitempty"`
+	ContentHandling     string                                          `json:"contentHandling,omitempty" yaml:"contentHandling,omitempty"`
+	// ResponseTransferMode is STREAM for lambda proxy integrations streaming their response, or BUFFERED.
+	ResponseTransferMode string `json:"responseTransferMode,omitempty" yaml:"responseTransferMode,omitempty"`
+}
+
+type XAmazonApigatewayIntegrationResponse struct {
+	StatusCode         string            `json:"statusCode" yaml:"statusCode"`
+	SelectionPattern   string            `json:"selectionPattern,omitempty" yaml:"selectionPattern,omitempty"`
+	ResponseTemplates  map[string]string `json:"responseTemplates,omitempty" yaml:"responseTemplates,omitempty"`
+	ResponseParameters map[string]string `json:"responseParameters,omitempty" yaml:"responseParameters,omitempty"`
+	ContentHandling    string            `json:"contentHandling,omitempty" yaml:"contentHandling,omitempty"`
 }
 
 func (api *API) Import(spec *openapi3.T) error {
-	for path, item := range spec.Paths {
-		if item.Get != nil {
-			if err := api.addOperationToAPI(spec, item.Get, http.MethodGet, path); err != nil {
-				return err
-			}
+	responses, err := gatewayResponses(spec)
+	if err != nil {
+		return err
+	}
+	api.gatewayResponses = responses
+	if api.cors, err = corsConfiguration(spec); err != nil {
+		return err
+	}
+	if api.binaryTypes, err = binaryMediaTypes(spec); err != nil {
+		return err
+	}
+	api.spec = spec
+	api.checkRootExtensions(spec)
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	// mux matches routes in the order they are registered, so the most specific paths go first
+	sort.SliceStable(paths, func(i, j int) bool {
+		return pathLess(paths[i], paths[j])
+	})
+	for _, path := range paths {
+		item := spec.Paths[path]
+		ops := item.Operations()
+		methods := make([]string, 0, len(ops))
+		for method := range ops {
+			methods = append(methods, method)
 		}
-		if item.Post != nil {
-			if err := api.addOperationToAPI(spec, item.Post, http.MethodPost, path); err != nil {
+		sort.Strings(methods)
+		for _, method := range methods {
+			if !contains(supportedMethods, method) {
+				api.diagnose(path, method, ops[method], "method", "the method is not supported by API Gateway and was not registered")
+				continue
+			}
+			if err := api.addOperationToAPI(spec, ops[method], method, path); err != nil {
 				return err
 			}
 		}
-		if item.Delete != nil {
-			if err := api.addOperationToAPI(spec, item.Delete, http.MethodDelete, path); err != nil {
+		// the any method is a catch-all, it is registered after the explicit methods of the path
+		if ext, ok := item.Extensions[anyMethodExtension]; ok {
+			op, err := anyMethodOperation(spec, path, ext)
+			if err != nil {
 				return err
 			}
-		}
-		if item.Put != nil {
-			if err := api.addOperationToAPI(spec, item.Put, http.MethodPut, path); err != nil {
+			if err := api.addOperationToAPI(spec, op, anyMethod, path); err != nil {
 				return err
 			}
 		}
@@ -49,49 +105,128 @@ func (api *API) Import(spec *openapi3.T) error {
 	return nil
 }
 
-func (api *API) addOperationToAPI(spec *openapi3.T, op *openapi3.Operation, method, path string) error {
-	if ext, ok := op.Extensions["x-amazon-apigateway-integration"]; ok {
-		var data XAmazonApigatewayIntegration
-		if err := mapstructure.Decode(ext, &data); err != nil {
-			return fmt.Errorf("unable to parse x-amazon-apigateway-integration extension for %s error: %w", path, err)
-		} else {
-			secReqs := make([]openapi3.SecurityRequirement, 0)
-			secReqs = append(secReqs, spec.Security...)
-			if op.Security != nil && len(*op.Security) > 0 {
-				secReqs = append(secReqs, *op.Security...)
-			}
-			if len(secReqs) > 0 {
-				// we are going to assume one for now
-				auths := make([]string, 0)
-				for _, req := range secReqs {
-					for k := range req {
-						auths = append(auths, k)
-					}
-				}
-				for _, name := range auths {
-					if sec, ok := spec.Components.SecuritySchemes[name]; ok {
-						if val, ok := sec.Value.Extensions["x-amazon-apigateway-authorizer"]; ok {
-							var auth XAmazonAPIGatewayAuthorizer
-							if err := mapstructure.Decode(val, &auth); err != nil {
-								return fmt.Errorf("unable to parse x-amazon-apigateway-authorizer extension for %s error: %w", name, err)
-							}
-							handler := Logger(api.Authorizer(auth.AuthorizerURI, auth.Type, api.LambdaProxy(data.URI)), op.OperationID)
-							api.router.Methods(method).Path(path).Name(op.OperationID).Handler(handler)
-						} else {
-							// if _, ok := sec.Value.Extensions["sigv4"]; ok {
-							// TODO something sig4
-							handler := Logger(api.LambdaProxy(data.URI), op.OperationID)
-							api.router.Methods(method).Path(path).Name(op.OperationID).Handler(handler)
-						}
-					} else {
-						return fmt.Errorf("something didnt work 2")
-					}
-				}
-			} else {
-				handler := Logger(api.LambdaProxy(data.URI), op.OperationID)
-				api.router.Methods(method).Path(path).Name(op.OperationID).Handler(handler)
-			}
+// anyMethodOperation decodes the x-amazon-apigateway-any-method extension into an operation
+// and resolves any references it makes into the spec components.
+func anyMethodOperation(spec *openapi3.T, path string, ext any) (*openapi3.Operation, error) {
+	b, err := json.Marshal(ext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s extension for %s error: %w", anyMethodExtension, path, err)
+	}
+	op := openapi3.NewOperation()
+	if err = op.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("unable to parse %s extension for %s error: %w", anyMethodExtension, path, err)
+	}
+	doc := &openapi3.T{
+		OpenAPI:    spec.OpenAPI,
+		Components: spec.Components,
+		Paths:      openapi3.Paths{path: &openapi3.PathItem{Get: op}},
+	}
+	if err = openapi3.NewLoader().ResolveRefsIn(doc, nil); err != nil {
+		return nil, fmt.Errorf("unable to resolve %s extension references for %s error: %w", anyMethodExtension, path, err)
+	}
+	return op, nil
+}
+
+// integration creates the handler for the integration type, lambda proxy integrations are the default.
+func (api *API) integration(data XAmazonApigatewayIntegration) (http.HandlerFunc, error) {
+	switch strings.ToLower(data.Type) {
+	case "aws":
+		return api.LambdaIntegration(data)
+	case "http":
+		return api.HTTPIntegration(data)
+	case "http_proxy":
+		return api.HTTPProxyIntegration(data)
+	case "mock":
+		return api.MockIntegration(data)
+	}
+	if strings.EqualFold(data.ResponseTransferMode, "STREAM") {
+		return api.LambdaProxyStream(data.URI), nil
+	}
+	return api.LambdaProxy(data.URI), nil
+}
+
+// muxPath converts greedy path parameters such as {proxy+} into mux patterns that match
+// multiple path segments.
+func muxPath(path string) string {
+	return greedyParamRx.ReplaceAllString(path, "{$1:.+}")
+}
+
+// pathLess orders paths by specificity, literal segments before parameters and parameters
+// before greedy parameters, as API Gateway prefers the most specific match.
+func pathLess(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if ak, bk := segmentKind(as[i]), segmentKind(bs[i]); ak != bk {
+			return ak < bk
+		}
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
 		}
 	}
+	return len(as) > len(bs)
+}
+
+func segmentKind(segment string) int {
+	switch {
+	case greedyParamRx.MatchString(segment):
+		return 2
+	case strings.HasPrefix(segment, "{"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (api *API) addOperationToAPI(spec *openapi3.T, op *openapi3.Operation, method, path string) error {
+	ext, ok := op.Extensions[integrationExtension]
+	if !ok {
+		api.diagnose(path, method, op, integrationExtension, "the operation has no integration and was not registered")
+		return nil
+	}
+	var data XAmazonApigatewayIntegration
+	if err := decodeExtension(ext, &data); err != nil {
+		return fmt.Errorf("unable to parse x-amazon-apigateway-integration extension for %s error: %w", path, err)
+	}
+	api.checkIntegration(path, method, op, ext, data)
+	integration, err := api.integration(data)
+	if err != nil {
+		return fmt.Errorf("unable to configure the integration for %s error: %w", path, err)
+	}
+	integration = recordIntegration(integration)
+	validator, err := requestValidator(spec, op)
+	if err != nil {
+		return fmt.Errorf("unable to configure request validation for %s error: %w", path, err)
+	}
+	if validator != nil {
+		integration = api.RequestValidator(*validator, operationParameters(spec.Paths[path], op), op.RequestBody, integration)
+	}
+	secReqs := operationSecurity(spec, op)
+	if !api.checkSecuritySchemes(spec, path, method, op, secReqs) {
+		return nil
+	}
+	api.exports = append(api.exports, exportedOperation{
+		method:      method,
+		path:        path,
+		operation:   op,
+		parameters:  operationParameters(spec.Paths[path], op),
+		integration: data,
+		validator:   requestValidatorName(spec, op),
+		security:    secReqs,
+	})
+	handler, err := api.secure(spec, path, method, op, secReqs, integration)
+	if err != nil {
+		return err
+	}
+	api.handle(method, path, op.OperationID, Logger(handler, op.OperationID))
 	return nil
 }
+
+// decodeExtension decodes an extension value, allowing for the loose typing of yaml specs
+// such as status codes written as numbers.
+func decodeExtension(ext, out any) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{WeaklyTypedInput: true, Result: out})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(ext)
+}
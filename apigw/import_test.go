@@ -12,6 +12,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
 	"github.com/iwarapter/gostack/lambstack"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -48,7 +49,7 @@ func Test_ImportSimpleGetAPI(t *testing.T) {
 	require.NoError(t, doc.Validate(context.Background()))
 
 	r := mux.NewRouter().Host(apiHostName).Subrouter()
-	api := New(r, f, "unit-test")
+	api := New(r, f, config.APIGW{ID: "unit-test"})
 	require.NoError(t, api.Import(doc))
 
 	rt := r.Get("getExample")
@@ -106,7 +107,7 @@ func Test_ImportLambdaAuthorizerGetAPI(t *testing.T) {
 									{
 										Action:   []string{"*"},
 										Effect:   "Allow",
-										Resource: []string{"my-resource"},
+										Resource: []string{"arn:aws:execute-api:us-east-1:123456789012:unit-test/*"},
 									},
 								},
 							},
@@ -124,7 +125,7 @@ func Test_ImportLambdaAuthorizerGetAPI(t *testing.T) {
 			require.NoError(t, doc.Validate(context.Background()))
 
 			r := mux.NewRouter().Host(apiHostName).Subrouter()
-			api := New(r, f, "unit-test")
+			api := New(r, f, config.APIGW{ID: "unit-test"})
 			require.NoError(t, api.Import(doc))
 
 			rt := r.Get("getExample")
@@ -151,3 +152,111 @@ func Test_ImportLambdaAuthorizerGetAPI(t *testing.T) {
 		})
 	}
 }
+
+func Test_ImportStagesWithStageVariables(t *testing.T) {
+	var received []events.APIGatewayProxyRequest
+	handler := func(payload any) ([]byte, error) {
+		event, ok := payload.(events.APIGatewayProxyRequest)
+		require.Truef(t, ok, "event must be events.APIGatewayProxyRequest")
+		received = append(received, event)
+		return json.Marshal(&events.APIGatewayProxyResponse{Body: event.RequestContext.Stage, StatusCode: http.StatusOK})
+	}
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple-dev":  handler,
+			"arn:aws:lambda:us-east-1:123456789012:function:simple-prod": handler,
+		},
+	}
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromFile("examples/stage-variables.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{
+		ID: "unit-test",
+		Stages: []config.APIStage{
+			{Name: "dev", Variables: map[string]string{"fn": "simple-dev"}},
+			{Name: "prod", Variables: map[string]string{"fn": "simple-prod"}},
+		},
+	})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	for _, stage := range []string{"dev", "prod"} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fmt.Sprintf("%s/unit-test/%s/_user_request_/simple", srv.URL, stage), nil)
+		require.NoError(t, err)
+		req.Host = apiHostName
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, stage, string(b))
+	}
+	require.Len(t, received, 2)
+	assert.Equal(t, "/simple", received[0].Path)
+	assert.Equal(t, map[string]string{"fn": "simple-dev"}, received[0].StageVariables)
+	assert.Equal(t, map[string]string{"fn": "simple-prod"}, received[1].StageVariables)
+	assert.Equal(t, "unit-test", received[1].RequestContext.APIID)
+}
+
+func Test_ImportAllMethodsAndGreedyPaths(t *testing.T) {
+	var received []events.APIGatewayProxyRequest
+	handler := func(name string) func(payload any) ([]byte, error) {
+		return func(payload any) ([]byte, error) {
+			event, ok := payload.(events.APIGatewayProxyRequest)
+			require.Truef(t, ok, "event must be events.APIGatewayProxyRequest")
+			received = append(received, event)
+			return json.Marshal(&events.APIGatewayProxyResponse{Body: name, StatusCode: http.StatusOK})
+		}
+	}
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple": handler("simple"),
+			"arn:aws:lambda:us-east-1:123456789012:function:proxy":  handler("proxy"),
+		},
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/any-method.yml")
+	require.NoError(t, err)
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "unit-test"})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		method, path, expected, resource string
+		params                           map[string]string
+	}{
+		{method: http.MethodGet, path: "/simple", expected: "simple", resource: "/simple", params: map[string]string{}},
+		{method: http.MethodPatch, path: "/simple", expected: "simple", resource: "/simple", params: map[string]string{}},
+		{method: http.MethodHead, path: "/simple", expected: "", resource: "/simple", params: map[string]string{}},
+		{method: http.MethodPost, path: "/simple", expected: "proxy", resource: "/{proxy+}", params: map[string]string{"proxy": "simple"}},
+		{method: http.MethodDelete, path: "/a/b/c", expected: "proxy", resource: "/{proxy+}", params: map[string]string{"proxy": "a/b/c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			received = nil
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, fmt.Sprintf("%s/unit-test%s", srv.URL, tt.path), nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(b))
+			require.Len(t, received, 1)
+			assert.Equal(t, tt.resource, received[0].Resource)
+			assert.Equal(t, tt.method, received[0].HTTPMethod)
+			assert.Equal(t, tt.params, received[0].PathParameters)
+		})
+	}
+}
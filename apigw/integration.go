@@ -0,0 +1,325 @@
+package apigw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iwarapter/gostack/internal/vtl"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultContentType = "application/json"
+
+type integrationResponse struct {
+	pattern         *regexp.Regexp
+	statusCode      int
+	templates       map[string]*vtl.Template
+	parameters      map[string]string
+	contentHandling string
+}
+
+// integrationResponses parses the integration responses, the response keys are the selection
+// patterns unless a selectionPattern is set, with "default" used when no pattern matches.
+func integrationResponses(responses map[string]XAmazonApigatewayIntegrationResponse) (map[string]*integrationResponse, error) {
+	parsed := make(map[string]*integrationResponse, len(responses))
+	for key, resp := range responses {
+		ir := &integrationResponse{statusCode: http.StatusOK, parameters: resp.ResponseParameters, contentHandling: strings.ToUpper(resp.ContentHandling)}
+		if resp.StatusCode != "" {
+			code, err := strconv.Atoi(resp.StatusCode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status code %s for integration response %s", resp.StatusCode, key)
+			}
+			ir.statusCode = code
+		}
+		pattern := resp.SelectionPattern
+		if pattern == "" && key != "default" {
+			pattern = key
+		}
+		if pattern != "" {
+			rx, err := regexp.Compile(`^(?s:` + pattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selection pattern for integration response %s: %w", key, err)
+			}
+			ir.pattern = rx
+		}
+		templates, err := parseTemplates(resp.ResponseTemplates)
+		if err != nil {
+			return nil, fmt.Errorf("integration response %s: %w", key, err)
+		}
+		ir.templates = templates
+		parsed[key] = ir
+	}
+	return parsed, nil
+}
+
+// selectResponse picks the integration response whose selection pattern matches the error message,
+// the default response is used for successful invocations or when no pattern matches.
+func selectResponse(responses map[string]*integrationResponse, errorMessage string, failed bool) *integrationResponse {
+	var def *integrationResponse
+	keys := make([]string, 0, len(responses))
+	for k := range responses {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resp := responses[k]
+		if resp.pattern == nil {
+			def = resp
+			continue
+		}
+		if failed && resp.pattern.MatchString(errorMessage) {
+			return resp
+		}
+	}
+	return def
+}
+
+// selectTemplate picks the mapping template for the content type, ok is false when templates are
+// defined and none of them match.
+func selectTemplate(templates map[string]*vtl.Template, contentType string) (*vtl.Template, string, bool) {
+	if len(templates) == 0 {
+		return nil, "", true
+	}
+	mediaType := defaultContentType
+	if contentType != "" {
+		if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = mt
+		}
+	}
+	for k, t := range templates {
+		if strings.EqualFold(k, mediaType) {
+			return t, k, true
+		}
+	}
+	return nil, "", false
+}
+
+// responseTemplate picks the response mapping template for the accepted content type, falling back to
+// application/json and then the first template.
+func responseTemplate(templates map[string]*vtl.Template, accept string) (*vtl.Template, string) {
+	if len(templates) == 0 {
+		return nil, ""
+	}
+	if tmpl, ct, ok := selectTemplate(templates, accept); ok {
+		return tmpl, ct
+	}
+	if tmpl, ok := templates[defaultContentType]; ok {
+		return tmpl, defaultContentType
+	}
+	keys := make([]string, 0, len(templates))
+	for k := range templates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return templates[keys[0]], keys[0]
+}
+
+// mappings are the request templates and integration responses of a non-proxy integration.
+type mappings struct {
+	passthrough      string
+	contentHandling  string
+	requestTemplates map[string]*vtl.Template
+	responses        map[string]*integrationResponse
+}
+
+func newMappings(integration XAmazonApigatewayIntegration) (*mappings, error) {
+	requestTemplates, err := parseTemplates(integration.RequestTemplates)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := integrationResponses(integration.Responses)
+	if err != nil {
+		return nil, err
+	}
+	return &mappings{
+		passthrough:      strings.ToLower(integration.PassthroughBehavior),
+		contentHandling:  strings.ToUpper(integration.ContentHandling),
+		requestTemplates: requestTemplates,
+		responses:        responses,
+	}, nil
+}
+
+// request transforms the request body with the request template matching the content type, ok is false
+// when the request was rejected and the response has been written. The content handling of the integration
+// is applied first, binary payloads are passed through without transformation.
+func (m *mappings) request(api *API, w http.ResponseWriter, r *http.Request, body []byte, subl zerolog.Logger) (string, bool) {
+	body, binary, err := convertContent(m.contentHandling, body, api.isBinary(r.Header.Get("Content-Type")))
+	if err != nil {
+		subl.Info().Err(err).Msg("unable to convert the request")
+		api.gatewayError(w, r, badRequestBody, "Invalid request body")
+		return "", false
+	}
+	if binary {
+		return string(body), true
+	}
+	tmpl, _, ok := selectTemplate(m.requestTemplates, r.Header.Get("Content-Type"))
+	switch {
+	case !ok && (m.passthrough == "never" || m.passthrough == "when_no_templates"):
+		api.gatewayError(w, r, unsupportedMediaType, "Unsupported Media Type")
+		return "", false
+	case tmpl != nil:
+		payload, err := tmpl.Execute(api.templateVariables(r, string(body)))
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to transform the request")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return "", false
+		}
+		return payload, true
+	case len(m.requestTemplates) == 0 && m.passthrough == "never":
+		api.gatewayError(w, r, unsupportedMediaType, "Unsupported Media Type")
+		return "", false
+	}
+	return string(body), true
+}
+
+// respond writes the integration output through the integration response selected by the selector,
+// the lambda error message or the http status code of the integration. Binary output, after the content
+// handling of the integration response, is written without transformation. The response parameters
+// are mapped from the request and the integration output and its headers.
+func (m *mappings) respond(api *API, w http.ResponseWriter, r *http.Request, selector string, matchPatterns bool, output []byte, header http.Header, binary bool, subl zerolog.Logger) {
+	resp := selectResponse(m.responses, selector, matchPatterns)
+	if resp == nil {
+		subl.Error().Msg("no match for output mapping and no default output mapping configured")
+		api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+		return
+	}
+	raw := output
+	output, binary, err := convertContent(resp.contentHandling, output, binary)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to convert the response")
+		api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+		return
+	}
+	contentType := defaultContentType
+	if binary {
+		contentType = "application/octet-stream"
+		if accept := r.Header.Get("Accept"); api.isBinary(accept) && !strings.Contains(firstMediaType(accept), "*") {
+			contentType = firstMediaType(accept)
+		}
+	} else if tmpl, ct := responseTemplate(resp.templates, r.Header.Get("Accept")); tmpl != nil {
+		contentType = ct
+		rendered, err := tmpl.Execute(api.templateVariables(r, string(output)))
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to transform the response")
+			api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+			return
+		}
+		output = []byte(rendered)
+	}
+	for k, source := range resp.parameters {
+		name, ok := strings.CutPrefix(k, "method.response.header.")
+		if !ok {
+			continue
+		}
+		if value, ok := api.responseParameterValue(r, source, header, raw); ok {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(resp.statusCode)
+	_, _ = w.Write(output)
+}
+
+// LambdaIntegration invokes the lambda with the payload produced by the request mapping templates, the
+// result or the error of the lambda is mapped back through the integration responses.
+func (api *API) LambdaIntegration(integration XAmazonApigatewayIntegration) (http.HandlerFunc, error) {
+	m, err := newMappings(integration)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		stage := api.stageFromRequest(r)
+		arn := stage.lambdaARN(integration.URI)
+		subl := log.With().Str("handler", "apigateway-lambda-integration").Str("arn", arn).Logger()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to read body")
+			api.gatewayError(w, r, default5XX, "Internal server error")
+			return
+		}
+		defer r.Body.Close()
+
+		payload, ok := m.request(api, w, r, body, subl)
+		if !ok {
+			return
+		}
+		if strings.TrimSpace(payload) == "" {
+			payload = "{}"
+		}
+		if !json.Valid([]byte(payload)) {
+			subl.Info().Msg("the integration request is not valid json")
+			api.gatewayError(w, r, badRequestBody, "Could not parse request body into json")
+			return
+		}
+
+		var errorMessage string
+		output, err := api.invoke(arn, json.RawMessage(payload))
+		if api.limitError(w, r, err) {
+			subl.Info().Err(err).Msg("lambda invocation over the limits")
+			return
+		}
+		var fnErr *lambstack.FunctionError
+		switch {
+		case errors.As(err, &fnErr):
+			subl.Info().Str("error", fnErr.Message).Msg("lambda returned an error")
+			errorMessage = fnErr.Message
+			output, _ = json.Marshal(fnErr)
+		case err != nil:
+			subl.Error().Err(err).Msg("unable to invoke lambda")
+			api.gatewayError(w, r, default5XX, "Internal server error")
+			return
+		}
+		m.respond(api, w, r, errorMessage, fnErr != nil, output, nil, false, subl)
+	}, nil
+}
+
+// MockIntegration responds without calling a backend, the status code is read from the statusCode of the
+// request template output and selects the integration response.
+func (api *API) MockIntegration(integration XAmazonApigatewayIntegration) (http.HandlerFunc, error) {
+	m, err := newMappings(integration)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-mock-integration").Logger()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to read body")
+			api.gatewayError(w, r, default5XX, "Internal server error")
+			return
+		}
+		defer r.Body.Close()
+
+		payload, ok := m.request(api, w, r, body, subl)
+		if !ok {
+			return
+		}
+		statusCode := http.StatusOK
+		if strings.TrimSpace(payload) != "" {
+			var req struct {
+				StatusCode any `json:"statusCode"`
+			}
+			// a passed through body that is not json leaves the default status code
+			if err := json.Unmarshal([]byte(payload), &req); err == nil && req.StatusCode != nil {
+				code, err := strconv.Atoi(fmt.Sprint(req.StatusCode))
+				if err != nil {
+					subl.Error().Err(err).Msg("invalid mock status code")
+					api.gatewayError(w, r, apiConfigurationError, "Internal server error")
+					return
+				}
+				statusCode = code
+			}
+		}
+		m.respond(api, w, r, strconv.Itoa(statusCode), true, nil, nil, false, subl)
+	}, nil
+}
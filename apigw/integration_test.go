@@ -0,0 +1,128 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportLambdaIntegration(t *testing.T) {
+	var received []map[string]any
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:pets": func(payload any) ([]byte, error) {
+				raw, ok := payload.(json.RawMessage)
+				require.Truef(t, ok, "event must be json.RawMessage")
+				event := map[string]any{}
+				require.NoError(t, json.Unmarshal(raw, &event))
+				received = append(received, event)
+				if event["id"] == "missing" {
+					return nil, &lambstack.FunctionError{Message: "pet not found", Type: "NotFound"}
+				}
+				return json.Marshal(map[string]any{"id": event["id"], "name": event["name"]})
+			},
+		},
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/lambda-integration.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "unit-test", Stages: []config.APIStage{{Name: "dev"}}})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, path, body, contentType string
+		status                        int
+		expected                      string
+		event                         map[string]any
+	}{
+		{
+			name:        "request and response templates are applied",
+			path:        "/pets/123",
+			body:        `{"name": "rex \"the dog\"", "tags": ["a", "b"]}`,
+			contentType: "application/json",
+			status:      http.StatusOK,
+			expected:    `{"pet": {"id":"123","name":"rex \"the dog\""}}`,
+			event:       map[string]any{"id": "123", "name": `rex "the dog"`, "tags": []any{"a", "b"}, "stage": "dev"},
+		},
+		{
+			name:        "lambda errors are selected by pattern",
+			path:        "/pets/missing",
+			body:        `{"name": "rex"}`,
+			contentType: "application/json",
+			status:      http.StatusNotFound,
+			expected:    `{"error": "pet not found"}`,
+			event:       map[string]any{"id": "missing", "name": "rex", "tags": []any{}, "stage": "dev"},
+		},
+		{
+			name:        "unmatched content types are rejected when passthrough is never",
+			path:        "/pets/123",
+			body:        `rex`,
+			contentType: "text/plain",
+			status:      http.StatusUnsupportedMediaType,
+			expected:    `{"message":"Unsupported Media Type"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, fmt.Sprintf("%s/unit-test/dev/_user_request_%s", srv.URL, tt.path), strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Host = apiHostName
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(b))
+			if tt.event == nil {
+				assert.Empty(t, received)
+				return
+			}
+			require.Len(t, received, 1)
+			assert.Equal(t, tt.event, received[0])
+		})
+	}
+}
+
+func Test_jsonPath(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(`{"a": {"b": [1, {"c": "d"}]}, "e": "f"}`), &doc))
+	tests := []struct {
+		path     string
+		expected any
+	}{
+		{path: "$", expected: doc},
+		{path: "$.e", expected: "f"},
+		{path: "$.a.b[0]", expected: float64(1)},
+		{path: "$['a']['b'][1].c", expected: "d"},
+		{path: "$.a.b[-1].c", expected: "d"},
+		{path: "$.missing", expected: nil},
+		{path: "$.a.b[*]", expected: []any{float64(1), map[string]any{"c": "d"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			v, err := jsonPath(doc, tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package apigw
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/iwarapter/gostack/lambstack"
+)
+
+const (
+	// maxPayloadSize is the size limit of the request and integration response payloads of API Gateway.
+	maxPayloadSize = 10 * 1024 * 1024
+	// maxHeaderSize is the size limit of the request line and header values of a request.
+	maxHeaderSize = 10 * 1024
+)
+
+var (
+	// errIntegrationTimeout is returned when a lambda does not respond within the integration timeout.
+	errIntegrationTimeout = errors.New("the integration timed out")
+	// errResponseTooLarge is returned for integration responses over the payload limit.
+	errResponseTooLarge = errors.New("the integration response is over the payload limit")
+)
+
+// limitRequest rejects requests over the payload and header size limits with the REQUEST_TOO_LARGE gateway
+// response, unless the limits are relaxed.
+func (api *API) limitRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.RelaxLimits {
+			next.ServeHTTP(w, r)
+			return
+		}
+		size := len(r.Method) + len(r.RequestURI) + len(r.Proto)
+		for _, values := range r.Header {
+			for _, v := range values {
+				size += len(v)
+			}
+		}
+		if size > maxHeaderSize || r.ContentLength > maxPayloadSize {
+			api.gatewayError(w, r, requestTooLarge, "Request Too Long")
+			return
+		}
+		if r.Body != nil && r.ContentLength < 0 {
+			// the size of chunked bodies is only known once they are read
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+			if err != nil {
+				api.gatewayError(w, r, default5XX, "Internal server error")
+				return
+			}
+			if len(body) > maxPayloadSize {
+				api.gatewayError(w, r, requestTooLarge, "Request Too Long")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// invoke invokes the lambda, giving up once the integration timeout has passed unless the limits are relaxed.
+// The lambda carries on after a timeout, as it does behind API Gateway.
+func (api *API) invoke(arn string, payload any) ([]byte, error) {
+	if api.RelaxLimits {
+		return api.lambs.Invoke(arn, payload)
+	}
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		b, err := api.lambs.Invoke(arn, payload)
+		done <- result{b: b, err: err}
+	}()
+	timeout := time.NewTimer(api.integrationTimeout)
+	defer timeout.Stop()
+	select {
+	case res := <-done:
+		return res.b, res.err
+	case <-timeout.C:
+		return nil, errIntegrationTimeout
+	}
+}
+
+// timeout is the timeout of an integration, the timeoutInMillis of the integration is capped at the
+// integration timeout of the api unless the limits are relaxed.
+func (api *API) timeout(integration XAmazonApigatewayIntegration) time.Duration {
+	timeout := api.integrationTimeout
+	if integration.TimeoutInMillis <= 0 {
+		return timeout
+	}
+	if custom := time.Duration(integration.TimeoutInMillis) * time.Millisecond; custom < timeout || api.RelaxLimits {
+		timeout = custom
+	}
+	return timeout
+}
+
+// readResponse reads the body of an integration response, errResponseTooLarge is returned for bodies over
+// the payload limit unless the limits are relaxed.
+func (api *API) readResponse(body io.Reader) ([]byte, error) {
+	if api.RelaxLimits {
+		return io.ReadAll(body)
+	}
+	b, err := io.ReadAll(io.LimitReader(body, maxPayloadSize+1))
+	if err == nil && len(b) > maxPayloadSize {
+		return nil, errResponseTooLarge
+	}
+	return b, err
+}
+
+// limitError writes the gateway response of lambda invocations that failed a limit, false is returned
+// for any other error.
+func (api *API) limitError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var tooLarge *lambstack.PayloadTooLargeError
+	switch {
+	case errors.Is(err, errIntegrationTimeout):
+		api.gatewayError(w, r, integrationTimeout, "Endpoint request timed out")
+	case errors.As(err, &tooLarge):
+		api.gatewayError(w, r, requestTooLarge, "Request Too Long")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package apigw
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", maxPayloadSize+1)))
+	}))
+	defer backend.Close()
+	f := &mockFactory{responses: map[string]func(payload any) ([]byte, error){
+		"arn:aws:lambda:us-east-1:123456789012:function:pets": func(payload any) ([]byte, error) {
+			event := payload.(events.APIGatewayProxyRequest)
+			switch {
+			case event.Body == "slow":
+				time.Sleep(200 * time.Millisecond)
+			// bodies of b stand in for payloads rejected by the payload limit of lambda
+			case strings.HasPrefix(event.Body, "b") && len(event.Body) > lambstack.MaxSyncPayload:
+				return nil, &lambstack.PayloadTooLargeError{Size: len(event.Body), Limit: lambstack.MaxSyncPayload}
+			}
+			return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "created"})
+		},
+	}}
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/limits.yml")
+	require.NoError(t, err)
+
+	setup := func(relax bool) *mux.Router {
+		r := mux.NewRouter().Host(apiHostName).Subrouter()
+		api := New(r, f, config.APIGW{ID: "limits", Stages: []config.APIStage{
+			{Name: "dev", Variables: map[string]string{"backend": strings.TrimPrefix(backend.URL, "http://")}},
+		}})
+		api.RelaxLimits = relax
+		api.integrationTimeout = 100 * time.Millisecond
+		require.NoError(t, api.Import(doc))
+		return r
+	}
+	limited, relaxed := setup(false), setup(true)
+
+	largeHeader := http.Header{"X-Large": {strings.Repeat("a", maxHeaderSize)}}
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		header   http.Header
+		status   int
+		relaxed  int
+		expected string
+	}{
+		{name: "request", method: http.MethodPost, path: "/pets", body: "rex", status: http.StatusOK, relaxed: http.StatusOK, expected: "created"},
+		{name: "request over the payload limit", method: http.MethodPost, path: "/pets", body: strings.Repeat("a", maxPayloadSize+1), status: http.StatusRequestEntityTooLarge, relaxed: http.StatusOK, expected: `{"message":"Request Too Long"}`},
+		{name: "request over the lambda payload limit", method: http.MethodPost, path: "/pets", body: strings.Repeat("b", lambstack.MaxSyncPayload+1), status: http.StatusRequestEntityTooLarge, relaxed: http.StatusRequestEntityTooLarge, expected: `{"message":"Request Too Long"}`},
+		{name: "headers over the limit", method: http.MethodPost, path: "/pets", body: "rex", header: largeHeader, status: http.StatusRequestEntityTooLarge, relaxed: http.StatusOK, expected: `{"message":"Request Too Long"}`},
+		{name: "lambda integration timeout", method: http.MethodPost, path: "/pets", body: "slow", status: http.StatusGatewayTimeout, relaxed: http.StatusOK, expected: `{"message":"Endpoint request timed out"}`},
+		{name: "integration response over the payload limit", method: http.MethodGet, path: "/reports", status: http.StatusBadGateway, relaxed: http.StatusOK, expected: `{"message":"Internal server error"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []*mux.Router{limited, relaxed} {
+				req := httptest.NewRequest(tt.method, "http://"+apiHostName+"/limits/dev/_user_request_"+tt.path, strings.NewReader(tt.body))
+				for k, v := range tt.header {
+					req.Header[k] = v
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if r == relaxed {
+					assert.Equal(t, tt.relaxed, w.Code)
+					continue
+				}
+				assert.Equal(t, tt.status, w.Code)
+				assert.Equal(t, tt.expected, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_IntegrationTimeoutIsCapped(t *testing.T) {
+	api := &API{integrationTimeout: defaultIntegrationTimeout}
+	assert.Equal(t, defaultIntegrationTimeout, api.timeout(XAmazonApigatewayIntegration{}))
+	assert.Equal(t, 5*time.Second, api.timeout(XAmazonApigatewayIntegration{TimeoutInMillis: 5000}))
+	assert.Equal(t, defaultIntegrationTimeout, api.timeout(XAmazonApigatewayIntegration{TimeoutInMillis: 60000}))
+	api.RelaxLimits = true
+	assert.Equal(t, time.Minute, api.timeout(XAmazonApigatewayIntegration{TimeoutInMillis: 60000}))
+}
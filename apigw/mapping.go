@@ -0,0 +1,393 @@
+package apigw
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/internal/vtl"
+)
+
+// parseTemplates parses a set of mapping templates keyed by content type.
+func parseTemplates(templates map[string]string) (map[string]*vtl.Template, error) {
+	parsed := make(map[string]*vtl.Template, len(templates))
+	for contentType, src := range templates {
+		t, err := vtl.Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping template for %s: %w", contentType, err)
+		}
+		parsed[contentType] = t
+	}
+	return parsed, nil
+}
+
+// templateVariables are the variables available to a mapping template, body is the request
+// body for request templates and the integration response for response templates.
+func (api *API) templateVariables(r *http.Request, body string) map[string]any {
+	stage := api.stageFromRequest(r)
+	variables := make(map[string]any, len(stage.Variables))
+	for k, v := range stage.Variables {
+		variables[k] = v
+	}
+	return map[string]any{
+		"input":          &mappingInput{body: body, params: requestParams(r)},
+		"context":        api.contextVariables(r),
+		"stageVariables": variables,
+		"util":           mappingUtil{},
+	}
+}
+
+// contextVariables is the request context as the $context variable.
+func (api *API) contextVariables(r *http.Request) map[string]any {
+	rc := api.stageFromRequest(r).requestContext(r)
+	if auth, ok := r.Context().Value(AuthorizerContext).(events.APIGatewayCustomAuthorizerResponse); ok {
+		rc.Authorizer = map[string]any{"principalId": auth.PrincipalID}
+		for k, v := range auth.Context {
+			rc.Authorizer[k] = v
+		}
+	}
+	b, _ := json.Marshal(rc)
+	ctx := map[string]any{}
+	_ = json.Unmarshal(b, &ctx)
+	return ctx
+}
+
+func requestParams(r *http.Request) map[string]any {
+	path := map[string]any{}
+	for k, v := range mux.Vars(r) {
+		path[k] = v
+	}
+	query := map[string]any{}
+	for k := range r.URL.Query() {
+		query[k] = r.URL.Query().Get(k)
+	}
+	header := map[string]any{}
+	for k := range r.Header {
+		header[k] = r.Header.Get(k)
+	}
+	return map[string]any{"path": path, "querystring": query, "header": header}
+}
+
+// parameterValue evaluates a request or response parameter mapping source, either a 'literal' value,
+// a method.request header, querystring or path parameter, a stage variable or a context variable.
+func (api *API) parameterValue(r *http.Request, source string) (string, bool) {
+	if len(source) > 1 && strings.HasPrefix(source, "'") && strings.HasSuffix(source, "'") {
+		return source[1 : len(source)-1], true
+	}
+	switch location, name, _ := strings.Cut(source, "."); location {
+	case "stageVariables":
+		v, ok := api.stageFromRequest(r).Variables[name]
+		return v, ok
+	case "context":
+		var v any = api.contextVariables(r)
+		for _, key := range strings.Split(name, ".") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			if v, ok = m[key]; !ok {
+				return "", false
+			}
+		}
+		return vtl.String(v), true
+	}
+	param, ok := strings.CutPrefix(source, "method.request.")
+	if !ok {
+		return "", false
+	}
+	location, name, _ := strings.Cut(param, ".")
+	switch location {
+	case "header", "multivalueheader":
+		return headerValue(r.Header, location, name)
+	case "querystring":
+		v, ok := r.URL.Query()[name]
+		if !ok {
+			return "", false
+		}
+		return v[len(v)-1], true
+	case "multivaluequerystring":
+		v, ok := r.URL.Query()[name]
+		return strings.Join(v, ","), ok
+	case "path":
+		v, ok := mux.Vars(r)[name]
+		return v, ok
+	}
+	return "", false
+}
+
+// responseParameterValue evaluates a response parameter mapping source, the integration.response header or
+// body of the integration output, such as integration.response.body.id for the id property of a json body.
+// Other sources are evaluated against the request.
+func (api *API) responseParameterValue(r *http.Request, source string, header http.Header, body []byte) (string, bool) {
+	param, ok := strings.CutPrefix(source, "integration.response.")
+	if !ok {
+		return api.parameterValue(r, source)
+	}
+	location, name, _ := strings.Cut(param, ".")
+	switch location {
+	case "header", "multivalueheader":
+		return headerValue(header, location, name)
+	case "body":
+		if name == "" {
+			return string(body), true
+		}
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", false
+		}
+		v, err := jsonPath(doc, "$."+name)
+		if err != nil || v == nil {
+			return "", false
+		}
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	}
+	return "", false
+}
+
+// headerValue is the last value of the header, or every value comma separated for a multivalueheader.
+func headerValue(header http.Header, location, name string) (string, bool) {
+	v := header.Values(name)
+	if len(v) == 0 {
+		return "", false
+	}
+	if location == "multivalueheader" {
+		return strings.Join(v, ","), true
+	}
+	return v[len(v)-1], true
+}
+
+// mappingInput is the $input variable of a mapping template.
+type mappingInput struct {
+	body   string
+	params map[string]any
+	parsed any
+	err    error
+	done   bool
+}
+
+func (i *mappingInput) json() (any, error) {
+	if !i.done {
+		i.done = true
+		if strings.TrimSpace(i.body) == "" {
+			// an empty body is treated as an empty object
+			i.parsed = map[string]any{}
+		} else {
+			i.err = json.Unmarshal([]byte(i.body), &i.parsed)
+		}
+	}
+	return i.parsed, i.err
+}
+
+func (i *mappingInput) Property(name string) (any, bool) {
+	if name == "body" {
+		return i.body, true
+	}
+	return nil, false
+}
+
+func (i *mappingInput) Call(method string, args []any) (any, error) {
+	switch method {
+	case "body":
+		return i.body, nil
+	case "params":
+		if len(args) == 0 {
+			return i.params, nil
+		}
+		name := vtl.String(args[0])
+		for _, location := range []string{"path", "querystring", "header"} {
+			if v, ok := i.params[location].(map[string]any)[name]; ok {
+				return v, nil
+			}
+		}
+		// headers are matched case insensitively
+		for k, v := range i.params["header"].(map[string]any) {
+			if strings.EqualFold(k, name) {
+				return v, nil
+			}
+		}
+		return "", nil
+	case "path", "json":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("$input.%s requires a json path", method)
+		}
+		doc, err := i.json()
+		if err != nil {
+			if method == "path" {
+				// a body that is not json is treated as a string
+				return i.body, nil
+			}
+			return nil, fmt.Errorf("unable to parse input as json: %w", err)
+		}
+		v, err := jsonPath(doc, vtl.String(args[0]))
+		if err != nil || method == "path" {
+			return v, err
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("unknown method $input.%s", method)
+}
+
+// mappingUtil is the $util variable of a mapping template.
+type mappingUtil struct{}
+
+func (mappingUtil) Property(_ string) (any, bool) {
+	return nil, false
+}
+
+func (mappingUtil) Call(method string, args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("$util.%s requires a single argument", method)
+	}
+	s := vtl.String(args[0])
+	switch method {
+	case "escapeJavaScript":
+		return escapeJavaScript(s), nil
+	case "parseJson":
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("$util.parseJson: %w", err)
+		}
+		return v, nil
+	case "urlEncode":
+		return url.QueryEscape(s), nil
+	case "urlDecode":
+		return url.QueryUnescape(s)
+	case "base64Encode":
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	case "base64Decode":
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	}
+	return nil, fmt.Errorf("unknown method $util.%s", method)
+}
+
+// escapeJavaScript escapes the string using javascript string rules, as the java StringEscapeUtils
+// used by API Gateway does.
+func escapeJavaScript(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '/':
+			b.WriteString(`\/`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || r > 0x7e {
+				if r > 0xffff {
+					r1, r2 := utf16.EncodeRune(r)
+					fmt.Fprintf(&b, `\u%04X\u%04X`, r1, r2)
+				} else {
+					fmt.Fprintf(&b, `\u%04X`, r)
+				}
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// jsonPath evaluates the simple json path expressions supported by API Gateway, the root $
+// followed by dotted or bracketed children, array indexes and wildcards.
+func jsonPath(doc any, path string) (any, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %s must start with $", path)
+	}
+	current := []any{doc}
+	wildcard := false
+	rest := path[1:]
+	for rest != "" {
+		var key string
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path %s", path)
+			}
+			key, rest = strings.Trim(rest[1:end], `'"`), rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+		default:
+			return nil, fmt.Errorf("invalid json path %s", path)
+		}
+		next := make([]any, 0, len(current))
+		for _, v := range current {
+			next = append(next, jsonPathChildren(v, key)...)
+		}
+		wildcard = wildcard || key == "*"
+		current = next
+	}
+	if wildcard {
+		return current, nil
+	}
+	if len(current) == 0 {
+		return nil, nil
+	}
+	return current[0], nil
+}
+
+func jsonPathChildren(v any, key string) []any {
+	switch v := v.(type) {
+	case map[string]any:
+		if key == "*" {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			children := make([]any, 0, len(v))
+			for _, k := range keys {
+				children = append(children, v[k])
+			}
+			return children
+		}
+		if child, ok := v[key]; ok {
+			return []any{child}
+		}
+	case []any:
+		if key == "*" {
+			return v
+		}
+		if i, err := strconv.Atoi(key); err == nil {
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []any{v[i]}
+			}
+		}
+	}
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -13,6 +13,8 @@ import (
 	cache "github.com/Code-Hex/go-generics-cache"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/internal/mw"
+	"github.com/iwarapter/gostack/lambstack"
 	"github.com/rs/zerolog/log"
 )
 
@@ -20,88 +22,167 @@ type contextKey string
 
 const AuthorizerContext contextKey = "authorizer"
 
-func (api *API) Authorizer(arn, authType string, h http.HandlerFunc) http.HandlerFunc {
+const explicitDenyMessage = "User is not authorized to access this resource with an explicit deny"
+
+// defaultAuthorizerTTL is the authorizer result TTL when authorizerResultTtlInSeconds is not set.
+const defaultAuthorizerTTL = 300 * time.Second
+
+// identitySourceReplacer rewrites HTTP API identity sources as their REST API equivalents.
+var identitySourceReplacer = strings.NewReplacer(
+	"$request.header.", "method.request.header.",
+	"$request.querystring.", "method.request.querystring.",
+	"$stageVariables.", "stageVariables.",
+	"$context.", "context.",
+)
+
+// identitySources are the comma separated identity sources of the authorizer, token authorizers
+// default to the Authorization header.
+func (a XAmazonAPIGatewayAuthorizer) identitySources() []string {
+	sources := make([]string, 0)
+	for _, s := range strings.Split(a.IdentitySource, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, identitySourceReplacer.Replace(s))
+		}
+	}
+	if len(sources) == 0 && !strings.EqualFold(a.Type, "request") {
+		sources = append(sources, "method.request.header.Authorization")
+	}
+	return sources
+}
+
+// payloadV2 is true for HTTP API authorizers using payload format 2.0.
+func (a XAmazonAPIGatewayAuthorizer) payloadV2() bool {
+	return a.AuthorizerPayloadFormatVersion == "2.0"
+}
+
+func (a XAmazonAPIGatewayAuthorizer) ttl() time.Duration {
+	if a.AuthorizerResultTTLInSeconds == nil {
+		return defaultAuthorizerTTL
+	}
+	return time.Duration(*a.AuthorizerResultTTLInSeconds) * time.Second
+}
+
+// Authorizer invokes the lambda authorizer with the identity sources of the request, requests missing
+// any of the identity sources are rejected. Results are cached by the authorizer and identity source
+// values for the authorizer TTL, authorizers without identity sources or with a TTL of 0 are not cached.
+func (api *API) Authorizer(authorizer XAmazonAPIGatewayAuthorizer, h http.HandlerFunc) http.HandlerFunc {
+	sources := authorizer.identitySources()
+	ttl := authorizer.ttl()
+	caching := len(sources) > 0 && ttl > 0
 	return func(w http.ResponseWriter, r *http.Request) {
 		subl := log.With().Str("handler", "apigateway-authorizer").Logger()
-		header := r.Header.Get("authorization")
-		if header == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		auth, ok := api.authCache.Get(header)
-		subl.Info().Bool("cache_hit", ok).Msg("checking authorizer cache")
-		if ok { // we use a cached response, move on
-			if isAuthResponseDeny(auth) {
-				subl.Info().Bool("cache_hit", ok).Msg("policy deny")
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			} else {
-				subl.Info().Bool("cache_hit", ok).Msg("policy allow")
-				h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), AuthorizerContext, auth)))
+		identity := make([]string, 0, len(sources))
+		for _, source := range sources {
+			value, ok := api.parameterValue(r, source)
+			if !ok || value == "" {
+				subl.Info().Str("identity_source", source).Msg("missing identity source")
+				api.gatewayError(w, r, unauthorized, "Unauthorized")
 				return
 			}
+			identity = append(identity, value)
 		}
-		var payload any
-		switch strings.ToLower(authType) {
-		case "token":
-			payload = events.APIGatewayCustomAuthorizerRequest{
-				Type:               "TOKEN",
-				AuthorizationToken: header,
-				MethodArn:          "some arn",
-			}
-		case "request":
-			params := mux.Vars(r)
-
-			headers := make(map[string]string)
-			for key := range r.Header {
-				headers[key] = r.Header.Get(key)
+		stage := api.stageFromRequest(r)
+		arn := stage.lambdaARN(authorizer.AuthorizerURI)
+		key := strings.Join(append([]string{arn}, identity...), "\x00")
+		methodARN := stage.methodARN(r)
+		auth, ok := events.APIGatewayCustomAuthorizerResponse{}, false
+		if caching {
+			auth, ok = api.authCache.Get(key)
+			subl.Info().Bool("cache_hit", ok).Msg("checking authorizer cache")
+		}
+		if !ok {
+			var err error
+			if auth, err = api.invokeAuthorizer(w, r, authorizer, arn, methodARN, identity); err != nil {
+				return
 			}
-			qParams := make(map[string]string)
-			for k, v := range r.URL.Query() {
-				qParams[k] = strings.Join(v, " ")
-			}
-			payload = events.APIGatewayProxyRequest{
-				Resource:              "/{proxy+}",
-				Path:                  strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/restapis/%s", api.ID)),
-				HTTPMethod:            r.Method,
-				QueryStringParameters: qParams,
-				Headers:               headers,
-				PathParameters:        params,
+			if caching {
+				api.authCache.Set(key, auth, cache.WithExpiration(ttl))
 			}
 		}
-		authResponse, err := api.lambs.Invoke(arn, payload)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			subl.Error().Err(err).Str("arn", arn).Msg("unable to invoke authorizer")
+		// cached policies are evaluated again as they may not cover the method of this request
+		allowed, explicitDeny := evaluatePolicy(auth.PolicyDocument, methodARN)
+		switch {
+		case authorizer.EnableSimpleResponses && !allowed:
+			subl.Info().Msg("authorizer denied the request")
+			api.gatewayError(w, r, accessDenied, "Forbidden")
 			return
-		}
-		err = json.Unmarshal(authResponse, &auth)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		case explicitDeny:
+			subl.Info().Str("method_arn", methodARN).Msg("policy deny")
+			api.gatewayError(w, r, accessDenied, explicitDenyMessage)
 			return
-		}
-		api.authCache.Set(header, auth, cache.WithExpiration(5*time.Minute))
-		if isAuthResponseDeny(auth) {
-			subl.Info().Msg("policy deny")
-			w.WriteHeader(http.StatusUnauthorized)
+		case !allowed:
+			subl.Info().Str("method_arn", methodARN).Msg("policy does not allow the method")
+			api.gatewayError(w, r, accessDenied, implicitDenyMessage)
 			return
 		}
-		subl.Info().Msg("policy allow")
+		subl.Info().Str("method_arn", methodARN).Msg("policy allow")
+		if authorizer.payloadV2() {
+			// HTTP APIs pass the context of lambda authorizers as requestContext.authorizer.lambda
+			auth.Context = map[string]any{"lambda": auth.Context}
+		}
 		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), AuthorizerContext, auth)))
 	}
 }
 
-func isAuthResponseDeny(auth events.APIGatewayCustomAuthorizerResponse) bool {
-	for _, statement := range auth.PolicyDocument.Statement {
-		if strings.ToLower(statement.Effect) == "deny" {
-			return true
-		}
+// headersV2 are the request headers of payload format 2.0 events, with lower case names and
+// multiple values joined by commas.
+func headersV2(r *http.Request) map[string]string {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
 	}
-	return false
+	return headers
 }
 
-func (api *API) LambdaProxy(arn string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// queryStringV2 are the query string parameters of payload format 2.0 events, with multiple values
+// joined by commas.
+func queryStringV2(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		params[k] = strings.Join(v, ",")
+	}
+	return params
+}
+
+func cookies(r *http.Request) []string {
+	cookies := make([]string, 0)
+	for _, c := range r.Cookies() {
+		cookies = append(cookies, c.String())
+	}
+	return cookies
+}
+
+// requestAuthorizerEvent is the event of request authorizers, the proxy request along with the method arn.
+type requestAuthorizerEvent struct {
+	Type      string `json:"type"`
+	MethodArn string `json:"methodArn"` //nolint: stylecheck
+	events.APIGatewayProxyRequest
+}
+
+// invokeAuthorizer invokes the authorizer lambda for the request, when the authorizer fails the
+// gateway response has been written and the error is returned.
+func (api *API) invokeAuthorizer(w http.ResponseWriter, r *http.Request, authorizer XAmazonAPIGatewayAuthorizer, arn, methodARN string, identity []string) (events.APIGatewayCustomAuthorizerResponse, error) {
+	subl := log.With().Str("handler", "apigateway-authorizer").Str("arn", arn).Logger()
+	stage := api.stageFromRequest(r)
+	var payload any
+	switch {
+	case authorizer.payloadV2():
+		payload = events.APIGatewayV2CustomAuthorizerV2Request{
+			Version:               "2.0",
+			Type:                  "REQUEST",
+			RouteArn:              methodARN,
+			IdentitySource:        identity,
+			RouteKey:              routeKey(r),
+			RawPath:               r.URL.Path,
+			RawQueryString:        r.URL.RawQuery,
+			Cookies:               cookies(r),
+			Headers:               headersV2(r),
+			QueryStringParameters: queryStringV2(r),
+			RequestContext:        stage.requestContextV2(r),
+			PathParameters:        mux.Vars(r),
+			StageVariables:        stage.Variables,
+		}
+	case strings.EqualFold(authorizer.Type, "request"):
 		params := mux.Vars(r)
 
 		headers := make(map[string]string)
@@ -112,38 +193,119 @@ func (api *API) LambdaProxy(arn string) http.HandlerFunc {
 		for k, v := range r.URL.Query() {
 			qParams[k] = strings.Join(v, " ")
 		}
-		body, err := io.ReadAll(r.Body)
+		payload = requestAuthorizerEvent{
+			Type:      "REQUEST",
+			MethodArn: methodARN,
+			APIGatewayProxyRequest: events.APIGatewayProxyRequest{
+				Resource:              resourcePath(r),
+				Path:                  stage.requestPath(r),
+				HTTPMethod:            r.Method,
+				QueryStringParameters: qParams,
+				Headers:               headers,
+				PathParameters:        params,
+				StageVariables:        stage.Variables,
+				RequestContext:        stage.requestContext(r),
+			},
+		}
+	default:
+		payload = events.APIGatewayCustomAuthorizerRequest{
+			Type:               "TOKEN",
+			AuthorizationToken: identity[0],
+			MethodArn:          methodARN,
+		}
+	}
+	authResponse, err := api.lambs.Invoke(arn, payload)
+	var fnErr *lambstack.FunctionError
+	if errors.As(err, &fnErr) && fnErr.Message == "Unauthorized" {
+		// authorizers reject requests by failing with the Unauthorized error
+		subl.Info().Msg("authorizer returned unauthorized")
+		api.gatewayError(w, r, unauthorized, "Unauthorized")
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to invoke authorizer")
+		api.gatewayError(w, r, authorizerConfigurationError, "")
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+	parse := parseAuthorizerResponse
+	if authorizer.EnableSimpleResponses {
+		parse = parseSimpleResponse
+	}
+	auth, err := parse(authResponse)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to unmarshal authorizer response")
+		api.gatewayError(w, r, authorizerConfigurationError, "")
+	}
+	return auth, err
+}
+
+// proxyEvent is the lambda proxy integration event of the request.
+func (api *API) proxyEvent(r *http.Request, stage *Stage) (events.APIGatewayProxyRequest, error) {
+	headers := make(map[string]string)
+	for key := range r.Header {
+		headers[key] = r.Header.Get(key)
+	}
+	qParams := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		qParams[k] = strings.Join(v, " ")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+	defer r.Body.Close()
+	payload := events.APIGatewayProxyRequest{
+		Resource:              resourcePath(r),
+		Path:                  stage.requestPath(r),
+		HTTPMethod:            r.Method,
+		QueryStringParameters: qParams,
+		Headers:               headers,
+		PathParameters:        mux.Vars(r),
+		StageVariables:        stage.Variables,
+		Body:                  string(body),
+		RequestContext:        stage.requestContext(r),
+	}
+	if api.isBinary(r.Header.Get("Content-Type")) {
+		payload.Body = base64.StdEncoding.EncodeToString(body)
+		payload.IsBase64Encoded = true
+	}
+
+	if auth := r.Context().Value(AuthorizerContext); auth != nil {
+		payload.RequestContext.Authorizer = auth.(events.APIGatewayCustomAuthorizerResponse).Context
+	}
+	return payload, nil
+}
+
+func (api *API) LambdaProxy(arn string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stage := api.stageFromRequest(r)
+		arn := stage.lambdaARN(arn)
+		payload, err := api.proxyEvent(r, stage)
 		if err != nil {
 			log.Error().Err(err).Str("arn", arn).Msg("unable to read body")
-			w.WriteHeader(http.StatusInternalServerError)
+			api.gatewayError(w, r, default5XX, "Internal server error")
 			return
 		}
-		defer r.Body.Close()
-		payload := events.APIGatewayProxyRequest{
-			Resource:              "/{proxy+}",
-			Path:                  strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/restapis/%s", api.ID)),
-			HTTPMethod:            r.Method,
-			QueryStringParameters: qParams,
-			Headers:               headers,
-			PathParameters:        params,
-			Body:                  string(body),
+		b, err := api.invoke(arn, payload)
+		if api.limitError(w, r, err) {
+			log.Info().Err(err).Str("arn", arn).Msg("lambda invocation over the limits")
+			return
 		}
-
-		if auth := r.Context().Value(AuthorizerContext); auth != nil {
-			payload.RequestContext = events.APIGatewayProxyRequestContext{
-				Authorizer: auth.(events.APIGatewayCustomAuthorizerResponse).Context,
-			}
+		var fnErr *lambstack.FunctionError
+		if errors.As(err, &fnErr) {
+			log.Error().Err(err).Str("arn", arn).Msg("lambda returned an error")
+			api.gatewayErrorStatus(w, r, default5XX, http.StatusBadGateway, "Internal server error")
+			return
 		}
-		b, err := api.lambs.Invoke(arn, payload)
 		if err != nil {
 			log.Error().Err(err).Str("arn", arn).Msg("unable to invoke lambda")
-			w.WriteHeader(http.StatusInternalServerError)
+			api.gatewayError(w, r, default5XX, "Internal server error")
 			return
 		}
 		var resp events.APIGatewayProxyResponse
 		if err = json.Unmarshal(b, &resp); err != nil {
 			log.Error().Err(err).Str("arn", arn).Msg("unable to unmarshal lambda response")
-			w.WriteHeader(http.StatusInternalServerError)
+			api.gatewayErrorStatus(w, r, default5XX, http.StatusBadGateway, "Internal server error")
 			return
 		}
 
@@ -165,8 +327,47 @@ func (api *API) LambdaProxy(arn string) http.HandlerFunc {
 	}
 }
 
+// LambdaProxyStream invokes the lambda with the proxy event and streams its response to the client as it is
+// written by the lambda. Http integration responses start with the status code and headers of the response as
+// a json prelude followed by 8 null bytes before the body.
+func (api *API) LambdaProxyStream(arn string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-lambda-proxy-stream").Logger()
+		stage := api.stageFromRequest(r)
+		arn := stage.lambdaARN(arn)
+		payload, err := api.proxyEvent(r, stage)
+		if err != nil {
+			subl.Error().Err(err).Str("arn", arn).Msg("unable to read body")
+			api.gatewayError(w, r, default5XX, "Internal server error")
+			return
+		}
+		stream := lambstack.NewHTTPResponseWriter(w)
+		stream.ContentType = defaultContentType
+		err = api.lambs.InvokeWithResponseStream(arn, payload, stream)
+		if err == nil {
+			err = stream.Close()
+		}
+		if err == nil {
+			return
+		}
+		if stream.Started() {
+			// the status has been sent, so the response is cut short
+			subl.Error().Err(err).Str("arn", arn).Msg("lambda response stream failed")
+			return
+		}
+		var fnErr *lambstack.FunctionError
+		if errors.As(err, &fnErr) {
+			subl.Error().Err(err).Str("arn", arn).Msg("lambda returned an error")
+			api.gatewayErrorStatus(w, r, default5XX, http.StatusBadGateway, "Internal server error")
+			return
+		}
+		subl.Error().Err(err).Str("arn", arn).Msg("unable to invoke lambda")
+		api.gatewayError(w, r, default5XX, "Internal server error")
+	}
+}
+
 type detailedResponseWriter struct {
-	http.ResponseWriter
+	mw.ResponseWriter
 	statusCode int
 }
 
@@ -182,7 +383,7 @@ func (dw *detailedResponseWriter) StatusCode() int {
 func Logger(next http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		dw := &detailedResponseWriter{ResponseWriter: w}
+		dw := &detailedResponseWriter{ResponseWriter: mw.ResponseWriter{ResponseWriter: w}}
 		next.ServeHTTP(dw, r)
 		log.Info().
 			Str("name", name).
@@ -10,6 +10,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,24 +26,24 @@ func TestAPI_Authorizer(t *testing.T) {
 	f := &mockFactory{
 		responses: map[string]func(payload any) ([]byte, error){
 			"arn:aws:lambda:us-east-1:123456789012:function:request-auth-allow": func(_ any) ([]byte, error) {
-				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Allow", Resource: []string{"my-resource"}}}}, Context: map[string]interface{}{}}
+				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Allow", Resource: []string{"arn:aws:execute-api:us-east-1:123456789012:unit-test/*"}}}}, Context: map[string]interface{}{}}
 				requestAuthAllowCalls++
 				return json.Marshal(&resp)
 			},
 			"arn:aws:lambda:us-east-1:123456789012:function:token-auth-allow": func(_ any) ([]byte, error) {
-				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Allow", Resource: []string{"my-resource"}}}}, Context: map[string]interface{}{}}
+				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Allow", Resource: []string{"arn:aws:execute-api:us-east-1:123456789012:unit-test/*"}}}}, Context: map[string]interface{}{}}
 				tokenAuthAllowCalls++
 				return json.Marshal(&resp)
 			},
 			"arn:aws:lambda:us-east-1:123456789012:function:request-auth-deny": func(_ any) ([]byte, error) {
-				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Deny", Resource: []string{"my-resource"}}}}, Context: map[string]interface{}{}}
+				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Deny", Resource: []string{"arn:aws:execute-api:us-east-1:123456789012:unit-test/*"}}}}, Context: map[string]interface{}{}}
 				requestAuthDenyCalls++
 				return json.Marshal(&resp)
 			},
 		},
 	}
 	r := mux.NewRouter()
-	api := New(r, f, "unit-test")
+	api := New(r, f, config.APIGW{ID: "unit-test"})
 
 	tests := []struct {
 		name, arn, authType string
@@ -54,6 +55,7 @@ func TestAPI_Authorizer(t *testing.T) {
 			req:  unauthenticatedGET(t),
 			validate: func(t *testing.T, rec *httptest.ResponseRecorder) {
 				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+				assert.JSONEq(t, `{"message":"Unauthorized"}`, rec.Body.String())
 			},
 		},
 		{
@@ -84,7 +86,8 @@ func TestAPI_Authorizer(t *testing.T) {
 			authType: "request",
 			req:      authenticatedGET(t, "deny"),
 			validate: func(t *testing.T, rec *httptest.ResponseRecorder) {
-				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+				assert.JSONEq(t, `{"message":"User is not authorized to access this resource with an explicit deny"}`, rec.Body.String())
 				assert.Equal(t, 1, requestAuthDenyCalls)
 			},
 		},
@@ -94,7 +97,8 @@ func TestAPI_Authorizer(t *testing.T) {
 			authType: "request",
 			req:      authenticatedGET(t, "deny"),
 			validate: func(t *testing.T, rec *httptest.ResponseRecorder) {
-				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+				assert.JSONEq(t, `{"message":"User is not authorized to access this resource with an explicit deny"}`, rec.Body.String())
 				assert.Equal(t, 1, requestAuthDenyCalls)
 			},
 		},
@@ -113,12 +117,215 @@ func TestAPI_Authorizer(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			rec := httptest.NewRecorder()
-			api.Authorizer(tt.arn, tt.authType, echo()).ServeHTTP(rec, tt.req)
+			auth := XAmazonAPIGatewayAuthorizer{Type: tt.authType, AuthorizerURI: tt.arn, IdentitySource: "method.request.header.Authorization"}
+			api.Authorizer(auth, echo()).ServeHTTP(rec, tt.req)
 			tt.validate(t, rec)
 		})
 	}
 }
 
+func TestAPI_AuthorizerIdentitySources(t *testing.T) {
+	calls := map[string]int{}
+	allow := func(name string) func(payload any) ([]byte, error) {
+		return func(_ any) ([]byte, error) {
+			calls[name]++
+			resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"execute-api:Invoke"}, Effect: "Allow", Resource: []string{"*"}}}}}
+			return json.Marshal(&resp)
+		}
+	}
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:one": allow("one"),
+			"arn:aws:lambda:us-east-1:123456789012:function:two": allow("two"),
+		},
+	}
+	r := mux.NewRouter()
+	api := New(r, f, config.APIGW{ID: "unit-test", Stages: []config.APIStage{{Name: "dev", Variables: map[string]string{"tenant": "acme"}}}})
+	zero := 0
+
+	tests := []struct {
+		name     string
+		auth     XAmazonAPIGatewayAuthorizer
+		query    string
+		header   string
+		status   int
+		expected map[string]int
+	}{
+		{
+			name:     "requests missing an identity source are rejected",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "request", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:one", IdentitySource: "method.request.header.X-Api-Token, method.request.querystring.user"},
+			header:   "token",
+			status:   http.StatusUnauthorized,
+			expected: map[string]int{},
+		},
+		{
+			name:     "requests with all identity sources are authorized",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "request", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:one", IdentitySource: "method.request.header.X-Api-Token, method.request.querystring.user, stageVariables.tenant, context.stage"},
+			query:    "user=bob",
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 1},
+		},
+		{
+			name:     "results are cached by the identity source values",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "request", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:one", IdentitySource: "method.request.header.X-Api-Token, method.request.querystring.user, stageVariables.tenant, context.stage"},
+			query:    "user=bob",
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 1},
+		},
+		{
+			name:     "different identity source values are not cached",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "request", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:one", IdentitySource: "method.request.header.X-Api-Token, method.request.querystring.user, stageVariables.tenant, context.stage"},
+			query:    "user=alice",
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 2},
+		},
+		{
+			name:     "results are cached per authorizer",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "request", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:two", IdentitySource: "method.request.header.X-Api-Token, method.request.querystring.user, stageVariables.tenant, context.stage"},
+			query:    "user=alice",
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 2, "two": 1},
+		},
+		{
+			name:     "a ttl of zero disables caching",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "token", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:two", IdentitySource: "method.request.header.X-Api-Token", AuthorizerResultTTLInSeconds: &zero},
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 2, "two": 2},
+		},
+		{
+			name:     "a ttl of zero disables caching for repeat requests",
+			auth:     XAmazonAPIGatewayAuthorizer{Type: "token", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:two", IdentitySource: "method.request.header.X-Api-Token", AuthorizerResultTTLInSeconds: &zero},
+			header:   "token",
+			status:   http.StatusOK,
+			expected: map[string]int{"one": 2, "two": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/test?"+tt.query, nil)
+			req.Header.Set("X-Api-Token", tt.header)
+			req = req.WithContext(context.WithValue(req.Context(), StageContext, api.stages[0]))
+			api.Authorizer(tt.auth, echo()).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.expected, calls)
+		})
+	}
+}
+
+func TestAPI_AuthorizerMethodARN(t *testing.T) {
+	var methodARNs []string
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:narrow": func(payload any) ([]byte, error) {
+				event, ok := payload.(events.APIGatewayCustomAuthorizerRequest)
+				require.Truef(t, ok, "event must be events.APIGatewayCustomAuthorizerRequest")
+				methodARNs = append(methodARNs, event.MethodArn)
+				// only the method of the first request is allowed
+				resp := events.APIGatewayCustomAuthorizerResponse{PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{Statement: []events.IAMPolicyStatement{{Action: []string{"execute-api:Invoke"}, Effect: "Allow", Resource: []string{event.MethodArn}}}}}
+				return json.Marshal(&resp)
+			},
+		},
+	}
+	r := mux.NewRouter()
+	api := New(r, f, config.APIGW{ID: "unit-test", Stages: []config.APIStage{{Name: "dev"}}})
+	auth := XAmazonAPIGatewayAuthorizer{Type: "token", AuthorizerURI: "arn:aws:lambda:us-east-1:123456789012:function:narrow"}
+
+	tests := []struct {
+		name, method, host, path string
+		status                   int
+	}{
+		{name: "the policy allows the method", method: http.MethodGet, host: "api.127.0.0.1.nip.io", path: "/pets/1", status: http.StatusOK},
+		{name: "the cached policy allows the same method", method: http.MethodGet, host: "api.127.0.0.1.nip.io", path: "/pets/1", status: http.StatusOK},
+		{name: "the cached policy is evaluated for other methods", method: http.MethodDelete, host: "api.127.0.0.1.nip.io", path: "/pets/1", status: http.StatusForbidden},
+		{name: "the cached policy is evaluated for other paths", method: http.MethodGet, host: "unit-test.execute-api.eu-west-2.amazonaws.com", path: "/pets/2", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Host = tt.host
+			req.Header.Set("Authorization", "token")
+			req = req.WithContext(context.WithValue(req.Context(), StageContext, api.stages[0]))
+			api.Authorizer(auth, echo()).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+	assert.Equal(t, []string{"arn:aws:execute-api:us-east-1:123456789012:unit-test/dev/GET/pets/1"}, methodARNs)
+
+	req := httptest.NewRequest(http.MethodGet, "/pets/2", nil)
+	req.Host = "unit-test.execute-api.eu-west-2.amazonaws.com:8080"
+	assert.Equal(t, "arn:aws:execute-api:eu-west-2:123456789012:unit-test/dev/GET/pets/2", api.stages[0].methodARN(req))
+}
+
+func TestAPI_AuthorizerPayloadV2(t *testing.T) {
+	var authEvents []events.APIGatewayV2CustomAuthorizerV2Request
+	var proxyEvent events.APIGatewayProxyRequest
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple": func(payload any) ([]byte, error) {
+				event, ok := payload.(events.APIGatewayV2CustomAuthorizerV2Request)
+				require.Truef(t, ok, "event must be events.APIGatewayV2CustomAuthorizerV2Request")
+				authEvents = append(authEvents, event)
+				return json.Marshal(events.APIGatewayV2CustomAuthorizerSimpleResponse{
+					IsAuthorized: event.IdentitySource[0] == "allow",
+					Context:      map[string]any{"tenant": "acme"},
+				})
+			},
+			"arn:aws:lambda:us-east-1:123456789012:function:echo": func(payload any) ([]byte, error) {
+				proxyEvent = payload.(events.APIGatewayProxyRequest)
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "OK!"})
+			},
+		},
+	}
+	r := mux.NewRouter()
+	api := New(r, f, config.APIGW{ID: "unit-test"})
+	auth := XAmazonAPIGatewayAuthorizer{
+		Type:                           "request",
+		AuthorizerURI:                  "arn:aws:lambda:us-east-1:123456789012:function:simple",
+		IdentitySource:                 "$request.header.Authorization, $request.querystring.tenant",
+		AuthorizerPayloadFormatVersion: "2.0",
+		EnableSimpleResponses:          true,
+	}
+	handler := api.Authorizer(auth, api.LambdaProxy("arn:aws:lambda:us-east-1:123456789012:function:echo"))
+
+	tests := []struct {
+		name, token, query string
+		status             int
+		expected           string
+	}{
+		{name: "missing identity sources are unauthorized", token: "allow", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "simple responses authorize the request", token: "allow", query: "?tenant=acme", status: http.StatusOK, expected: "OK!"},
+		{name: "simple responses deny the request", token: "deny", query: "?tenant=acme", status: http.StatusForbidden, expected: `{"message":"Forbidden"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/pets"+tt.query, nil)
+			req.Header.Set("Authorization", tt.token)
+			req.Header.Add("X-Multi", "a")
+			req.Header.Add("X-Multi", "b")
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.expected, rec.Body.String())
+		})
+	}
+	require.Len(t, authEvents, 2)
+	assert.Equal(t, "2.0", authEvents[0].Version)
+	assert.Equal(t, "REQUEST", authEvents[0].Type)
+	assert.Equal(t, []string{"allow", "acme"}, authEvents[0].IdentitySource)
+	assert.Equal(t, "arn:aws:execute-api:us-east-1:123456789012:unit-test/$default/GET/pets", authEvents[0].RouteArn)
+	assert.Equal(t, "a,b", authEvents[0].Headers["x-multi"])
+	assert.Equal(t, map[string]string{"tenant": "acme"}, authEvents[0].QueryStringParameters)
+	assert.Equal(t, "$default", authEvents[0].RequestContext.Stage)
+	assert.Equal(t, map[string]any{"lambda": map[string]any{"tenant": "acme"}}, proxyEvent.RequestContext.Authorizer)
+}
+
 func TestAPI_LambdaProxy(t *testing.T) {
 	var requestedCalls = 0
 	f := &mockFactory{
@@ -132,7 +339,7 @@ func TestAPI_LambdaProxy(t *testing.T) {
 		},
 	}
 	r := mux.NewRouter()
-	api := New(r, f, "unit-test")
+	api := New(r, f, config.APIGW{ID: "unit-test"})
 
 	tests := []struct {
 		name, arn string
@@ -0,0 +1,132 @@
+package apigw
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const implicitDenyMessage = "User is not authorized to access this resource"
+
+// stringList is an IAM policy element that is either a single value or a list of values.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*l = []string{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// parseAuthorizerResponse unmarshals the authorizer response, the policy statement actions and
+// resources may be single values as well as lists.
+func parseAuthorizerResponse(b []byte) (events.APIGatewayCustomAuthorizerResponse, error) {
+	var raw struct {
+		events.APIGatewayCustomAuthorizerResponse
+		PolicyDocument struct {
+			Version   string
+			Statement []struct {
+				Effect   string
+				Action   stringList
+				Resource stringList
+			}
+		} `json:"policyDocument"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+	resp := raw.APIGatewayCustomAuthorizerResponse
+	resp.PolicyDocument = events.APIGatewayCustomAuthorizerPolicy{Version: raw.PolicyDocument.Version}
+	for _, s := range raw.PolicyDocument.Statement {
+		resp.PolicyDocument.Statement = append(resp.PolicyDocument.Statement, events.IAMPolicyStatement{
+			Effect:   s.Effect,
+			Action:   s.Action,
+			Resource: s.Resource,
+		})
+	}
+	return resp, nil
+}
+
+// parseSimpleResponse converts the simple response of an HTTP API authorizer into a policy allowing or
+// denying every route, as simple responses are not specific to the route they were returned for.
+func parseSimpleResponse(b []byte) (events.APIGatewayCustomAuthorizerResponse, error) {
+	var simple events.APIGatewayV2CustomAuthorizerSimpleResponse
+	if err := json.Unmarshal(b, &simple); err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+	effect := "Deny"
+	if simple.IsAuthorized {
+		effect = "Allow"
+	}
+	return events.APIGatewayCustomAuthorizerResponse{
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version:   "2012-10-17",
+			Statement: []events.IAMPolicyStatement{{Effect: effect, Action: []string{"execute-api:Invoke"}, Resource: []string{"*"}}},
+		},
+		Context: simple.Context,
+	}, nil
+}
+
+// evaluatePolicy evaluates the policy for invoking the method arn with IAM semantics, an explicit deny
+// wins over any allow and a method not allowed by any statement is denied.
+func evaluatePolicy(policy events.APIGatewayCustomAuthorizerPolicy, methodARN string) (allowed, explicitDeny bool) {
+	for _, statement := range policy.Statement {
+		if !matchesAny(statement.Action, "execute-api:Invoke", true) || !matchesAny(statement.Resource, methodARN, false) {
+			continue
+		}
+		switch strings.ToLower(statement.Effect) {
+		case "deny":
+			return false, true
+		case "allow":
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+func matchesAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, p := range patterns {
+		if ignoreCase {
+			p, value = strings.ToLower(p), strings.ToLower(value)
+		}
+		if wildcardMatch(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches the value against an IAM pattern where * matches any sequence of characters,
+// including slashes, and ? matches a single character.
+func wildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
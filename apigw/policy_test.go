@@ -0,0 +1,91 @@
+package apigw
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_evaluatePolicy(t *testing.T) {
+	const methodARN = "arn:aws:execute-api:us-east-1:123456789012:api/dev/GET/pets/1"
+	statement := func(effect, action, resource string) events.IAMPolicyStatement {
+		return events.IAMPolicyStatement{Effect: effect, Action: []string{action}, Resource: []string{resource}}
+	}
+	tests := []struct {
+		name                  string
+		statements            []events.IAMPolicyStatement
+		allowed, explicitDeny bool
+	}{
+		{name: "no statements are denied"},
+		{
+			name:       "exact resources are allowed",
+			statements: []events.IAMPolicyStatement{statement("Allow", "execute-api:Invoke", methodARN)},
+			allowed:    true,
+		},
+		{
+			name:       "wildcard resources span path segments",
+			statements: []events.IAMPolicyStatement{statement("Allow", "execute-api:*", "arn:aws:execute-api:*:*:api/*/GET/*")},
+			allowed:    true,
+		},
+		{
+			name:       "single character wildcards",
+			statements: []events.IAMPolicyStatement{statement("Allow", "*", "arn:aws:execute-api:us-east-1:123456789012:api/de?/GET/pets/?")},
+			allowed:    true,
+		},
+		{
+			name:       "statements for other methods do not allow",
+			statements: []events.IAMPolicyStatement{statement("Allow", "execute-api:Invoke", "arn:aws:execute-api:us-east-1:123456789012:api/dev/POST/*")},
+		},
+		{
+			name:       "statements for other actions do not allow",
+			statements: []events.IAMPolicyStatement{statement("Allow", "execute-api:ManageConnections", "*")},
+		},
+		{
+			name: "explicit deny wins",
+			statements: []events.IAMPolicyStatement{
+				statement("Allow", "execute-api:Invoke", "*"),
+				statement("Deny", "execute-api:Invoke", "arn:aws:execute-api:us-east-1:123456789012:api/dev/GET/pets/*"),
+			},
+			explicitDeny: true,
+		},
+		{
+			name: "deny statements for other resources are ignored",
+			statements: []events.IAMPolicyStatement{
+				statement("Allow", "execute-api:Invoke", "*"),
+				statement("Deny", "execute-api:Invoke", "arn:aws:execute-api:us-east-1:123456789012:api/dev/DELETE/*"),
+			},
+			allowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, explicitDeny := evaluatePolicy(events.APIGatewayCustomAuthorizerPolicy{Statement: tt.statements}, methodARN)
+			assert.Equal(t, tt.allowed, allowed)
+			assert.Equal(t, tt.explicitDeny, explicitDeny)
+		})
+	}
+}
+
+func Test_parseAuthorizerResponse(t *testing.T) {
+	resp, err := parseAuthorizerResponse([]byte(`{
+		"principalId": "user",
+		"policyDocument": {
+			"Version": "2012-10-17",
+			"Statement": [
+				{"Action": "execute-api:Invoke", "Effect": "Allow", "Resource": "arn:aws:execute-api:*"},
+				{"Action": ["execute-api:Invoke"], "Effect": "Deny", "Resource": ["a", "b"]}
+			]
+		},
+		"context": {"tenant": "acme"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "user", resp.PrincipalID)
+	assert.Equal(t, map[string]any{"tenant": "acme"}, resp.Context)
+	assert.Equal(t, "2012-10-17", resp.PolicyDocument.Version)
+	assert.Equal(t, []events.IAMPolicyStatement{
+		{Action: []string{"execute-api:Invoke"}, Effect: "Allow", Resource: []string{"arn:aws:execute-api:*"}},
+		{Action: []string{"execute-api:Invoke"}, Effect: "Deny", Resource: []string{"a", "b"}},
+	}, resp.PolicyDocument.Statement)
+}
@@ -0,0 +1,191 @@
+package apigw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/rs/zerolog/log"
+)
+
+// authorization wraps a handler with the checks of a security requirement, the handler is only called once
+// the request is authorized.
+type authorization func(h http.HandlerFunc) http.HandlerFunc
+
+// operationSecurity is the security requirement of the operation, an operation level requirement replaces the
+// requirement of the spec, so an empty security list on the operation disables the requirement of the spec.
+func operationSecurity(spec *openapi3.T, op *openapi3.Operation) openapi3.SecurityRequirements {
+	if op.Security != nil {
+		return *op.Security
+	}
+	return spec.Security
+}
+
+// secure wraps the integration with the security requirements of the operation. Each requirement is an
+// alternative, the first to authorize the request is used, and all of the schemes within a requirement
+// must authorize the request.
+func (api *API) secure(spec *openapi3.T, path, method string, op *openapi3.Operation, secReqs openapi3.SecurityRequirements, integration http.HandlerFunc) (http.HandlerFunc, error) {
+	source, required := apiKeySource(spec, op)
+	if required {
+		// the api key source extension of the operation requires a key whatever the alternative
+		integration = api.APIKey(source, integration)
+	}
+	alternatives := make([]alternative, 0, len(secReqs))
+	for _, req := range secReqs {
+		alt, err := api.requirement(spec, path, method, op, req, source, !required)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, alt)
+	}
+	switch len(alternatives) {
+	case 0:
+		return integration, nil
+	case 1:
+		return alternatives[0].authorize(alternatives[0].charge(integration)), nil
+	}
+	return api.anyOf(alternatives, integration), nil
+}
+
+// alternative is a security requirement, the api key of the requirement is checked after its authorizers.
+type alternative struct {
+	authorizers []authorization
+	apiKey      bool
+	source      string
+	api         *API
+}
+
+// authorize wraps the handler with the authorizers of the requirement.
+func (a alternative) authorize(h http.HandlerFunc) http.HandlerFunc {
+	for i := len(a.authorizers) - 1; i >= 0; i-- {
+		h = a.authorizers[i](h)
+	}
+	return h
+}
+
+// charge wraps the handler with the api key check of the requirement, applying the usage plan throttle and quota.
+func (a alternative) charge(h http.HandlerFunc) http.HandlerFunc {
+	if a.apiKey {
+		h = a.api.APIKey(a.source, h)
+	}
+	return h
+}
+
+// verify wraps the handler with the api key check of the requirement without applying the usage plan throttle
+// and quota, so alternatives that are not used are not charged.
+func (a alternative) verify(h http.HandlerFunc) http.HandlerFunc {
+	if a.apiKey {
+		h = a.api.validAPIKey(a.source, h)
+	}
+	return h
+}
+
+// requirement combines the schemes of a security requirement, authorizers run first so api keys can be taken
+// from their usage identifier key. Schemes without an authorizer are not enforced.
+func (api *API) requirement(spec *openapi3.T, path, method string, op *openapi3.Operation, req openapi3.SecurityRequirement, source string, checkAPIKey bool) (alternative, error) {
+	var authorizers []authorization
+	var apiKey bool
+	for _, name := range sortedKeys(req) {
+		sec := spec.Components.SecuritySchemes[name]
+		if isAPIKeyScheme(sec) {
+			apiKey = checkAPIKey
+			continue
+		}
+		if val, ok := sec.Value.Extensions[authorizerExtension]; ok {
+			var auth XAmazonAPIGatewayAuthorizer
+			if err := decodeExtension(val, &auth); err != nil {
+				return alternative{}, fmt.Errorf("unable to parse x-amazon-apigateway-authorizer extension for %s error: %w", name, err)
+			}
+			api.checkAuthorizer(path, method, op, name, auth)
+			scopes := req[name]
+			if strings.EqualFold(auth.Type, "cognito_user_pools") {
+				authorizers = append(authorizers, func(h http.HandlerFunc) http.HandlerFunc {
+					return api.CognitoAuthorizer(auth, scopes, h)
+				})
+				continue
+			}
+			authorizers = append(authorizers, func(h http.HandlerFunc) http.HandlerFunc {
+				return api.Authorizer(auth, h)
+			})
+		} else if isSigV4Scheme(name, sec) {
+			authorizers = append(authorizers, api.IAMAuthorizer)
+		} else {
+			api.diagnose(path, method, op, "security."+name, "the security scheme has no authorizer and is not enforced")
+		}
+	}
+	return alternative{authorizers: authorizers, apiKey: apiKey, source: source, api: api}, nil
+}
+
+// anyOf authorizes the request with the first of the alternatives to succeed, only its api key is charged to the
+// usage plans. The responses of the alternatives are buffered, so when none authorize the request the response of
+// the last one is written.
+func (api *API) anyOf(alternatives []alternative, integration http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				log.Error().Err(err).Str("handler", "apigateway-security").Msg("unable to read body")
+				api.gatewayError(w, r, default5XX, "Internal server error")
+				return
+			}
+		}
+		var rejected *bufferedResponseWriter
+		for _, alt := range alternatives {
+			var authorized *http.Request
+			rejected = newBufferedResponseWriter()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			alt.authorize(alt.verify(func(_ http.ResponseWriter, r *http.Request) {
+				authorized = r
+			}))(rejected, r)
+			if authorized != nil {
+				clearGatewayError(authorized)
+				authorized.Body = io.NopCloser(bytes.NewReader(body))
+				alt.charge(integration)(w, authorized)
+				return
+			}
+		}
+		rejected.writeTo(w)
+	}
+}
+
+// bufferedResponseWriter keeps a response so it can be written later, or discarded.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: http.Header{}}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(code int) {
+	if b.statusCode == 0 {
+		b.statusCode = code
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponseWriter) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.statusCode != 0 {
+		w.WriteHeader(b.statusCode)
+	}
+	_, _ = w.Write(b.body.Bytes())
+}
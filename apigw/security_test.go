@@ -0,0 +1,139 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportSecurityRequirements(t *testing.T) {
+	invocations := 0
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:request-auth": func(payload any) ([]byte, error) {
+				invocations++
+				effect := "Allow"
+				if payload.(requestAuthorizerEvent).Headers["Authorization"] != "allow" {
+					effect = "Deny"
+				}
+				return json.Marshal(events.APIGatewayCustomAuthorizerResponse{
+					PrincipalID: "user",
+					PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+						Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: effect, Resource: []string{"*"}}},
+					},
+				})
+			},
+		},
+	}
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/security.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	api := New(r, f, config.APIGW{ID: "security", Stages: []config.APIStage{{Name: "prod"}}})
+	api.UsagePlans = NewUsagePlans([]config.APIKey{{Name: "one", Value: "key-one"}}, []config.UsagePlan{{
+		Name:      "basic",
+		APIKeys:   []string{"one"},
+		APIStages: []config.UsagePlanStage{{API: "security", Stage: "prod"}},
+	}})
+	require.NoError(t, api.Import(doc))
+	assert.Empty(t, api.Diagnostics())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, path, authorization, key string
+		status                         int
+		expected                       string
+		invocations                    int
+	}{
+		{name: "root requirement", path: "/default", authorization: "allow", status: http.StatusOK, expected: `{"principal": "user"}`, invocations: 1},
+		{name: "root requirement unauthorized", path: "/default", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+		{name: "empty requirement disables the root requirement", path: "/public", status: http.StatusOK, expected: `{"principal": ""}`},
+		{name: "either with the authorizer", path: "/either", authorization: "allow", status: http.StatusOK, expected: `{"principal": "user"}`, invocations: 1},
+		{name: "either with an api key", path: "/either", key: "key-one", status: http.StatusOK, expected: `{"principal": ""}`},
+		{name: "either denied by the authorizer with an api key", path: "/either", authorization: "deny", key: "key-one", status: http.StatusOK, expected: `{"principal": ""}`, invocations: 1},
+		{name: "either without credentials", path: "/either", status: http.StatusForbidden, expected: `{"message":"Forbidden"}`},
+		{name: "both", path: "/both", authorization: "allow", key: "key-one", status: http.StatusOK, expected: `{"principal": "user"}`, invocations: 1},
+		{name: "both without an api key", path: "/both", authorization: "allow", status: http.StatusForbidden, expected: `{"message":"Forbidden"}`, invocations: 1},
+		{name: "both without the authorizer", path: "/both", key: "key-one", status: http.StatusUnauthorized, expected: `{"message":"Unauthorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invocations = 0
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/security/prod/_user_request_"+tt.path, nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.key != "" {
+				req.Header.Set("x-api-key", tt.key)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+			assert.Equal(t, tt.invocations, invocations)
+		})
+	}
+}
+
+func Test_SecurityRequirementsChargeTheAPIKeyOnce(t *testing.T) {
+	invocations := 0
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:request-auth": func(payload any) ([]byte, error) {
+				invocations++
+				return json.Marshal(events.APIGatewayCustomAuthorizerResponse{
+					PrincipalID: "user",
+					PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+						Statement: []events.IAMPolicyStatement{{Action: []string{"*"}, Effect: "Allow", Resource: []string{"*"}}},
+					},
+				})
+			},
+		},
+	}
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/security.yml")
+	require.NoError(t, err)
+	api := New(r, f, config.APIGW{ID: "security", Stages: []config.APIStage{{Name: "prod"}}})
+	// the burst allows a second request, so only the quota rejects it
+	api.UsagePlans = NewUsagePlans([]config.APIKey{{Name: "one", Value: "key-one"}}, []config.UsagePlan{{
+		Name:      "basic",
+		APIKeys:   []string{"one"},
+		APIStages: []config.UsagePlanStage{{API: "security", Stage: "prod"}},
+		Throttle:  &config.Throttle{BurstLimit: 2},
+		Quota:     &config.Quota{Limit: 1, Period: "DAY"},
+	}})
+	require.NoError(t, api.Import(doc))
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+apiHostName+"/security/prod/_user_request_/key-or-both", nil)
+		req.Header.Set("Authorization", "allow")
+		req.Header.Set("x-api-key", "key-one")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, api.UsagePlans.quotas["basic\x00one"].count)
+
+	w = get()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, `{"message":"Limit Exceeded"}`, w.Body.String(), "the second alternative must not be throttled by the first")
+	assert.Equal(t, 1, api.UsagePlans.quotas["basic\x00one"].count)
+	assert.Zero(t, invocations, "the first alternative authorizes the request")
+}
@@ -0,0 +1,203 @@
+package apigw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/invopop/yaml"
+)
+
+// LoadSpec loads an OpenAPI 3 or Swagger 2.0 spec from a YAML or JSON file, Swagger 2.0 specs such as
+// those exported from API Gateway are converted to OpenAPI 3 keeping their x-amazon-apigateway extensions.
+// References to other local files are resolved relative to the file referencing them.
+func LoadSpec(path string) (*openapi3.T, error) {
+	data, err := readSpecFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		Swagger string `json:"swagger"`
+		OpenAPI string `json:"openapi"`
+	}
+	if err = json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("unable to parse spec %s error: %w", path, err)
+	}
+	switch {
+	case strings.HasPrefix(version.Swagger, "2."):
+		return loadSwagger(path, data)
+	case strings.HasPrefix(version.OpenAPI, "3."):
+		loader := openapi3.NewLoader()
+		loader.IsExternalRefsAllowed = true
+		return loader.LoadFromFile(path)
+	}
+	return nil, fmt.Errorf("unable to load spec %s, only swagger 2.0 and openapi 3 specs are supported", path)
+}
+
+// loadSwagger converts a Swagger 2.0 spec to OpenAPI 3. The converter can only resolve references within the
+// spec, so references to other files are inlined first.
+func loadSwagger(path string, data []byte) (*openapi3.T, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("unable to parse spec %s error: %w", path, err)
+	}
+	r := &refResolver{docs: map[string]any{path: raw}}
+	inlined, err := r.inline(raw, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(inlined); err != nil {
+		return nil, err
+	}
+	var doc2 openapi2.T
+	if err = doc2.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("unable to parse swagger spec %s error: %w", path, err)
+	}
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert swagger spec %s error: %w", path, err)
+	}
+	// the any method operations of exported specs are swagger operations too
+	for p, item := range doc2.Paths {
+		ext, ok := item.Extensions[anyMethodExtension]
+		if !ok {
+			continue
+		}
+		b, err := json.Marshal(ext)
+		if err != nil {
+			return nil, err
+		}
+		var op openapi2.Operation
+		if err = op.UnmarshalJSON(b); err != nil {
+			return nil, fmt.Errorf("unable to parse %s extension for %s error: %w", anyMethodExtension, p, err)
+		}
+		converted, err := openapi2conv.ToV3Operation(&doc2, doc.Components, item, &op, doc2.Consumes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert %s extension for %s error: %w", anyMethodExtension, p, err)
+		}
+		doc.Paths[p].Extensions[anyMethodExtension] = converted
+	}
+	return doc, nil
+}
+
+func readSpecFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spec %s error: %w", path, err)
+	}
+	// YAML is a superset of JSON so both are accepted
+	if data, err = yaml.YAMLToJSON(data); err != nil {
+		return nil, fmt.Errorf("unable to parse spec %s error: %w", path, err)
+	}
+	return data, nil
+}
+
+// refResolver inlines the references to other files of a spec, the documents are cached by file path.
+type refResolver struct {
+	docs map[string]any
+}
+
+// inline replaces the references to other files within the node with their values. References within
+// the spec itself are kept, but references within other files are resolved against those files.
+// The references being inlined are tracked to report circular references.
+func (r *refResolver) inline(node any, file string, resolving []string) (any, error) {
+	switch v := node.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok {
+			return r.resolve(ref, file, resolving)
+		}
+		result := make(map[string]any, len(v))
+		for k, child := range v {
+			inlined, err := r.inline(child, file, resolving)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = inlined
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(v))
+		for i, child := range v {
+			inlined, err := r.inline(child, file, resolving)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = inlined
+		}
+		return result, nil
+	}
+	return node, nil
+}
+
+func (r *refResolver) resolve(ref, file string, resolving []string) (any, error) {
+	location, pointer, _ := strings.Cut(ref, "#")
+	if location == "" && len(resolving) == 0 {
+		return map[string]any{"$ref": ref}, nil
+	}
+	if location != "" {
+		if u, err := url.Parse(location); err == nil && u.Scheme != "" {
+			return nil, fmt.Errorf("unable to resolve reference %s in %s, only references to local files are supported", ref, file)
+		}
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(filepath.Dir(file), location)
+		}
+		file = location
+	}
+	key := file + "#" + pointer
+	for _, k := range resolving {
+		if k == key {
+			return nil, fmt.Errorf("unable to resolve reference %s in %s, the reference is circular", ref, file)
+		}
+	}
+	doc, ok := r.docs[file]
+	if !ok {
+		data, err := readSpecFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("unable to parse spec %s error: %w", file, err)
+		}
+		r.docs[file] = doc
+	}
+	value, err := jsonPointer(doc, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve reference %s in %s error: %w", ref, file, err)
+	}
+	return r.inline(value, file, append(resolving, key))
+}
+
+// jsonPointer finds the value at the pointer, such as /definitions/Pet, within the document.
+func jsonPointer(doc any, pointer string) (any, error) {
+	if pointer == "" || pointer == "/" {
+		return doc, nil
+	}
+	value := doc
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := value.(type) {
+		case map[string]any:
+			child, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", pointer)
+			}
+			value = child
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("%s not found", pointer)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("%s not found", pointer)
+		}
+	}
+	return value, nil
+}
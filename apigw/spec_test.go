@@ -0,0 +1,143 @@
+package apigw
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadSpecSwagger(t *testing.T) {
+	doc, err := LoadSpec("examples/swagger.yml")
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Extensions, binaryMediaTypesExtension)
+
+	sec := doc.Components.SecuritySchemes["authorizer"]
+	require.NotNil(t, sec)
+	assert.Equal(t, "custom", sec.Value.Extensions["x-amazon-apigateway-authtype"])
+	assert.Contains(t, sec.Value.Extensions, "x-amazon-apigateway-authorizer")
+
+	post := doc.Paths.Find("/pets").Post
+	require.NotNil(t, post)
+	assert.Contains(t, post.Extensions, "x-amazon-apigateway-integration")
+	schema := post.RequestBody.Value.Content.Get("application/json").Schema.Value
+	require.NotNil(t, schema)
+	assert.Equal(t, []string{"name"}, schema.Required)
+	assert.Equal(t, "string", schema.Properties["tag"].Value.Type)
+
+	anyMethod, ok := doc.Paths.Find("/pets/{id}").Extensions[anyMethodExtension].(*openapi3.Operation)
+	require.True(t, ok)
+	assert.Equal(t, "id", anyMethod.Parameters[0].Value.Name)
+
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:echo": func(payload any) ([]byte, error) {
+				event := payload.(events.APIGatewayProxyRequest)
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: event.HTTPMethod + " " + event.Resource})
+			},
+			"arn:aws:lambda:us-east-1:123456789012:function:request-auth": func(_ any) ([]byte, error) {
+				return json.Marshal(events.APIGatewayCustomAuthorizerResponse{
+					PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+						Version: "2012-10-17",
+						Statement: []events.IAMPolicyStatement{
+							{Action: []string{"execute-api:Invoke"}, Effect: "Allow", Resource: []string{"*"}},
+						},
+					},
+				})
+			},
+		},
+	}
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "swagger"})
+	require.NoError(t, api.Import(doc))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		name, method, path, authorization string
+		status                            int
+		body                              string
+	}{
+		{name: "authorized post", method: http.MethodPost, path: "/pets", authorization: "token", status: http.StatusOK, body: "POST /pets"},
+		{name: "unauthorized post", method: http.MethodPost, path: "/pets", status: http.StatusUnauthorized},
+		{name: "any method", method: http.MethodDelete, path: "/pets/1", status: http.StatusOK, body: "DELETE /pets/{id}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+"/swagger"+tt.path, strings.NewReader(`{"name":"rex"}`))
+			require.NoError(t, err)
+			req.Host = apiHostName
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.body != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tt.body, string(body))
+			}
+		})
+	}
+}
+
+func Test_LoadSpecExternalRefs(t *testing.T) {
+	doc, err := LoadSpec("examples/external-refs.json")
+	require.NoError(t, err)
+	schema := doc.Paths.Find("/pets").Get.Responses.Get(200).Value.Content.Get("application/json").Schema.Value
+	require.NotNil(t, schema)
+	assert.Equal(t, "string", schema.Properties["name"].Value.Type)
+}
+
+func Test_LoadSpecErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "unknown version",
+			files: map[string]string{"spec.yml": "info:\n  title: example\n"},
+			err:   "only swagger 2.0 and openapi 3 specs are supported",
+		},
+		{
+			name: "circular reference",
+			files: map[string]string{
+				"spec.yml":  "swagger: \"2.0\"\ninfo:\n  title: example\n  version: \"1\"\npaths: {}\ndefinitions:\n  A:\n    $ref: other.yml#/B\n",
+				"other.yml": "B:\n  $ref: \"#/C\"\nC:\n  $ref: \"#/B\"\n",
+			},
+			err: "the reference is circular",
+		},
+		{
+			name: "remote reference",
+			files: map[string]string{
+				"spec.yml": "swagger: \"2.0\"\ninfo:\n  title: example\n  version: \"1\"\npaths: {}\ndefinitions:\n  A:\n    $ref: https://example.com/spec.yml#/B\n",
+			},
+			err: "only references to local files are supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+			}
+			_, err := LoadSpec(filepath.Join(dir, "spec.yml"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
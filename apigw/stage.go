@@ -0,0 +1,167 @@
+package apigw
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/mw"
+)
+
+const StageContext contextKey = "stage"
+
+const (
+	accountID     = "123456789012"
+	defaultRegion = "us-east-1"
+)
+
+var (
+	stageVariableRx  = regexp.MustCompile(`\$\{stageVariables\.([^}]+)}`)
+	invocationURIRx  = regexp.MustCompile(`^arn:aws:apigateway:[^:]+:lambda:path/[^/]+/functions/(.+)/(?:response-streaming-)?invocations$`)
+	executeAPIHostRx = regexp.MustCompile(`\.execute-api\.([a-z0-9-]+)\.amazonaws\.com(:\d+)?$`)
+)
+
+// Stage is a deployment of an API, each stage is served on its own base path
+// and carries the stage variables made available to integrations.
+type Stage struct {
+	Name      string
+	APIID     string
+	Variables map[string]string
+	basePath  string
+	router    *mux.Router
+	// throttling is the method throttling of the stage keyed by {resource-path}/{METHOD}.
+	throttling map[string]config.Throttle
+	accessLog  *accessLog
+}
+
+func (s *Stage) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), StageContext, s)))
+	})
+}
+
+// resolve replaces any ${stageVariables.name} references with the stage variable value.
+func (s *Stage) resolve(value string) string {
+	return stageVariableRx.ReplaceAllStringFunc(value, func(match string) string {
+		return s.Variables[stageVariableRx.FindStringSubmatch(match)[1]]
+	})
+}
+
+// requestPath is the path of the request relative to the stage base path.
+func (s *Stage) requestPath(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, s.basePath)
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// lambdaARN resolves stage variables in an integration uri and unwraps the function
+// arn from API Gateway style invocation uris.
+func (s *Stage) lambdaARN(uri string) string {
+	uri = s.resolve(uri)
+	if match := invocationURIRx.FindStringSubmatch(uri); match != nil {
+		return match[1]
+	}
+	return uri
+}
+
+// requestContext is the base request context for proxy events made through the stage.
+func (s *Stage) requestContext(r *http.Request) events.APIGatewayProxyRequestContext {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	requestID, ok := r.Context().Value(requestIDContext).(string)
+	if !ok {
+		requestID = newRequestID()
+	}
+	requestTime, ok := r.Context().Value(requestTimeContext).(time.Time)
+	if !ok {
+		requestTime = time.Now()
+	}
+	return events.APIGatewayProxyRequestContext{
+		AccountID:        accountID,
+		APIID:            s.APIID,
+		Stage:            s.Name,
+		RequestID:        requestID,
+		DomainName:       host,
+		DomainPrefix:     strings.Split(host, ".")[0],
+		HTTPMethod:       r.Method,
+		Path:             r.URL.Path,
+		Protocol:         r.Proto,
+		ResourcePath:     resourcePath(r),
+		RequestTime:      requestTime.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		RequestTimeEpoch: requestTime.UnixMilli(),
+		Identity:         identity(r),
+	}
+}
+
+// identity is the caller identity of the request, with the IAM user of signed requests.
+func identity(r *http.Request) events.APIGatewayRequestIdentity {
+	id, _ := r.Context().Value(IdentityContext).(events.APIGatewayRequestIdentity)
+	id.SourceIP = mw.SourceIP(r)
+	id.UserAgent = r.UserAgent()
+	return id
+}
+
+// requestContextV2 is the request context for HTTP API payload format 2.0 events made through the stage.
+func (s *Stage) requestContextV2(r *http.Request) events.APIGatewayV2HTTPRequestContext {
+	rc := s.requestContext(r)
+	stage := rc.Stage
+	if stage == "" {
+		stage = "$default"
+	}
+	return events.APIGatewayV2HTTPRequestContext{
+		RouteKey:     routeKey(r),
+		AccountID:    rc.AccountID,
+		Stage:        stage,
+		RequestID:    rc.RequestID,
+		APIID:        rc.APIID,
+		DomainName:   rc.DomainName,
+		DomainPrefix: rc.DomainPrefix,
+		Time:         rc.RequestTime,
+		TimeEpoch:    rc.RequestTimeEpoch,
+		HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Protocol:  r.Proto,
+			SourceIP:  rc.Identity.SourceIP,
+			UserAgent: rc.Identity.UserAgent,
+		},
+	}
+}
+
+// methodARN is the execute-api arn of the method invoked by the request, as given to authorizers
+// and evaluated against the policies they return.
+func (s *Stage) methodARN(r *http.Request) string {
+	region := requestRegion(r)
+	stage := s.Name
+	if stage == "" {
+		stage = "$default"
+	}
+	return fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/%s/%s/%s", region, accountID, s.APIID, stage, r.Method, strings.TrimPrefix(s.requestPath(r), "/"))
+}
+
+// requestRegion is the region of the execute-api hostname of the request, requests to other
+// hostnames are in the default region.
+func requestRegion(r *http.Request) string {
+	if match := executeAPIHostRx.FindStringSubmatch(r.Host); match != nil {
+		return match[1]
+	}
+	return defaultRegion
+}
+
+func (api *API) stageFromRequest(r *http.Request) *Stage {
+	if stage, ok := r.Context().Value(StageContext).(*Stage); ok {
+		return stage
+	}
+	return &Stage{APIID: api.ID}
+}
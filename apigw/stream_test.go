@@ -0,0 +1,79 @@
+package apigw
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamingFactory struct {
+	lambstack.LambdaFactory
+	streams map[string]func(payload any, w io.Writer) error
+}
+
+func (f streamingFactory) InvokeWithResponseStream(arn string, payload any, w io.Writer) error {
+	return f.streams[arn](payload, w)
+}
+
+func Test_ImportLambdaProxyStream(t *testing.T) {
+	release := make(chan struct{})
+	fail := false
+	f := &streamingFactory{streams: map[string]func(payload any, w io.Writer) error{
+		"arn:aws:lambda:us-east-1:123456789012:function:report": func(payload any, w io.Writer) error {
+			if fail {
+				return &lambstack.FunctionError{Message: "failed"}
+			}
+			event := payload.(events.APIGatewayProxyRequest)
+			lambstack.ReadPrelude(w)
+			prelude := `{"statusCode":201,"headers":{"Content-Type":"text/csv","X-Resource":"` + event.Resource + `"},"cookies":["a=b"]}`
+			_, _ = io.WriteString(w, prelude+"\x00\x00\x00\x00\x00\x00\x00\x00id,name\n")
+			// the rest of the report is only written once the client has received the first rows
+			<-release
+			_, _ = io.WriteString(w, "1,rex\n")
+			return nil
+		},
+	}}
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/lambda-streaming.yml")
+	require.NoError(t, err)
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "streaming"})
+	require.NoError(t, api.Import(doc))
+	assert.Empty(t, api.Diagnostics())
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/streaming/report", nil)
+	require.NoError(t, err)
+	req.Host = apiHostName
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "/report", resp.Header.Get("X-Resource"))
+	assert.Equal(t, "a=b", resp.Header.Get("Set-Cookie"))
+	body := bufio.NewReader(resp.Body)
+	line, err := body.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "id,name\n", line)
+	close(release)
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "1,rex\n", string(rest))
+
+	fail = true
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
@@ -0,0 +1,276 @@
+package apigw
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/iwarapter/gostack/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	apiKeyHeader           = "x-api-key"
+	apiKeySourceExtension  = "x-amazon-apigateway-api-key-source"
+	apiKeySourceAuthorizer = "AUTHORIZER"
+)
+
+// usageResult is the outcome of checking a request against the usage plans.
+type usageResult int
+
+const (
+	usageAllowed usageResult = iota
+	usageInvalidKey
+	usageThrottled
+	usageQuotaExceeded
+)
+
+// UsagePlans holds the api keys and the usage plans they belong to, the rate limits and quotas of
+// the plans are shared by every api using them.
+type UsagePlans struct {
+	keys    map[string]config.APIKey
+	plans   []config.UsagePlan
+	limiter *limiter
+	mu      sync.Mutex
+	quotas  map[string]*quotaCounter
+	now     func() time.Time
+}
+
+func NewUsagePlans(keys []config.APIKey, plans []config.UsagePlan) *UsagePlans {
+	u := &UsagePlans{
+		keys:    map[string]config.APIKey{},
+		plans:   plans,
+		limiter: newLimiter(),
+		quotas:  map[string]*quotaCounter{},
+		now:     time.Now,
+	}
+	for _, k := range keys {
+		u.keys[k.Value] = k
+	}
+	return u
+}
+
+// check finds the usage plan of the key for the api stage and applies its throttle and quota,
+// methods are keyed as {resource-path}/{METHOD}.
+func (u *UsagePlans) check(value, apiID, stage, method string) usageResult {
+	key, plan, ps, ok := u.find(value, apiID, stage)
+	if !ok {
+		return usageInvalidKey
+	}
+	now := u.now()
+	bucket := plan.Name + "\x00" + key.Name
+	throttle := plan.Throttle
+	if t, ok := ps.Throttle[method]; ok {
+		bucket, throttle = bucket+"\x00"+apiID+"\x00"+stage+"\x00"+method, &t
+	}
+	if throttle != nil && !u.limiter.allow(bucket, *throttle, now) {
+		return usageThrottled
+	}
+	if plan.Quota != nil && !u.consume(plan.Name+"\x00"+key.Name, *plan.Quota, now) {
+		return usageQuotaExceeded
+	}
+	return usageAllowed
+}
+
+// find is the enabled key of the value and the first of its usage plans for the api stage.
+func (u *UsagePlans) find(value, apiID, stage string) (config.APIKey, config.UsagePlan, config.UsagePlanStage, bool) {
+	key, ok := u.keys[value]
+	if value == "" || !ok || key.Disabled {
+		return config.APIKey{}, config.UsagePlan{}, config.UsagePlanStage{}, false
+	}
+	for _, plan := range u.plans {
+		if !contains(plan.APIKeys, key.Name) {
+			continue
+		}
+		for _, ps := range plan.APIStages {
+			if ps.API == apiID && (ps.Stage == "" || ps.Stage == stage) {
+				return key, plan, ps, true
+			}
+		}
+	}
+	return config.APIKey{}, config.UsagePlan{}, config.UsagePlanStage{}, false
+}
+
+type quotaCounter struct {
+	start time.Time
+	count int
+}
+
+// consume counts the request against the quota, the count is reset at the start of each period.
+func (u *UsagePlans) consume(key string, quota config.Quota, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	start := periodStart(quota.Period, now)
+	c, ok := u.quotas[key]
+	if !ok || !c.start.Equal(start) {
+		c = &quotaCounter{start: start}
+		u.quotas[key] = c
+	}
+	if c.count >= quota.Limit {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// periodStart is the start of the quota period containing now in UTC, weeks start on a Monday.
+func periodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch strings.ToUpper(period) {
+	case "WEEK":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "MONTH":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// tokenBucket allows bursts of up to burst requests, refilling at rate tokens per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter holds the token buckets of each throttled key.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from the bucket of the key. A burst limit of 0 defaults to the rate, so
+// only a throttle with neither a rate nor a burst limit rejects every request.
+func (l *limiter) allow(key string, t config.Throttle, now time.Time) bool {
+	burst := float64(t.BurstLimit)
+	if burst == 0 && t.RateLimit > 0 {
+		burst = math.Max(1, math.Ceil(t.RateLimit))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*t.RateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// methodKey is the {resource-path}/{METHOD} key of the route matched for the request used by method settings.
+func methodKey(r *http.Request) string {
+	return resourcePath(r) + "/" + r.Method
+}
+
+// APIKey requires an api key of a usage plan for the api stage, taken from the x-api-key header or from
+// the usageIdentifierKey of the authorizer when the api key source is AUTHORIZER. The usage plan throttle
+// and quota are applied to the requests of each key.
+func (api *API) APIKey(source string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-api-key").Logger()
+		switch api.usagePlans().check(apiKeyValue(source, r), api.ID, api.stageFromRequest(r).Name, methodKey(r)) {
+		case usageInvalidKey:
+			subl.Info().Msg("missing or invalid api key")
+			api.gatewayError(w, r, invalidAPIKey, "Forbidden")
+			return
+		case usageThrottled:
+			subl.Info().Msg("usage plan throttled the request")
+			api.gatewayError(w, r, throttled, "Too Many Requests")
+			return
+		case usageQuotaExceeded:
+			subl.Info().Msg("usage plan quota exceeded")
+			api.gatewayError(w, r, quotaExceeded, "Limit Exceeded")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// validAPIKey requires an api key of a usage plan for the api stage like APIKey, without applying the usage plan
+// throttle and quota.
+func (api *API) validAPIKey(source string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, _, ok := api.usagePlans().find(apiKeyValue(source, r), api.ID, api.stageFromRequest(r).Name); !ok {
+			log.Info().Str("handler", "apigateway-api-key").Msg("missing or invalid api key")
+			api.gatewayError(w, r, invalidAPIKey, "Forbidden")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+func (api *API) usagePlans() *UsagePlans {
+	if api.UsagePlans == nil {
+		return NewUsagePlans(nil, nil)
+	}
+	return api.UsagePlans
+}
+
+// apiKeyValue is the api key of the request, taken from the authorizer when the api key source is AUTHORIZER.
+func apiKeyValue(source string, r *http.Request) string {
+	if strings.EqualFold(source, apiKeySourceAuthorizer) {
+		auth, _ := r.Context().Value(AuthorizerContext).(events.APIGatewayCustomAuthorizerResponse)
+		return auth.UsageIdentifierKey
+	}
+	return r.Header.Get(apiKeyHeader)
+}
+
+// throttle applies the method throttling of the stage, methods without their own settings use the */* settings.
+// Each method of the stage has its own token bucket.
+func (api *API) throttle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stage := api.stageFromRequest(r)
+		key := methodKey(r)
+		t, ok := stage.throttling[key]
+		if !ok {
+			t, ok = stage.throttling["*/*"]
+		}
+		if ok && !api.limiter.allow(stage.Name+"\x00"+key, t, time.Now()) {
+			log.Info().Str("handler", "apigateway-throttle").Str("method", key).Msg("stage throttled the request")
+			api.gatewayError(w, r, throttled, "Too Many Requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeySource is the api key source of the operation and whether the operation requires an api key through
+// its own api key source extension, operations with an x-api-key header security scheme require a key too.
+func apiKeySource(spec *openapi3.T, op *openapi3.Operation) (string, bool) {
+	source, _ := spec.Extensions[apiKeySourceExtension].(string)
+	opSource, required := op.Extensions[apiKeySourceExtension].(string)
+	if required {
+		source = opSource
+	}
+	return source, required
+}
+
+// isAPIKeyScheme is true for x-api-key header schemes that are not authorizers.
+func isAPIKeyScheme(sec *openapi3.SecuritySchemeRef) bool {
+	if sec.Value == nil || sec.Value.Type != "apiKey" || sec.Value.In != "header" || !strings.EqualFold(sec.Value.Name, apiKeyHeader) {
+		return false
+	}
+	_, ok := sec.Value.Extensions["x-amazon-apigateway-authorizer"]
+	return !ok
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
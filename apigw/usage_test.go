@@ -0,0 +1,128 @@
+package apigw
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := newLimiter()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	throttle := config.Throttle{RateLimit: 1, BurstLimit: 2}
+
+	assert.True(t, l.allow("a", throttle, now))
+	assert.True(t, l.allow("a", throttle, now))
+	assert.False(t, l.allow("a", throttle, now), "the burst is exhausted")
+	assert.True(t, l.allow("b", throttle, now), "keys have their own buckets")
+	assert.False(t, l.allow("a", throttle, now.Add(500*time.Millisecond)))
+	assert.True(t, l.allow("a", throttle, now.Add(time.Second)), "a token is refilled each second")
+	assert.False(t, l.allow("a", throttle, now.Add(time.Second)))
+
+	assert.False(t, l.allow("c", config.Throttle{}, now), "an empty throttle rejects every request")
+	assert.True(t, l.allow("d", config.Throttle{RateLimit: 1}, now), "the burst defaults to the rate")
+}
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2023, 3, 16, 13, 14, 15, 0, time.UTC) // a Thursday
+	assert.Equal(t, time.Date(2023, 3, 16, 0, 0, 0, 0, time.UTC), periodStart("DAY", now))
+	assert.Equal(t, time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC), periodStart("WEEK", now))
+	assert.Equal(t, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), periodStart("MONTH", now))
+}
+
+func TestUsagePlans_Check(t *testing.T) {
+	now := time.Date(2023, 3, 16, 23, 59, 59, 0, time.UTC)
+	plans := NewUsagePlans([]config.APIKey{
+		{Name: "gold", Value: "gold-key"},
+		{Name: "bronze", Value: "bronze-key"},
+		{Name: "disabled", Value: "disabled-key", Disabled: true},
+		{Name: "unused", Value: "unused-key"},
+	}, []config.UsagePlan{
+		{
+			Name:      "gold",
+			APIKeys:   []string{"gold", "disabled"},
+			APIStages: []config.UsagePlanStage{{API: "pets"}},
+		},
+		{
+			Name:      "bronze",
+			APIKeys:   []string{"bronze"},
+			APIStages: []config.UsagePlanStage{{API: "pets", Stage: "prod", Throttle: map[string]config.Throttle{"/pets/POST": {}}}},
+			Throttle:  &config.Throttle{RateLimit: 100, BurstLimit: 100},
+			Quota:     &config.Quota{Limit: 2, Period: "DAY"},
+		},
+	})
+	plans.now = func() time.Time { return now }
+
+	assert.Equal(t, usageAllowed, plans.check("gold-key", "pets", "dev", "/pets/GET"))
+	assert.Equal(t, usageInvalidKey, plans.check("", "pets", "dev", "/pets/GET"))
+	assert.Equal(t, usageInvalidKey, plans.check("unknown", "pets", "dev", "/pets/GET"))
+	assert.Equal(t, usageInvalidKey, plans.check("disabled-key", "pets", "dev", "/pets/GET"))
+	assert.Equal(t, usageInvalidKey, plans.check("unused-key", "pets", "dev", "/pets/GET"), "keys must be in a usage plan")
+	assert.Equal(t, usageInvalidKey, plans.check("gold-key", "other", "dev", "/pets/GET"), "usage plans are limited to their apis")
+	assert.Equal(t, usageInvalidKey, plans.check("bronze-key", "pets", "dev", "/pets/GET"), "usage plans are limited to their stages")
+
+	assert.Equal(t, usageThrottled, plans.check("bronze-key", "pets", "prod", "/pets/POST"), "method throttles override the plan")
+	assert.Equal(t, usageAllowed, plans.check("bronze-key", "pets", "prod", "/pets/GET"))
+	assert.Equal(t, usageAllowed, plans.check("bronze-key", "pets", "prod", "/pets/GET"))
+	assert.Equal(t, usageQuotaExceeded, plans.check("bronze-key", "pets", "prod", "/pets/GET"))
+	now = now.Add(time.Second)
+	assert.Equal(t, usageAllowed, plans.check("bronze-key", "pets", "prod", "/pets/GET"), "quotas are reset each period")
+}
+
+func Test_ImportAPIKeys(t *testing.T) {
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/api-keys.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	api := New(r, &mockFactory{}, config.APIGW{ID: "keys", Stages: []config.APIStage{{
+		Name:       "prod",
+		Throttling: map[string]config.Throttle{"/health/GET": {RateLimit: 0.001, BurstLimit: 1}},
+	}}})
+	api.UsagePlans = NewUsagePlans([]config.APIKey{{Name: "one", Value: "key-one"}}, []config.UsagePlan{{
+		Name:      "basic",
+		APIKeys:   []string{"one"},
+		APIStages: []config.UsagePlanStage{{API: "keys", Stage: "prod"}},
+		Quota:     &config.Quota{Limit: 1, Period: "MONTH"},
+	}})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, path, key string
+		status          int
+		expected        string
+	}{
+		{name: "missing api key", path: "/keys/prod/_user_request_/pets", status: http.StatusForbidden, expected: `{"message":"Forbidden"}`},
+		{name: "invalid api key", path: "/keys/prod/_user_request_/pets", key: "key-two", status: http.StatusForbidden, expected: `{"message":"Forbidden"}`},
+		{name: "valid api key", path: "/keys/prod/_user_request_/pets", key: "key-one", status: http.StatusOK, expected: `[]`},
+		{name: "quota exceeded", path: "/keys/prod/_user_request_/pets", key: "key-one", status: http.StatusTooManyRequests, expected: `{"message":"Limit Exceeded"}`},
+		{name: "methods without api keys", path: "/keys/prod/_user_request_/health", status: http.StatusOK, expected: `{"status": "ok"}`},
+		{name: "stage method throttling", path: "/keys/prod/_user_request_/health", status: http.StatusTooManyRequests, expected: `{"message":"Too Many Requests"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Host = apiHostName
+			if tt.key != "" {
+				req.Header.Set("x-api-key", tt.key)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(body))
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package apigw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	requestValidatorsExtension = "x-amazon-apigateway-request-validators"
+	requestValidatorExtension  = "x-amazon-apigateway-request-validator"
+)
+
+type XAmazonAPIGatewayRequestValidator struct {
+	ValidateRequestBody       bool `json:"validateRequestBody,omitempty" yaml:"validateRequestBody,omitempty"`
+	ValidateRequestParameters bool `json:"validateRequestParameters,omitempty" yaml:"validateRequestParameters,omitempty"`
+}
+
+// requestValidator finds the validator configured for the operation, falling back to the
+// validator set at the root of the spec.
+func requestValidator(spec *openapi3.T, op *openapi3.Operation) (*XAmazonAPIGatewayRequestValidator, error) {
+	name := requestValidatorName(spec, op)
+	if name == "" {
+		return nil, nil
+	}
+	validators := map[string]XAmazonAPIGatewayRequestValidator{}
+	if ext, ok := spec.Extensions[requestValidatorsExtension]; ok {
+		if err := decodeExtension(ext, &validators); err != nil {
+			return nil, fmt.Errorf("unable to parse %s extension error: %w", requestValidatorsExtension, err)
+		}
+	}
+	validator, ok := validators[name]
+	if !ok {
+		return nil, fmt.Errorf("request validator %s is not defined in %s", name, requestValidatorsExtension)
+	}
+	return &validator, nil
+}
+
+// requestValidatorName is the validator of the operation, operations without their own validator use the default
+// validator of the spec.
+func requestValidatorName(spec *openapi3.T, op *openapi3.Operation) string {
+	if name, ok := op.Extensions[requestValidatorExtension].(string); ok {
+		return name
+	}
+	name, _ := spec.Extensions[requestValidatorExtension].(string)
+	return name
+}
+
+// RequestValidator rejects requests missing required parameters or with a body that does not match
+// the operation schema before they reach the integration.
+func (api *API) RequestValidator(validator XAmazonAPIGatewayRequestValidator, params openapi3.Parameters, body *openapi3.RequestBodyRef, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "apigateway-request-validator").Logger()
+		if validator.ValidateRequestParameters {
+			if missing := missingParameters(r, params); len(missing) > 0 {
+				subl.Info().Strs("missing", missing).Msg("request parameter validation failed")
+				api.gatewayError(w, r, badRequestParameters, fmt.Sprintf("Missing required request parameters: [%s]", strings.Join(missing, ", ")))
+				return
+			}
+		}
+		if validator.ValidateRequestBody && body != nil && body.Value != nil {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				subl.Error().Err(err).Msg("unable to read body")
+				api.gatewayError(w, r, default5XX, "Internal server error")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(b))
+			if err = validateBody(r.Header.Get("Content-Type"), b, body.Value); err != nil {
+				subl.Info().Err(err).Msg("request body validation failed")
+				api.gatewayError(w, r, badRequestBody, "Invalid request body")
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+func missingParameters(r *http.Request, params openapi3.Parameters) []string {
+	missing := make([]string, 0)
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, p := range params {
+		if p.Value == nil || !p.Value.Required {
+			continue
+		}
+		var found bool
+		switch p.Value.In {
+		case openapi3.ParameterInHeader:
+			found = r.Header.Get(p.Value.Name) != ""
+		case openapi3.ParameterInQuery:
+			found = query.Has(p.Value.Name)
+		case openapi3.ParameterInPath:
+			found = vars[p.Value.Name] != ""
+		default:
+			found = true
+		}
+		if !found {
+			missing = append(missing, p.Value.Name)
+		}
+	}
+	return missing
+}
+
+func validateBody(contentType string, body []byte, requestBody *openapi3.RequestBody) error {
+	if len(body) == 0 {
+		if requestBody.Required {
+			return fmt.Errorf("request body is required")
+		}
+		return nil
+	}
+	mediaType := "application/json"
+	if contentType != "" {
+		if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = mt
+		}
+	}
+	content := requestBody.Content.Get(mediaType)
+	// only json bodies are validated against the schema of the model
+	if !isJSONMediaType(mediaType) || content == nil || content.Schema == nil || content.Schema.Value == nil {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return err
+	}
+	return content.Schema.Value.VisitJSON(value)
+}
+
+// isJSONMediaType is true for application/json and the +json structured syntax suffix.
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// operationParameters merges the path level parameters with the operation parameters, with the
+// operation taking precedence.
+func operationParameters(item *openapi3.PathItem, op *openapi3.Operation) openapi3.Parameters {
+	params := make(openapi3.Parameters, 0, len(op.Parameters))
+	params = append(params, op.Parameters...)
+	if item == nil {
+		return params
+	}
+	for _, p := range item.Parameters {
+		if p.Value != nil && op.Parameters.GetByInAndName(p.Value.In, p.Value.Name) == nil {
+			params = append(params, p)
+		}
+	}
+	return params
+}
@@ -0,0 +1,154 @@
+package apigw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ImportRequestValidators(t *testing.T) {
+	calls := 0
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			"arn:aws:lambda:us-east-1:123456789012:function:simple": func(_ any) ([]byte, error) {
+				calls++
+				return json.Marshal(&events.APIGatewayProxyResponse{Body: "unit-test", StatusCode: http.StatusOK})
+			},
+		},
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromFile("examples/request-validation.yml")
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	api := New(r, f, config.APIGW{ID: "unit-test"})
+	require.NoError(t, api.Import(doc))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	tests := []struct {
+		name, method, path, body string
+		headers                  map[string]string
+		status, calls            int
+		message                  string
+	}{
+		{
+			name:    "missing query and header parameters are rejected",
+			method:  http.MethodGet,
+			path:    "/pets",
+			status:  http.StatusBadRequest,
+			message: `{"message":"Missing required request parameters: [limit, X-Tenant]"}`,
+		},
+		{
+			name:    "missing header parameter is rejected",
+			method:  http.MethodGet,
+			path:    "/pets?limit=10",
+			status:  http.StatusBadRequest,
+			message: `{"message":"Missing required request parameters: [X-Tenant]"}`,
+		},
+		{
+			name:    "valid parameters are allowed",
+			method:  http.MethodGet,
+			path:    "/pets?limit=10",
+			headers: map[string]string{"X-Tenant": "unit-test"},
+			status:  http.StatusOK,
+			calls:   1,
+			message: "unit-test",
+		},
+		{
+			name:    "missing body is rejected",
+			method:  http.MethodPost,
+			path:    "/pets",
+			status:  http.StatusBadRequest,
+			message: `{"message":"Invalid request body"}`,
+		},
+		{
+			name:    "body not matching the schema is rejected",
+			method:  http.MethodPost,
+			path:    "/pets",
+			body:    `{"age": "ten"}`,
+			headers: map[string]string{"Content-Type": "application/json"},
+			status:  http.StatusBadRequest,
+			message: `{"message":"Invalid request body"}`,
+		},
+		{
+			name:    "malformed json body is rejected",
+			method:  http.MethodPost,
+			path:    "/pets",
+			body:    `{"name": `,
+			status:  http.StatusBadRequest,
+			message: `{"message":"Invalid request body"}`,
+		},
+		{
+			name:    "valid body is allowed",
+			method:  http.MethodPost,
+			path:    "/pets",
+			body:    `{"name": "rex", "age": 10}`,
+			headers: map[string]string{"Content-Type": "application/json"},
+			status:  http.StatusOK,
+			calls:   1,
+			message: "unit-test",
+		},
+		{
+			name:    "validators with string flags validate the body",
+			method:  http.MethodPost,
+			path:    "/notes",
+			body:    `{"age": "ten"}`,
+			headers: map[string]string{"Content-Type": "application/json"},
+			status:  http.StatusBadRequest,
+			message: `{"message":"Invalid request body"}`,
+		},
+		{
+			name:    "text bodies are not validated as json",
+			method:  http.MethodPost,
+			path:    "/notes",
+			body:    "remember the milk",
+			headers: map[string]string{"Content-Type": "text/plain"},
+			status:  http.StatusOK,
+			calls:   1,
+			message: "unit-test",
+		},
+		{
+			name:    "xml bodies are not validated as json",
+			method:  http.MethodPost,
+			path:    "/notes",
+			body:    "<pet><name>rex</name></pet>",
+			headers: map[string]string{"Content-Type": "application/xml"},
+			status:  http.StatusOK,
+			calls:   1,
+			message: "unit-test",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, fmt.Sprintf("%s/unit-test%s", srv.URL, tt.path), strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Host = apiHostName
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.message, string(b))
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
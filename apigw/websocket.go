@@ -0,0 +1,407 @@
+package apigw
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/mw"
+	"github.com/iwarapter/gostack/lambstack"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	connectRoute    = "$connect"
+	disconnectRoute = "$disconnect"
+	defaultRoute    = "$default"
+
+	// defaultRouteSelectionExpression is the route selection expression of the AWS console.
+	defaultRouteSelectionExpression = "$request.body.action"
+)
+
+// WebSocketAPI is a websocket api, messages from connected clients are sent to the lambda of the route
+// selected by the route selection expression. Connections are tracked in memory and can be messaged with
+// the @connections management api of each stage.
+type WebSocketAPI struct {
+	ID             string
+	routeSelection string
+	routes         map[string]string
+	lambs          lambstack.LambdaFactory
+	stages         []*Stage
+	upgrader       websocket.Upgrader
+
+	mu          sync.RWMutex
+	connections map[string]*connection
+}
+
+// connection is a connected websocket client.
+type connection struct {
+	id          string
+	stage       *Stage
+	conn        *websocket.Conn
+	domainName  string
+	connectedAt time.Time
+	identity    events.APIGatewayRequestIdentity
+
+	// mu serialises writes to the connection and guards lastActiveAt
+	mu           sync.Mutex
+	lastActiveAt time.Time
+}
+
+func NewWebSocket(subrouter *mux.Router, lambs lambstack.LambdaFactory, conf config.APIGW) *WebSocketAPI {
+	log.Info().Str("apid_id", conf.ID).Msg("creating websocket api gateway")
+	expression := conf.RouteSelectionExpression
+	if expression == "" {
+		expression = defaultRouteSelectionExpression
+	}
+	ws := &WebSocketAPI{
+		ID:             conf.ID,
+		routeSelection: expression,
+		routes:         conf.Routes,
+		lambs:          lambs,
+		// browsers connect from any origin, as API Gateway does not check it
+		upgrader:    websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		connections: map[string]*connection{},
+	}
+	prefix := subrouter.PathPrefix(fmt.Sprintf("/%s", conf.ID))
+	if len(conf.Stages) == 0 {
+		ws.stages = append(ws.stages, &Stage{APIID: conf.ID})
+		ws.mount(prefix, ws.stages[0])
+		return ws
+	}
+	router := prefix.Subrouter()
+	for _, s := range conf.Stages {
+		log.Info().Str("apid_id", conf.ID).Str("stage", s.Name).Msg("adding websocket api gateway stage")
+		stage := &Stage{Name: s.Name, APIID: conf.ID, Variables: s.Variables}
+		ws.stages = append(ws.stages, stage)
+		ws.mount(router.PathPrefix(fmt.Sprintf("/%s/_user_request_", s.Name)), stage)
+	}
+	return ws
+}
+
+// MountExecuteAPI serves every stage of the api from the execute-api style hostnames
+// {id}.execute-api.127.0.0.1.nip.io/{stage} and {id}.execute-api.{region}.amazonaws.com/{stage}.
+func (ws *WebSocketAPI) MountExecuteAPI(router *mux.Router) {
+	hosts := []string{
+		fmt.Sprintf("%s.execute-api.127.0.0.1.nip.io", ws.ID),
+		fmt.Sprintf("%s.execute-api.{region}.amazonaws.com", ws.ID),
+	}
+	for _, host := range hosts {
+		for _, s := range ws.stages {
+			rt := router.Host(host)
+			if s.Name != "" {
+				rt = rt.PathPrefix(fmt.Sprintf("/%s", s.Name))
+			}
+			ws.mount(rt, s)
+		}
+	}
+}
+
+// mount serves the websocket endpoint of the stage and its @connections management api from the route.
+func (ws *WebSocketAPI) mount(rt *mux.Route, stage *Stage) {
+	router := rt.Subrouter()
+	router.Use(stage.middleware)
+	connections := router.PathPrefix("/@connections/{connectionId}").Subrouter()
+	connections.Methods(http.MethodPost).HandlerFunc(ws.postToConnection)
+	connections.Methods(http.MethodGet).HandlerFunc(ws.getConnection)
+	connections.Methods(http.MethodDelete).HandlerFunc(ws.deleteConnection)
+	router.Path("/").HandlerFunc(ws.connect)
+	if tpl, err := rt.GetPathTemplate(); err == nil && tpl != "" {
+		router.Path("").HandlerFunc(ws.connect)
+	}
+}
+
+// connect invokes the $connect route, when it succeeds the connection is upgraded and its messages
+// are routed until the client or the management api closes it.
+func (ws *WebSocketAPI) connect(w http.ResponseWriter, r *http.Request) {
+	subl := log.With().Str("handler", "apigateway-websocket").Str("api_id", ws.ID).Logger()
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeManagementError(w, http.StatusUpgradeRequired, "", "Upgrade Required")
+		return
+	}
+	stage, _ := r.Context().Value(StageContext).(*Stage)
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	now := time.Now()
+	c := &connection{
+		id:           newConnectionID(),
+		stage:        stage,
+		domainName:   host,
+		connectedAt:  now,
+		lastActiveAt: now,
+		identity:     events.APIGatewayRequestIdentity{SourceIP: mw.SourceIP(r), UserAgent: r.UserAgent()},
+	}
+	subl = subl.With().Str("connection_id", c.id).Logger()
+
+	if _, ok := ws.routes[connectRoute]; ok {
+		event := ws.event(c, "CONNECT", connectRoute, "")
+		event.Headers = map[string]string{}
+		event.MultiValueHeaders = map[string][]string{}
+		for k, v := range r.Header {
+			event.Headers[k] = strings.Join(v, ",")
+			event.MultiValueHeaders[k] = v
+		}
+		event.QueryStringParameters = map[string]string{}
+		event.MultiValueQueryStringParameters = map[string][]string{}
+		for k, v := range r.URL.Query() {
+			event.QueryStringParameters[k] = v[len(v)-1]
+			event.MultiValueQueryStringParameters[k] = v
+		}
+		resp, err := ws.invoke(c, connectRoute, event)
+		switch {
+		case err != nil:
+			subl.Error().Err(err).Msg("unable to invoke the $connect route")
+			writeManagementError(w, http.StatusBadGateway, "", "Internal server error")
+			return
+		case resp.StatusCode >= 300:
+			subl.Info().Int("status_code", resp.StatusCode).Msg("the $connect route rejected the connection")
+			w.WriteHeader(resp.StatusCode)
+			_, _ = w.Write([]byte(resp.Body))
+			return
+		}
+	}
+
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to upgrade the connection")
+		return
+	}
+	c.conn = conn
+	ws.mu.Lock()
+	ws.connections[c.id] = c
+	ws.mu.Unlock()
+	subl.Info().Msg("client connected")
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		c.lastActiveAt = time.Now()
+		c.mu.Unlock()
+		ws.message(c, message, messageType == websocket.BinaryMessage)
+	}
+
+	ws.mu.Lock()
+	delete(ws.connections, c.id)
+	ws.mu.Unlock()
+	_ = conn.Close()
+	subl.Info().Msg("client disconnected")
+	if _, ok := ws.routes[disconnectRoute]; ok {
+		if _, err := ws.invoke(c, disconnectRoute, ws.event(c, "DISCONNECT", disconnectRoute, "")); err != nil {
+			subl.Error().Err(err).Msg("unable to invoke the $disconnect route")
+		}
+	}
+}
+
+// message sends a message to the lambda of its route, clients are sent an error when no route
+// matches or the lambda fails.
+func (ws *WebSocketAPI) message(c *connection, message []byte, binary bool) {
+	subl := log.With().Str("handler", "apigateway-websocket").Str("api_id", ws.ID).Str("connection_id", c.id).Logger()
+	routeKey := ws.selectRoute(message)
+	event := ws.event(c, "MESSAGE", routeKey, string(message))
+	if binary {
+		event.Body = base64.StdEncoding.EncodeToString(message)
+		event.IsBase64Encoded = true
+	}
+	if _, ok := ws.routes[routeKey]; !ok {
+		subl.Info().Str("route_key", routeKey).Msg("no route for the message")
+		ws.sendError(c, "Forbidden", event.RequestContext.RequestID)
+		return
+	}
+	if _, err := ws.invoke(c, routeKey, event); err != nil {
+		subl.Error().Err(err).Str("route_key", routeKey).Msg("unable to invoke the route")
+		ws.sendError(c, "Internal server error", event.RequestContext.RequestID)
+	}
+}
+
+// selectRoute evaluates the route selection expression against the message, such as $request.body.action
+// for the action property of json messages. Messages without a matching route use $default.
+func (ws *WebSocketAPI) selectRoute(message []byte) string {
+	expression := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(ws.routeSelection, "$"), "{"), "}")
+	path, ok := strings.CutPrefix(expression, "request.body.")
+	if !ok {
+		return defaultRoute
+	}
+	var value any
+	if err := json.Unmarshal(message, &value); err != nil {
+		return defaultRoute
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return defaultRoute
+		}
+		value = obj[key]
+	}
+	if key, ok := value.(string); ok {
+		if _, routed := ws.routes[key]; routed {
+			return key
+		}
+	}
+	return defaultRoute
+}
+
+// event is the proxy event of the connection for the route.
+func (ws *WebSocketAPI) event(c *connection, eventType, routeKey, body string) events.APIGatewayWebsocketProxyRequest {
+	now := time.Now()
+	requestID := newRequestID()
+	rc := events.APIGatewayWebsocketProxyRequestContext{
+		AccountID:         accountID,
+		Stage:             c.stage.Name,
+		RequestID:         requestID,
+		ExtendedRequestID: requestID,
+		Identity:          c.identity,
+		APIID:             ws.ID,
+		ConnectedAt:       c.connectedAt.UnixMilli(),
+		ConnectionID:      c.id,
+		DomainName:        c.domainName,
+		EventType:         eventType,
+		MessageDirection:  "IN",
+		RequestTime:       now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		RequestTimeEpoch:  now.UnixMilli(),
+		RouteKey:          routeKey,
+	}
+	if eventType == "MESSAGE" {
+		rc.MessageID = newConnectionID()
+	}
+	return events.APIGatewayWebsocketProxyRequest{
+		StageVariables:  c.stage.Variables,
+		RequestContext:  rc,
+		Body:            body,
+		IsBase64Encoded: false,
+	}
+}
+
+// invoke sends the event to the lambda of the route, function errors are returned as errors.
+func (ws *WebSocketAPI) invoke(c *connection, routeKey string, event events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var resp events.APIGatewayProxyResponse
+	b, err := ws.lambs.Invoke(c.stage.lambdaARN(ws.routes[routeKey]), event)
+	if err != nil {
+		return resp, err
+	}
+	// lambdas may return nothing, which is a success
+	if len(b) > 0 && string(b) != "null" {
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return resp, fmt.Errorf("unable to unmarshal the route response: %w", err)
+		}
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	return resp, nil
+}
+
+// sendError sends an API Gateway error message to the client.
+func (ws *WebSocketAPI) sendError(c *connection, message, requestID string) {
+	b, _ := json.Marshal(map[string]string{"message": message, "connectionId": c.id, "requestId": requestID})
+	_ = c.write(websocket.TextMessage, b)
+}
+
+func (c *connection) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (ws *WebSocketAPI) connection(r *http.Request) (*connection, bool) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	c, ok := ws.connections[mux.Vars(r)["connectionId"]]
+	return c, ok
+}
+
+// postToConnection sends the request body to the connection, as a text message when it is valid utf-8.
+func (ws *WebSocketAPI) postToConnection(w http.ResponseWriter, r *http.Request) {
+	c, ok := ws.connection(r)
+	if !ok {
+		writeManagementError(w, http.StatusGone, "GoneException", "")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeManagementError(w, http.StatusBadRequest, "BadRequestException", "Unable to read the request body")
+		return
+	}
+	messageType := websocket.TextMessage
+	if !utf8.Valid(body) {
+		messageType = websocket.BinaryMessage
+	}
+	if err := c.write(messageType, body); err != nil {
+		log.Info().Err(err).Str("connection_id", c.id).Msg("unable to post to the connection")
+		writeManagementError(w, http.StatusGone, "GoneException", "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// getConnection describes the connection.
+func (ws *WebSocketAPI) getConnection(w http.ResponseWriter, r *http.Request) {
+	c, ok := ws.connection(r)
+	if !ok {
+		writeManagementError(w, http.StatusGone, "GoneException", "")
+		return
+	}
+	c.mu.Lock()
+	lastActiveAt := c.lastActiveAt
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", defaultContentType)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"connectedAt":  c.connectedAt.UTC().Format(time.RFC3339),
+		"lastActiveAt": lastActiveAt.UTC().Format(time.RFC3339),
+		"identity":     map[string]string{"sourceIp": c.identity.SourceIP, "userAgent": c.identity.UserAgent},
+	})
+}
+
+// deleteConnection closes the connection, which invokes the $disconnect route.
+func (ws *WebSocketAPI) deleteConnection(w http.ResponseWriter, r *http.Request) {
+	c, ok := ws.connection(r)
+	if !ok {
+		writeManagementError(w, http.StatusGone, "GoneException", "")
+		return
+	}
+	c.mu.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.mu.Unlock()
+	_ = c.conn.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeManagementError writes an error of the management api, the error type lets the AWS SDKs
+// return the matching exception such as GoneException.
+func writeManagementError(w http.ResponseWriter, status int, errorType, message string) {
+	if errorType != "" {
+		w.Header().Set("X-Amzn-ErrorType", errorType)
+	}
+	w.Header().Set("Content-Type", defaultContentType)
+	w.WriteHeader(status)
+	if message == "" {
+		_, _ = w.Write([]byte(`{"message":null}`))
+		return
+	}
+	b, _ := json.Marshal(map[string]string{"message": message})
+	_, _ = w.Write(b)
+}
+
+// newConnectionID is a random id in the format of API Gateway connection ids, such as L0SM9cOFvHcCIhw=,
+// using the url safe alphabet so it can be used in @connections paths.
+func newConnectionID() string {
+	b := make([]byte, 11)
+	_, _ = rand.Read(b)
+	return base64.URLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,199 @@
+package apigw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WebSocket(t *testing.T) {
+	const (
+		connectARN    = "arn:aws:lambda:us-east-1:123456789012:function:connect"
+		disconnectARN = "arn:aws:lambda:us-east-1:123456789012:function:disconnect"
+		sendARN       = "arn:aws:lambda:us-east-1:123456789012:function:send"
+		defaultARN    = "arn:aws:lambda:us-east-1:123456789012:function:default"
+	)
+	received := make(chan events.APIGatewayWebsocketProxyRequest, 10)
+	record := func(resp string) func(payload any) ([]byte, error) {
+		return func(payload any) ([]byte, error) {
+			received <- payload.(events.APIGatewayWebsocketProxyRequest)
+			return []byte(resp), nil
+		}
+	}
+	f := &mockFactory{
+		responses: map[string]func(payload any) ([]byte, error){
+			connectARN: func(payload any) ([]byte, error) {
+				event := payload.(events.APIGatewayWebsocketProxyRequest)
+				received <- event
+				if event.QueryStringParameters["token"] != "allow" {
+					return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized, Body: "denied"})
+				}
+				return json.Marshal(events.APIGatewayProxyResponse{StatusCode: http.StatusOK})
+			},
+			disconnectARN: record("null"),
+			sendARN:       record(`{"statusCode":200}`),
+			defaultARN: func(payload any) ([]byte, error) {
+				received <- payload.(events.APIGatewayWebsocketProxyRequest)
+				return nil, fmt.Errorf("function error")
+			},
+		},
+	}
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	NewWebSocket(r, f, config.APIGW{
+		ID:                       "chat",
+		Type:                     "websocket",
+		RouteSelectionExpression: "$request.body.message.action",
+		Routes: map[string]string{
+			"$connect":    connectARN,
+			"$disconnect": disconnectARN,
+			"send":        sendARN,
+			"$default":    defaultARN,
+		},
+		Stages: []config.APIStage{{Name: "dev", Variables: map[string]string{"env": "dev"}}},
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/chat/dev/_user_request_"
+	dial := func(query string) (*websocket.Conn, *http.Response, error) {
+		return websocket.DefaultDialer.Dial(wsURL+query, http.Header{"Host": []string{apiHostName}})
+	}
+	next := func() events.APIGatewayWebsocketProxyRequest {
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the route was not invoked")
+		}
+		return events.APIGatewayWebsocketProxyRequest{}
+	}
+	management := func(method, connectionID, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/chat/dev/_user_request_/@connections/"+connectionID, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Host = apiHostName
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("the $connect route can reject the connection", func(t *testing.T) {
+		_, resp, err := dial("?token=deny")
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "denied", string(body))
+		assert.Equal(t, "CONNECT", next().RequestContext.EventType)
+	})
+
+	t.Run("requests that are not upgrades are rejected", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/chat/dev/_user_request_", nil)
+		require.NoError(t, err)
+		req.Host = apiHostName
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+	})
+
+	conn, _, err := dial("?token=allow")
+	require.NoError(t, err)
+	connect := next()
+	assert.Equal(t, "$connect", connect.RequestContext.RouteKey)
+	assert.Equal(t, "dev", connect.RequestContext.Stage)
+	assert.Equal(t, "chat", connect.RequestContext.APIID)
+	assert.Equal(t, "dev", connect.StageVariables["env"])
+	connectionID := connect.RequestContext.ConnectionID
+	require.NotEmpty(t, connectionID)
+
+	t.Run("messages are sent to the selected route", func(t *testing.T) {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"message":{"action":"send","text":"hello"}}`)))
+		event := next()
+		assert.Equal(t, "send", event.RequestContext.RouteKey)
+		assert.Equal(t, "MESSAGE", event.RequestContext.EventType)
+		assert.Equal(t, connectionID, event.RequestContext.ConnectionID)
+		assert.NotEmpty(t, event.RequestContext.MessageID)
+		assert.Equal(t, `{"message":{"action":"send","text":"hello"}}`, event.Body)
+	})
+
+	t.Run("unmatched messages are sent to the $default route", func(t *testing.T) {
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xff, 0x00}))
+		event := next()
+		assert.Equal(t, "$default", event.RequestContext.RouteKey)
+		assert.True(t, event.IsBase64Encoded)
+		assert.Equal(t, "/wA=", event.Body)
+
+		var msg map[string]string
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, "Internal server error", msg["message"])
+		assert.Equal(t, connectionID, msg["connectionId"])
+		assert.Equal(t, event.RequestContext.RequestID, msg["requestId"])
+	})
+
+	t.Run("the management api posts to the connection", func(t *testing.T) {
+		resp := management(http.MethodPost, connectionID, "pushed")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		messageType, message, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.TextMessage, messageType)
+		assert.Equal(t, "pushed", string(message))
+	})
+
+	t.Run("the management api describes the connection", func(t *testing.T) {
+		resp := management(http.MethodGet, connectionID, "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var info struct {
+			ConnectedAt  string            `json:"connectedAt"`
+			LastActiveAt string            `json:"lastActiveAt"`
+			Identity     map[string]string `json:"identity"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+		assert.NotEmpty(t, info.ConnectedAt)
+		assert.NotEmpty(t, info.LastActiveAt)
+		assert.Equal(t, "127.0.0.1", info.Identity["sourceIp"])
+	})
+
+	t.Run("unknown connections are gone", func(t *testing.T) {
+		for _, method := range []string{http.MethodPost, http.MethodGet, http.MethodDelete} {
+			resp := management(method, "unknown", "")
+			assert.Equal(t, http.StatusGone, resp.StatusCode, method)
+			assert.Equal(t, "GoneException", resp.Header.Get("X-Amzn-ErrorType"), method)
+		}
+	})
+
+	t.Run("the management api closes the connection", func(t *testing.T) {
+		resp := management(http.MethodDelete, connectionID, "")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+		event := next()
+		assert.Equal(t, "$disconnect", event.RequestContext.RouteKey)
+		assert.Equal(t, "DISCONNECT", event.RequestContext.EventType)
+		assert.Equal(t, connectionID, event.RequestContext.ConnectionID)
+		assert.Equal(t, http.StatusGone, management(http.MethodGet, connectionID, "").StatusCode)
+	})
+}
+
+func Test_WebSocketForbiddenWithoutRoute(t *testing.T) {
+	r := mux.NewRouter().Host(apiHostName).Subrouter()
+	NewWebSocket(r, &mockFactory{}, config.APIGW{ID: "chat", Type: "websocket"})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/chat", http.Header{"Host": []string{apiHostName}})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"send"}`)))
+	var msg map[string]string
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "Forbidden", msg["message"])
+}
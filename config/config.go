@@ -1,10 +1,81 @@
 package config
 
 type GoStack struct {
-	APIs     []APIGW             `yaml:"apigateways"`
-	ALBs     []ALB               `yaml:"albs"`
-	Lambdas  []Lambda            `yaml:"lambdas"`
-	MockData map[string]MockData `yaml:"mock-data"`
+	APIs        []APIGW             `yaml:"apigateways"`
+	Domains     []Domain            `yaml:"domains"`
+	ALBs        []ALB               `yaml:"albs"`
+	Lambdas     []Lambda            `yaml:"lambdas"`
+	MockData    map[string]MockData `yaml:"mock-data"`
+	CORS        *CORS               `yaml:"cors"`
+	UserPools   []UserPool          `yaml:"user-pools"`
+	APIKeys     []APIKey            `yaml:"api-keys"`
+	UsagePlans  []UsagePlan         `yaml:"usage-plans"`
+	Credentials []Credential        `yaml:"credentials"`
+	// RelaxLimits disables the payload and header size limits and timeouts of lambda, api gateway and the
+	// load balancers.
+	RelaxLimits bool `yaml:"relax-limits"`
+}
+
+// Credential is an access key accepted for IAM authorization, the caller and user arn default to
+// the access key id and an IAM user named after it.
+type Credential struct {
+	AccessKeyID     string `yaml:"access-key-id"`
+	SecretAccessKey string `yaml:"secret-access-key"`
+	Caller          string `yaml:"caller"`
+	UserARN         string `yaml:"user-arn"`
+}
+
+// APIKey is an API Gateway api key, keys are enabled unless disabled is set.
+type APIKey struct {
+	Name     string `yaml:"name"`
+	Value    string `yaml:"value"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+// UsagePlan limits the requests made with its api keys to the api stages of the plan.
+type UsagePlan struct {
+	Name      string           `yaml:"name"`
+	APIKeys   []string         `yaml:"api-keys"`
+	APIStages []UsagePlanStage `yaml:"api-stages"`
+	Throttle  *Throttle        `yaml:"throttle"`
+	Quota     *Quota           `yaml:"quota"`
+}
+
+// UsagePlanStage is an api stage of a usage plan, the throttle overrides the plan throttle for methods
+// keyed as {resource-path}/{METHOD} such as /pets/GET. An empty stage matches every stage of the api.
+type UsagePlanStage struct {
+	API      string              `yaml:"api"`
+	Stage    string              `yaml:"stage"`
+	Throttle map[string]Throttle `yaml:"throttle"`
+}
+
+// Throttle is a token bucket refilled at rate-limit requests per second holding up to burst-limit requests.
+type Throttle struct {
+	RateLimit  float64 `yaml:"rate-limit"`
+	BurstLimit int     `yaml:"burst-limit"`
+}
+
+// Quota is the number of requests allowed each DAY, WEEK or MONTH.
+type Quota struct {
+	Limit  int    `yaml:"limit"`
+	Period string `yaml:"period"`
+}
+
+// UserPool is a Cognito user pool, when clients are set only tokens for those app clients are accepted.
+type UserPool struct {
+	ID      string   `yaml:"id"`
+	Clients []string `yaml:"clients"`
+}
+
+// CORS is the cross-origin resource sharing configuration, origins may contain * wildcards
+// such as http://localhost:*.
+type CORS struct {
+	AllowOrigins     []string `yaml:"allow-origins"`
+	AllowMethods     []string `yaml:"allow-methods"`
+	AllowHeaders     []string `yaml:"allow-headers"`
+	ExposeHeaders    []string `yaml:"expose-headers"`
+	AllowCredentials bool     `yaml:"allow-credentials"`
+	MaxAge           int      `yaml:"max-age"`
 }
 
 type MockData struct {
@@ -13,8 +84,38 @@ type MockData struct {
 }
 
 type APIGW struct {
-	ID      string `yaml:"id"`
-	OA3path string `yaml:"openapi-spec"`
+	ID      string     `yaml:"id"`
+	OA3path string     `yaml:"openapi-spec"`
+	Stages  []APIStage `yaml:"stages"`
+	// Type is rest (the default) or websocket, websocket apis are configured with routes rather than a spec.
+	Type                     string            `yaml:"type"`
+	RouteSelectionExpression string            `yaml:"route-selection-expression"`
+	Routes                   map[string]string `yaml:"routes"`
+	// AccessLogFormat and AccessLogDestination are the access log settings of stages without their own.
+	AccessLogFormat      string `yaml:"access-log-format"`
+	AccessLogDestination string `yaml:"access-log-destination"`
+}
+
+type APIStage struct {
+	Name      string            `yaml:"name"`
+	Variables map[string]string `yaml:"variables"`
+	// Throttling is keyed by {resource-path}/{METHOD} such as /pets/GET, with */* for every method.
+	Throttling map[string]Throttle `yaml:"throttling"`
+	// AccessLogFormat is an access log format of $context variables, written to the AccessLogDestination
+	// of stdout (the default), stderr or a file path.
+	AccessLogFormat      string `yaml:"access-log-format"`
+	AccessLogDestination string `yaml:"access-log-destination"`
+}
+
+type Domain struct {
+	Name     string            `yaml:"name"`
+	Mappings []BasePathMapping `yaml:"mappings"`
+}
+
+type BasePathMapping struct {
+	BasePath string `yaml:"base-path"`
+	API      string `yaml:"api"`
+	Stage    string `yaml:"stage"`
 }
 
 type ALB struct {
@@ -51,8 +152,22 @@ type FixedResponse struct {
 }
 
 type Lambda struct {
-	Name        string             `yaml:"name"`
-	Zip         string             `yaml:"zip"`
-	Timeout     int                `yaml:"timeout"`
+	Name    string `yaml:"name"`
+	Zip     string `yaml:"zip"`
+	Timeout int    `yaml:"timeout"`
+	// Runtime is go1.x (the default) for functions using the rpc api of aws-lambda-go, or a custom runtime
+	// such as provided.al2 for functions using the runtime api, which can stream their responses.
+	Runtime     string             `yaml:"runtime"`
 	Environment map[string]*string `yaml:"environment"`
+	FunctionURL *FunctionURL       `yaml:"function-url"`
+}
+
+// FunctionURL serves a lambda from http://{name}.lambda-url.127.0.0.1.nip.io:{port}, the cors configuration
+// applies to the requests of the function url only.
+type FunctionURL struct {
+	// AuthType is NONE (the default) or AWS_IAM, which requires requests signed by the credentials.
+	AuthType string `yaml:"auth-type"`
+	// InvokeMode is BUFFERED (the default) or RESPONSE_STREAM, which streams the response as it is written.
+	InvokeMode string `yaml:"invoke-mode"`
+	CORS       *CORS  `yaml:"cors"`
 }
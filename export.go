@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/apigw"
+	"github.com/iwarapter/gostack/config"
+)
+
+type ExportCommand struct {
+	API    string `long:"api" description:"ID of the api to export" required:"true"`
+	Stage  string `long:"stage" description:"Name of the stage to export, defaults to the first stage"`
+	Format string `long:"format" description:"Format of the document" choice:"yaml" choice:"json" default:"yaml"`
+	Output string `short:"o" long:"output" description:"Path of the file to write, defaults to stdout"`
+}
+
+// runExport imports the spec of the api without starting its lambdas and writes the export of its stage.
+func runExport(stack config.GoStack, cmd ExportCommand, strict bool) error {
+	for _, apicfg := range stack.APIs {
+		if apicfg.ID != cmd.API {
+			continue
+		}
+		if strings.EqualFold(apicfg.Type, "websocket") {
+			return fmt.Errorf("unable to export api %s, websocket apis cannot be exported", apicfg.ID)
+		}
+		stage := cmd.Stage
+		if stage == "" && len(apicfg.Stages) > 0 {
+			stage = apicfg.Stages[0].Name
+		}
+		api := apigw.New(mux.NewRouter(), nil, apicfg)
+		doc, err := apigw.LoadSpec(apicfg.OA3path)
+		if err != nil {
+			return err
+		}
+		if err = api.Import(doc); err != nil {
+			return fmt.Errorf("unable to import spec %s error: %w", apicfg.OA3path, err)
+		}
+		if err = checkDiagnostics(api, apicfg, strict); err != nil {
+			return err
+		}
+		export, err := api.Export(stage)
+		if err != nil {
+			return err
+		}
+		b, err := apigw.MarshalExport(export, cmd.Format != "json")
+		if err != nil {
+			return fmt.Errorf("unable to marshal the export error: %w", err)
+		}
+		if cmd.Output == "" {
+			_, err = os.Stdout.Write(b)
+			return err
+		}
+		return os.WriteFile(cmd.Output, b, 0o644)
+	}
+	return fmt.Errorf("unable to export api %s, it is not defined in the configuration", cmd.API)
+}
@@ -0,0 +1,250 @@
+// Package cognito emulates the tokens of Cognito user pools. Tokens are signed with a key generated
+// at startup which is published on the jwks endpoint of every user pool.
+package cognito
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Host is the local hostname of the user pool endpoints.
+	Host          = "cognito-idp.127.0.0.1.nip.io"
+	keyID         = "gostack"
+	tokenValidity = time.Hour
+	defaultRegion = "us-east-1"
+)
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+)
+
+// signingKey generates the signing key on first use, so that processes without user pools do not
+// pay for the key generation.
+func signingKey() *rsa.PrivateKey {
+	keyOnce.Do(func() {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			log.Fatal().Err(err).Msg("unable to generate the cognito signing key")
+		}
+	})
+	return key
+}
+
+// Provider validates the tokens of the configured user pools and the mock data tokens.
+type Provider struct {
+	pools    map[string]config.UserPool
+	mockData map[string]config.MockData
+}
+
+func New(pools []config.UserPool, mockData map[string]config.MockData) *Provider {
+	p := &Provider{pools: map[string]config.UserPool{}, mockData: mockData}
+	for _, pool := range pools {
+		p.pools[pool.ID] = pool
+	}
+	return p
+}
+
+// PoolID returns the user pool id of a provider arn such as
+// arn:aws:cognito-idp:us-east-1:123456789012:userpool/us-east-1_abc123.
+func PoolID(providerARN string) (string, error) {
+	parts := strings.SplitN(providerARN, ":", 6)
+	if len(parts) != 6 || parts[2] != "cognito-idp" || !strings.HasPrefix(parts[5], "userpool/") {
+		return "", fmt.Errorf("invalid user pool arn %s", providerARN)
+	}
+	return strings.TrimPrefix(parts[5], "userpool/"), nil
+}
+
+// Issuer is the iss claim of the tokens of the pool, the region is taken from the pool id prefix.
+func Issuer(poolID string) string {
+	region, _, ok := strings.Cut(poolID, "_")
+	if !ok {
+		region = defaultRegion
+	}
+	return fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, poolID)
+}
+
+// Sign signs the claims with the user pool signing key.
+func Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(signingKey())
+}
+
+// ValidClient is true when the pool has no configured clients or the client is one of them.
+func (p *Provider) ValidClient(poolID, clientID string) bool {
+	pool, ok := p.pools[poolID]
+	if !ok || len(pool.Clients) == 0 {
+		return true
+	}
+	for _, c := range pool.Clients {
+		if c == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims returns the claims of a token issued by the pool, tokens in the mock data use the
+// userinfo and introspection of the mock data as their claims.
+func (p *Provider) Claims(poolID, token string) (map[string]any, error) {
+	if data, ok := p.mockData[token]; ok {
+		claims := map[string]any{}
+		for k, v := range data.Userinfo {
+			claims[k] = v
+		}
+		for k, v := range data.Introspection {
+			claims[k] = v
+		}
+		if active, ok := claims["active"].(bool); ok && !active {
+			return nil, errors.New("the mock data token is not active")
+		}
+		delete(claims, "active")
+		return claims, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		if kid, _ := t.Header["kid"].(string); kid != keyID {
+			return nil, fmt.Errorf("unknown signing key %s", kid)
+		}
+		return &signingKey().PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(Issuer(poolID), true) {
+		return nil, fmt.Errorf("the token was not issued by %s", poolID)
+	}
+	return claims, nil
+}
+
+// Register adds the jwks and token endpoints of the user pools to the router, the endpoints are served
+// for any pool id from the local host and the regional cognito-idp hosts.
+func (p *Provider) Register(router *mux.Router) {
+	for _, host := range []string{Host, "cognito-idp.{region}.amazonaws.com"} {
+		r := router.Host(host).Subrouter()
+		r.HandleFunc("/{pool}/.well-known/jwks.json", jwks).Methods(http.MethodGet)
+		r.HandleFunc("/{pool}/oauth2/token", p.token).Methods(http.MethodPost)
+	}
+}
+
+func jwks(w http.ResponseWriter, _ *http.Request) {
+	pub := signingKey().PublicKey
+	keys := map[string]any{
+		"keys": []map[string]string{{
+			"alg": jwt.SigningMethodRS256.Alg(),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			"kid": keyID,
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"use": "sig",
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
+// token issues tokens for the client_credentials and password grants, the password of the user is not checked.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	subl := log.With().Str("handler", "cognito-token").Logger()
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	poolID := mux.Vars(r)["pool"]
+	clientID := r.Form.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID = id
+	}
+	if clientID == "" || !p.ValidClient(poolID, clientID) {
+		subl.Info().Str("pool", poolID).Str("client_id", clientID).Msg("unknown client")
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	now := time.Now()
+	common := func(claims jwt.MapClaims) jwt.MapClaims {
+		claims["iss"] = Issuer(poolID)
+		claims["iat"] = now.Unix()
+		claims["auth_time"] = now.Unix()
+		claims["exp"] = now.Add(tokenValidity).Unix()
+		claims["jti"] = uuid(fmt.Sprintf("%s/%d", clientID, now.UnixNano()))
+		return claims
+	}
+	resp := map[string]any{
+		"token_type": "Bearer",
+		"expires_in": int(tokenValidity.Seconds()),
+	}
+	access := common(jwt.MapClaims{"token_use": "access", "client_id": clientID, "version": 2})
+	if scope := r.Form.Get("scope"); scope != "" {
+		access["scope"] = scope
+	}
+	switch r.Form.Get("grant_type") {
+	case "client_credentials":
+		access["sub"] = clientID
+	case "password":
+		username := r.Form.Get("username")
+		if username == "" {
+			tokenError(w, "invalid_request")
+			return
+		}
+		sub := uuid(poolID + "/" + username)
+		access["sub"] = sub
+		access["username"] = username
+		id := common(jwt.MapClaims{"token_use": "id", "aud": clientID, "sub": sub, "cognito:username": username})
+		if strings.Contains(username, "@") {
+			id["email"] = username
+			id["email_verified"] = true
+		}
+		signed, err := Sign(id)
+		if err != nil {
+			subl.Error().Err(err).Msg("unable to sign the id token")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp["id_token"] = signed
+	default:
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	signed, err := Sign(access)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to sign the access token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp["access_token"] = signed
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// uuid derives a stable uuid from the name, so users keep the same sub between tokens.
+func uuid(name string) string {
+	h := sha1.Sum([]byte(name))
+	h[6] = (h[6] & 0x0f) | 0x50
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
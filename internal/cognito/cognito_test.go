@@ -0,0 +1,97 @@
+package cognito
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolID(t *testing.T) {
+	id, err := PoolID("arn:aws:cognito-idp:eu-west-1:123456789012:userpool/eu-west-1_abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1_abc123", id)
+	assert.Equal(t, "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_abc123", Issuer(id))
+
+	_, err = PoolID("arn:aws:lambda:eu-west-1:123456789012:function:abc")
+	assert.Error(t, err)
+}
+
+func TestProvider_Register(t *testing.T) {
+	const pool = "us-east-1_unitTest"
+	p := New([]config.UserPool{{ID: pool, Clients: []string{"web"}}}, nil)
+	r := mux.NewRouter()
+	p.Register(r)
+
+	t.Run("jwks", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://"+Host+"/"+pool+"/.well-known/jwks.json", nil)
+		r.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var keys struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &keys))
+		require.Len(t, keys.Keys, 1)
+		assert.Equal(t, "RS256", keys.Keys[0]["alg"])
+		assert.Equal(t, keyID, keys.Keys[0]["kid"])
+		assert.Equal(t, "AQAB", keys.Keys[0]["e"])
+	})
+
+	token := func(form url.Values) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://cognito-idp.us-east-1.amazonaws.com/"+pool+"/oauth2/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(rec, req)
+		body := map[string]any{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	t.Run("client credentials", func(t *testing.T) {
+		code, body := token(url.Values{"grant_type": {"client_credentials"}, "client_id": {"web"}, "scope": {"pets/read"}})
+		require.Equal(t, http.StatusOK, code)
+		claims, err := p.Claims(pool, body["access_token"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, "access", claims["token_use"])
+		assert.Equal(t, "web", claims["client_id"])
+		assert.Equal(t, "pets/read", claims["scope"])
+		assert.Nil(t, body["id_token"])
+	})
+
+	t.Run("password", func(t *testing.T) {
+		code, body := token(url.Values{"grant_type": {"password"}, "client_id": {"web"}, "username": {"user@example.com"}})
+		require.Equal(t, http.StatusOK, code)
+		id, err := p.Claims(pool, body["id_token"].(string))
+		require.NoError(t, err)
+		access, err := p.Claims(pool, body["access_token"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, "id", id["token_use"])
+		assert.Equal(t, "web", id["aud"])
+		assert.Equal(t, "user@example.com", id["email"])
+		assert.Equal(t, id["sub"], access["sub"])
+		assert.Equal(t, "user@example.com", access["username"])
+
+		_, err = p.Claims("us-east-1_other", body["id_token"].(string))
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		code, body := token(url.Values{"grant_type": {"client_credentials"}, "client_id": {"mobile"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "invalid_client", body["error"])
+	})
+
+	t.Run("unsupported grant", func(t *testing.T) {
+		code, body := token(url.Values{"grant_type": {"implicit"}, "client_id": {"web"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "unsupported_grant_type", body["error"])
+	})
+}
@@ -0,0 +1,138 @@
+// Package eventstream encodes and decodes the messages of the application/vnd.amazon.eventstream format, as
+// used by InvokeWithResponseStream. Only string headers are supported.
+package eventstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// preludeSize is the total length, headers length and prelude crc of a message.
+	preludeSize = 12
+	crcSize     = 4
+	stringType  = 7
+	// maxMessageSize bounds the messages read by the decoder.
+	maxMessageSize = 24 * 1024 * 1024
+)
+
+// Header is a string header of a message.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Message is an event stream message, headers are written in order.
+type Message struct {
+	Headers []Header
+	Payload []byte
+}
+
+// Header is the value of the header of the message with the name.
+func (m Message) Header(name string) string {
+	for _, h := range m.Headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Encoder writes messages to the writer.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the message with a single write, so each message can be flushed as it is encoded.
+func (e *Encoder) Encode(m Message) error {
+	var headers bytes.Buffer
+	for _, h := range m.Headers {
+		if len(h.Name) > 255 || len(h.Value) > 65535 {
+			return fmt.Errorf("unable to encode the %s header error: the header is too long", h.Name)
+		}
+		headers.WriteByte(byte(len(h.Name)))
+		headers.WriteString(h.Name)
+		headers.WriteByte(stringType)
+		_ = binary.Write(&headers, binary.BigEndian, uint16(len(h.Value)))
+		headers.WriteString(h.Value)
+	}
+	total := preludeSize + headers.Len() + len(m.Payload) + crcSize
+	msg := make([]byte, 0, total)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(total))
+	msg = binary.BigEndian.AppendUint32(msg, uint32(headers.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	msg = append(msg, headers.Bytes()...)
+	msg = append(msg, m.Payload...)
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	_, err := e.w.Write(msg)
+	return err
+}
+
+// Decoder reads messages from the reader.
+type Decoder struct {
+	r io.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next message, checking the crcs of its prelude and of the whole message.
+func (d *Decoder) Decode() (Message, error) {
+	prelude := make([]byte, preludeSize)
+	if _, err := io.ReadFull(d.r, prelude); err != nil {
+		return Message{}, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return Message{}, errors.New("unable to decode the message error: the prelude crc does not match")
+	}
+	if total > maxMessageSize || uint64(total) < uint64(preludeSize)+uint64(headersLen)+crcSize {
+		return Message{}, fmt.Errorf("unable to decode the message error: invalid message length %d", total)
+	}
+	msg := make([]byte, total)
+	copy(msg, prelude)
+	if _, err := io.ReadFull(d.r, msg[preludeSize:]); err != nil {
+		return Message{}, err
+	}
+	end := len(msg) - crcSize
+	if crc32.ChecksumIEEE(msg[:end]) != binary.BigEndian.Uint32(msg[end:]) {
+		return Message{}, errors.New("unable to decode the message error: the message crc does not match")
+	}
+	headers, err := decodeHeaders(msg[preludeSize : preludeSize+headersLen])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Headers: headers, Payload: msg[preludeSize+headersLen : end]}, nil
+}
+
+func decodeHeaders(b []byte) ([]Header, error) {
+	var headers []Header
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+3 {
+			return nil, errors.New("unable to decode the headers error: truncated header")
+		}
+		name := string(b[1 : 1+nameLen])
+		b = b[1+nameLen:]
+		if b[0] != stringType {
+			return nil, fmt.Errorf("unable to decode the %s header error: unsupported header type %d", name, b[0])
+		}
+		valueLen := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+valueLen {
+			return nil, errors.New("unable to decode the headers error: truncated header")
+		}
+		headers = append(headers, Header{Name: name, Value: string(b[3 : 3+valueLen])})
+		b = b[3+valueLen:]
+	}
+	return headers, nil
+}
@@ -0,0 +1,52 @@
+package eventstream
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// payloadChunk is a PayloadChunk event of "hello" as encoded by the aws sdk.
+const payloadChunk = "0000006f0000005af5fe871f0b3a6576656e742d7479706507000c5061796c6f61644368756e6b0d3a636f6e74656e742d74797065" +
+	"0700186170706c69636174696f6e2f6f637465742d73747265616d0d3a6d6573736167652d747970650700056576656e7468656c6c6f29e71260"
+
+func TestEncoder(t *testing.T) {
+	msg := Message{
+		Headers: []Header{
+			{Name: ":event-type", Value: "PayloadChunk"},
+			{Name: ":content-type", Value: "application/octet-stream"},
+			{Name: ":message-type", Value: "event"},
+		},
+		Payload: []byte("hello"),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, NewEncoder(&buf).Encode(msg))
+	assert.Equal(t, payloadChunk, hex.EncodeToString(buf.Bytes()))
+
+	dec := NewDecoder(&buf)
+	decoded, err := dec.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, msg, decoded)
+	assert.Equal(t, "PayloadChunk", decoded.Header(":event-type"))
+	_, err = dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoderChecksTheCRCs(t *testing.T) {
+	b, err := hex.DecodeString(payloadChunk)
+	require.NoError(t, err)
+
+	corrupt := bytes.Clone(b)
+	corrupt[9]++
+	_, err = NewDecoder(bytes.NewReader(corrupt)).Decode()
+	assert.EqualError(t, err, "unable to decode the message error: the prelude crc does not match")
+
+	corrupt = bytes.Clone(b)
+	corrupt[len(corrupt)-5]++
+	_, err = NewDecoder(bytes.NewReader(corrupt)).Decode()
+	assert.EqualError(t, err, "unable to decode the message error: the message crc does not match")
+}
@@ -0,0 +1,103 @@
+package mw
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/iwarapter/gostack/config"
+)
+
+// CORS answers preflight requests from allowed origins and sets the CORS headers on their responses,
+// replacing any CORS headers set by the wrapped handler. Requests from other origins are passed through.
+func CORS(conf config.CORS) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !allowedOrigin(conf.AllowOrigins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				preflight(w, r, conf)
+				return
+			}
+			next.ServeHTTP(&corsResponseWriter{ResponseWriter: ResponseWriter{ResponseWriter: w}, conf: conf, origin: origin}, r)
+		})
+	}
+}
+
+func allowedOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if ok, _ := path.Match(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func preflight(w http.ResponseWriter, r *http.Request, conf config.CORS) {
+	setOriginHeaders(w.Header(), conf, r.Header.Get("Origin"))
+	methods := strings.Join(conf.AllowMethods, ",")
+	if methods == "" || methods == "*" {
+		methods = r.Header.Get("Access-Control-Request-Method")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	headers := strings.Join(conf.AllowHeaders, ",")
+	if len(conf.AllowHeaders) == 0 || headers == "*" {
+		headers = r.Header.Get("Access-Control-Request-Headers")
+	}
+	if headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if conf.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setOriginHeaders(h http.Header, conf config.CORS, origin string) {
+	for _, k := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+		h.Del(k)
+	}
+	// a wildcard cannot be used with credentials, so the origin is echoed instead
+	if len(conf.AllowOrigins) == 1 && conf.AllowOrigins[0] == "*" && !conf.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if conf.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(conf.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(conf.ExposeHeaders, ","))
+	}
+}
+
+// corsResponseWriter sets the CORS headers when the wrapped handler writes its response.
+type corsResponseWriter struct {
+	ResponseWriter
+	conf        config.CORS
+	origin      string
+	wroteHeader bool
+}
+
+func (cw *corsResponseWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		setOriginHeaders(cw.Header(), cw.conf, cw.origin)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *corsResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
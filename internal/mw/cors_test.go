@@ -0,0 +1,92 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://backend.example.com")
+		_, _ = w.Write([]byte(`OK!`))
+	})
+	tests := []struct {
+		name            string
+		conf            config.CORS
+		method          string
+		headers         map[string]string
+		status          int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:            "requests without an origin are passed through",
+			conf:            config.CORS{AllowOrigins: []string{"*"}},
+			method:          http.MethodGet,
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://backend.example.com"},
+		},
+		{
+			name:            "requests from other origins are passed through",
+			conf:            config.CORS{AllowOrigins: []string{"http://localhost:*"}},
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://evil.example.com"},
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://backend.example.com"},
+		},
+		{
+			name:    "allowed origins replace the handler cors headers",
+			conf:    config.CORS{AllowOrigins: []string{"http://localhost:*"}, AllowCredentials: true, ExposeHeaders: []string{"X-Request-Id"}},
+			method:  http.MethodGet,
+			headers: map[string]string{"Origin": "http://localhost:5173"},
+			status:  http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:5173",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-Id",
+			},
+		},
+		{
+			name:            "wildcard origins without credentials",
+			conf:            config.CORS{AllowOrigins: []string{"*"}},
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "http://localhost:5173"},
+			status:          http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{
+			name:   "preflight requests are answered",
+			conf:   config.CORS{AllowOrigins: []string{"http://localhost:*"}, AllowMethods: []string{"GET", "POST"}, MaxAge: 300},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "http://localhost:3000",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "authorization,content-type",
+			},
+			status: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "http://localhost:3000",
+				"Access-Control-Allow-Methods": "GET,POST",
+				"Access-Control-Allow-Headers": "authorization,content-type",
+				"Access-Control-Max-Age":       "300",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			CORS(tt.conf)(next).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			for k, v := range tt.expectedHeaders {
+				assert.Equal(t, v, rec.Header().Get(k), k)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package mw
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is embedded by the response writers of middleware, it lets http.ResponseController flush
+// streamed responses through Unwrap and lets websocket apis take over the connection through Hijack.
+type ResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (rw ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (rw ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
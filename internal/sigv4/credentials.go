@@ -0,0 +1,54 @@
+package sigv4
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iwarapter/gostack/config"
+)
+
+// Identity is the IAM identity of the credentials that signed a request.
+type Identity struct {
+	AccessKey string
+	AccountID string
+	Caller    string
+	UserARN   string
+}
+
+// Secrets looks up the secret access keys of the credentials.
+func Secrets(credentials []config.Credential) SecretFunc {
+	return func(accessKeyID string) (string, bool) {
+		for _, c := range credentials {
+			if c.AccessKeyID == accessKeyID {
+				return c.SecretAccessKey, true
+			}
+		}
+		return "", false
+	}
+}
+
+// CallerIdentity is the identity of the credentials of the access key, the caller and user arn default to the
+// access key id and an IAM user named after it in the account.
+func CallerIdentity(credentials []config.Credential, accessKeyID, accountID string) Identity {
+	id := Identity{
+		AccessKey: accessKeyID,
+		AccountID: accountID,
+		Caller:    accessKeyID,
+		UserARN:   fmt.Sprintf("arn:aws:iam::%s:user/%s", accountID, accessKeyID),
+	}
+	for _, c := range credentials {
+		if c.AccessKeyID != accessKeyID {
+			continue
+		}
+		if c.Caller != "" {
+			id.Caller = c.Caller
+		}
+		if c.UserARN != "" {
+			id.UserARN = c.UserARN
+			if parts := strings.Split(c.UserARN, ":"); len(parts) > 4 && parts[4] != "" {
+				id.AccountID = parts[4]
+			}
+		}
+	}
+	return id
+}
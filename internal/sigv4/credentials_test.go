@@ -0,0 +1,34 @@
+package sigv4
+
+import (
+	"testing"
+
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentials(t *testing.T) {
+	creds := []config.Credential{
+		{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"},
+		{AccessKeyID: "AKIDCALLER", SecretAccessKey: "other", Caller: "AIDACALLER", UserARN: "arn:aws:iam::210987654321:user/rex"},
+	}
+
+	secret, ok := Secrets(creds)("AKIDCALLER")
+	assert.True(t, ok)
+	assert.Equal(t, "other", secret)
+	_, ok = Secrets(creds)("AKIDUNKNOWN")
+	assert.False(t, ok)
+
+	assert.Equal(t, Identity{
+		AccessKey: "AKIDEXAMPLE",
+		AccountID: "123456789012",
+		Caller:    "AKIDEXAMPLE",
+		UserARN:   "arn:aws:iam::123456789012:user/AKIDEXAMPLE",
+	}, CallerIdentity(creds, "AKIDEXAMPLE", "123456789012"))
+	assert.Equal(t, Identity{
+		AccessKey: "AKIDCALLER",
+		AccountID: "210987654321",
+		Caller:    "AIDACALLER",
+		UserARN:   "arn:aws:iam::210987654321:user/rex",
+	}, CallerIdentity(creds, "AKIDCALLER", "123456789012"))
+}
@@ -0,0 +1,249 @@
+// Package sigv4 verifies AWS Signature Version 4 signed requests.
+package sigv4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+	// maxSkew is how far the signing time may be from the time the request is received.
+	maxSkew = 5 * time.Minute
+)
+
+// Kind classifies verification errors the way AWS reports them.
+type Kind int
+
+const (
+	// IncompleteSignature is a malformed Authorization header.
+	IncompleteSignature Kind = iota
+	// UnrecognizedClient is a signature by an unknown access key.
+	UnrecognizedClient
+	// InvalidSignature is a signature that does not match the request, is expired or has the wrong scope.
+	InvalidSignature
+)
+
+// Error is a verification error, the message is the text AWS responds with.
+type Error struct {
+	Kind    Kind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(kind Kind, format string, args ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// SecretFunc returns the secret access key of an access key id.
+type SecretFunc func(accessKeyID string) (string, bool)
+
+// authorization is a parsed AWS4-HMAC-SHA256 Authorization header.
+type authorization struct {
+	accessKeyID, date, region, service string
+	signedHeaders                      []string
+	signature                          string
+}
+
+// Verify checks the signature of the request for the region and service, the body is read and replaced
+// so it can be read again. The access key id of the signature is returned.
+func Verify(r *http.Request, region, service string, secret SecretFunc, now time.Time) (string, error) {
+	auth, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+
+	var signed time.Time
+	source := "X-Amz-Date"
+	if v := r.Header.Get("X-Amz-Date"); v != "" {
+		if signed, err = time.Parse(timeFormat, v); err != nil {
+			return "", errorf(IncompleteSignature, "Date must be in ISO-8601 'basic format'. Got '%s'. See http://en.wikipedia.org/wiki/ISO_8601", v)
+		}
+	} else if v := r.Header.Get("Date"); v != "" {
+		source = "Date"
+		if signed, err = http.ParseTime(v); err != nil {
+			return "", errorf(IncompleteSignature, "Date must be in ISO-8601 'basic format'. Got '%s'. See http://en.wikipedia.org/wiki/ISO_8601", v)
+		}
+	} else {
+		return "", errorf(IncompleteSignature, "Authorization header requires existence of either a 'X-Amz-Date' or a 'Date' header. Authorization=%s", r.Header.Get("Authorization"))
+	}
+	if auth.date != signed.UTC().Format(dateFormat) {
+		return "", errorf(InvalidSignature, "Date in Credential scope does not match YYYYMMDD from ISO-8601 version of date from HTTP: '%s' != '%s', from '%s' header.", auth.date, signed.UTC().Format(dateFormat), source)
+	}
+	now = now.UTC()
+	switch {
+	case signed.Before(now.Add(-maxSkew)):
+		return "", errorf(InvalidSignature, "Signature expired: %s is now earlier than %s (%s - 5 min.)", signed.UTC().Format(timeFormat), now.Add(-maxSkew).Format(timeFormat), now.Format(timeFormat))
+	case signed.After(now.Add(maxSkew)):
+		return "", errorf(InvalidSignature, "Signature not yet current: %s is still later than %s (%s + 5 min.)", signed.UTC().Format(timeFormat), now.Add(maxSkew).Format(timeFormat), now.Format(timeFormat))
+	}
+	if auth.service != service {
+		return "", errorf(InvalidSignature, "Credential should be scoped to correct service: '%s'. ", service)
+	}
+	if auth.region != region {
+		return "", errorf(InvalidSignature, "Credential should be scoped to a valid region. ")
+	}
+
+	key, ok := secret(auth.accessKeyID)
+	if !ok {
+		return "", errorf(UnrecognizedClient, "The security token included in the request is invalid.")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return "", errorf(IncompleteSignature, "Unable to read the request body.")
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if v := r.Header.Get("X-Amz-Content-Sha256"); v != "" && v != payloadHash {
+		return "", errorf(InvalidSignature, "The provided 'x-amz-content-sha256' header does not match what was computed.")
+	}
+
+	scope := strings.Join([]string{auth.date, auth.region, auth.service, "aws4_request"}, "/")
+	canonical := canonicalRequest(r, auth.signedHeaders, payloadHash)
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{algorithm, signed.UTC().Format(timeFormat), scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+key), auth.date)
+	for _, v := range []string{auth.region, auth.service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, v)
+	}
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(auth.signature)) {
+		return "", errorf(InvalidSignature, "The request signature we calculated does not match the signature you provided. Check your AWS Secret Access Key and signing method. Consult the service documentation for details.")
+	}
+	return auth.accessKeyID, nil
+}
+
+func parseAuthorization(header string) (authorization, error) {
+	var auth authorization
+	params, ok := strings.CutPrefix(header, algorithm+" ")
+	if !ok {
+		return auth, errorf(IncompleteSignature, "Authorization header requires '%s' algorithm. Authorization=%s", algorithm, header)
+	}
+	values := map[string]string{}
+	for _, p := range strings.Split(params, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok {
+			values[k] = v
+		}
+	}
+	missing := ""
+	for _, k := range []string{"Credential", "Signature", "SignedHeaders"} {
+		if values[k] == "" {
+			missing += fmt.Sprintf("Authorization header requires '%s' parameter. ", k)
+		}
+	}
+	if missing != "" {
+		return auth, errorf(IncompleteSignature, "%sAuthorization=%s", missing, header)
+	}
+	credential := strings.Split(values["Credential"], "/")
+	if len(credential) != 5 || credential[4] != "aws4_request" {
+		return auth, errorf(IncompleteSignature, "Credential should be scoped to a valid region. ")
+	}
+	auth.accessKeyID, auth.date, auth.region, auth.service = credential[0], credential[1], credential[2], credential[3]
+	auth.signedHeaders = strings.Split(values["SignedHeaders"], ";")
+	auth.signature = values["Signature"]
+	return auth, nil
+}
+
+// canonicalRequest is the canonical form of the request that is hashed into the string to sign.
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	headers := make([]string, 0, len(signedHeaders))
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = r.Header.Values(name)
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers = append(headers, name+":"+strings.Join(trimmed, ","))
+	}
+	return strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(r.URL),
+		strings.Join(headers, "\n") + "\n",
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// canonicalURI encodes the already escaped path again, as signers of services other than S3 do.
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	pairs := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{escape(k), escape(v)})
+		}
+	}
+	// parameters are sorted by name and then value
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// escape percent encodes everything but the RFC 3986 unreserved characters.
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
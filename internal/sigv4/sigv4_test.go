@@ -0,0 +1,167 @@
+package sigv4
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func secrets(accessKeyID string) (string, bool) {
+	if accessKeyID == "AKIDEXAMPLE" {
+		return "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", true
+	}
+	return "", false
+}
+
+// signed returns a request signed by the aws sdk as it is received by a server.
+func signed(t *testing.T, method, target, body string, signTime time.Time, accessKeyID, region, service string) *http.Request {
+	out, err := http.NewRequest(method, target, strings.NewReader(body))
+	require.NoError(t, err)
+	out.Header.Set("Content-Type", "application/json")
+	out.Header.Set("X-Custom", "  a   b ")
+	signer := v4.NewSigner(credentials.NewStaticCredentials(accessKeyID, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", ""))
+	_, err = signer.Sign(out, bytes.NewReader([]byte(body)), service, region, signTime)
+	require.NoError(t, err)
+
+	in := httptest.NewRequest(method, target, strings.NewReader(body))
+	in.Header = out.Header.Clone()
+	return in
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2023, 3, 16, 12, 0, 0, 0, time.UTC)
+	target := "https://abc123.execute-api.eu-west-1.amazonaws.com/prod/pets/a%20b?z=1&a=2&a=1&b=x+y&c=~"
+
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		kind    Kind
+		message string
+	}{
+		{
+			name: "valid signature",
+			req: func() *http.Request {
+				return signed(t, http.MethodPost, target, `{"name":"rex"}`, now, "AKIDEXAMPLE", "eu-west-1", "execute-api")
+			},
+		},
+		{
+			name: "valid signature within the allowed skew",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now.Add(-4*time.Minute), "AKIDEXAMPLE", "eu-west-1", "execute-api")
+			},
+		},
+		{
+			name: "missing algorithm",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, target, nil)
+				r.Header.Set("Authorization", "Bearer abc")
+				return r
+			},
+			kind:    IncompleteSignature,
+			message: "Authorization header requires 'AWS4-HMAC-SHA256' algorithm. Authorization=Bearer abc",
+		},
+		{
+			name: "missing parameters",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, target, nil)
+				r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20230316/eu-west-1/execute-api/aws4_request")
+				return r
+			},
+			kind:    IncompleteSignature,
+			message: "Authorization header requires 'Signature' parameter. Authorization header requires 'SignedHeaders' parameter. Authorization=AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20230316/eu-west-1/execute-api/aws4_request",
+		},
+		{
+			name: "tampered body",
+			req: func() *http.Request {
+				r := signed(t, http.MethodPost, target, `{"name":"rex"}`, now, "AKIDEXAMPLE", "eu-west-1", "execute-api")
+				r.Body = httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"name":"max"}`)).Body
+				return r
+			},
+			kind:    InvalidSignature,
+			message: "The request signature we calculated does not match the signature you provided. Check your AWS Secret Access Key and signing method. Consult the service documentation for details.",
+		},
+		{
+			name: "tampered query",
+			req: func() *http.Request {
+				r := signed(t, http.MethodGet, target, "", now, "AKIDEXAMPLE", "eu-west-1", "execute-api")
+				r.URL.RawQuery = "z=2"
+				return r
+			},
+			kind:    InvalidSignature,
+			message: "The request signature we calculated does not match the signature you provided. Check your AWS Secret Access Key and signing method. Consult the service documentation for details.",
+		},
+		{
+			name: "mismatched payload hash",
+			req: func() *http.Request {
+				r := signed(t, http.MethodGet, target, "", now, "AKIDEXAMPLE", "eu-west-1", "execute-api")
+				r.Header.Set("X-Amz-Content-Sha256", "abc")
+				return r
+			},
+			kind:    InvalidSignature,
+			message: "The provided 'x-amz-content-sha256' header does not match what was computed.",
+		},
+		{
+			name: "expired signature",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now.Add(-6*time.Minute), "AKIDEXAMPLE", "eu-west-1", "execute-api")
+			},
+			kind:    InvalidSignature,
+			message: "Signature expired: 20230316T115400Z is now earlier than 20230316T115500Z (20230316T120000Z - 5 min.)",
+		},
+		{
+			name: "future signature",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now.Add(6*time.Minute), "AKIDEXAMPLE", "eu-west-1", "execute-api")
+			},
+			kind:    InvalidSignature,
+			message: "Signature not yet current: 20230316T120600Z is still later than 20230316T120500Z (20230316T120000Z + 5 min.)",
+		},
+		{
+			name: "wrong service",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now, "AKIDEXAMPLE", "eu-west-1", "lambda")
+			},
+			kind:    InvalidSignature,
+			message: "Credential should be scoped to correct service: 'execute-api'. ",
+		},
+		{
+			name: "wrong region",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now, "AKIDEXAMPLE", "us-east-1", "execute-api")
+			},
+			kind:    InvalidSignature,
+			message: "Credential should be scoped to a valid region. ",
+		},
+		{
+			name: "unknown access key",
+			req: func() *http.Request {
+				return signed(t, http.MethodGet, target, "", now, "AKIDUNKNOWN", "eu-west-1", "execute-api")
+			},
+			kind:    UnrecognizedClient,
+			message: "The security token included in the request is invalid.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessKeyID, err := Verify(tt.req(), "eu-west-1", "execute-api", secrets, now)
+			if tt.message == "" {
+				require.NoError(t, err)
+				assert.Equal(t, "AKIDEXAMPLE", accessKeyID)
+				return
+			}
+			var verr *Error
+			require.True(t, errors.As(err, &verr), "error must be a *sigv4.Error")
+			assert.Equal(t, tt.kind, verr.Kind)
+			assert.Equal(t, tt.message, verr.Message)
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package vtl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type scope struct {
+	vars map[string]any
+}
+
+func (s *scope) resolve(ref *reference) (any, error) {
+	v, ok := s.vars[ref.name]
+	if !ok {
+		return nil, nil
+	}
+	for _, a := range ref.parts {
+		if v == nil {
+			return nil, nil
+		}
+		var err error
+		switch {
+		case a.index != nil:
+			var idx any
+			if idx, err = a.index.eval(s); err != nil {
+				return nil, err
+			}
+			v = index(v, idx)
+		case a.call:
+			args := make([]any, len(a.args))
+			for i, arg := range a.args {
+				if args[i], err = arg.eval(s); err != nil {
+					return nil, err
+				}
+			}
+			if v, err = call(v, a.name, args); err != nil {
+				return nil, err
+			}
+		default:
+			v = property(v, a.name)
+		}
+	}
+	return v, nil
+}
+
+func (n textNode) render(_ *scope, b *strings.Builder) error {
+	b.WriteString(string(n))
+	return nil
+}
+
+func (n refNode) render(s *scope, b *strings.Builder) error {
+	v, err := s.resolve(n.ref)
+	if err != nil {
+		return err
+	}
+	b.WriteString(String(v))
+	return nil
+}
+
+func (n setNode) render(s *scope, _ *strings.Builder) error {
+	v, err := n.value.eval(s)
+	if err != nil {
+		return err
+	}
+	// like velocity, assigning null leaves the reference unchanged
+	if v == nil {
+		return nil
+	}
+	if len(n.target.parts) == 0 {
+		s.vars[n.target.name] = v
+		return nil
+	}
+	last := n.target.parts[len(n.target.parts)-1]
+	parent, err := s.resolve(&reference{name: n.target.name, parts: n.target.parts[:len(n.target.parts)-1]})
+	if err != nil {
+		return err
+	}
+	if m, ok := parent.(map[string]any); ok && !last.call && last.index == nil {
+		m[last.name] = v
+	}
+	return nil
+}
+
+func (n ifNode) render(s *scope, b *strings.Builder) error {
+	for i, cond := range n.conds {
+		v, err := cond.eval(s)
+		if err != nil {
+			return err
+		}
+		if truthy(v) {
+			return renderNodes(n.blocks[i], s, b)
+		}
+	}
+	return renderNodes(n.elseBlock, s, b)
+}
+
+func (n foreachNode) render(s *scope, b *strings.Builder) error {
+	v, err := n.list.eval(s)
+	if err != nil {
+		return err
+	}
+	items := iterable(v)
+	prev, hadPrev := s.vars[n.name]
+	prevLoop, hadLoop := s.vars["foreach"]
+	for i, item := range items {
+		s.vars[n.name] = item
+		s.vars["foreach"] = map[string]any{
+			"index":   i,
+			"count":   i + 1,
+			"hasNext": i < len(items)-1,
+			"first":   i == 0,
+			"last":    i == len(items)-1,
+		}
+		s.vars["velocityCount"] = i + 1
+		if err := renderNodes(n.body, s, b); err != nil {
+			return err
+		}
+	}
+	restore(s, n.name, prev, hadPrev)
+	restore(s, "foreach", prevLoop, hadLoop)
+	delete(s.vars, "velocityCount")
+	return nil
+}
+
+func restore(s *scope, name string, v any, ok bool) {
+	if ok {
+		s.vars[name] = v
+	} else {
+		delete(s.vars, name)
+	}
+}
+
+func renderNodes(nodes []node, s *scope, b *strings.Builder) error {
+	for _, n := range nodes {
+		if err := n.render(s, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e literal) eval(_ *scope) (any, error) { return e.value, nil }
+
+func (e refExpr) eval(s *scope) (any, error) { return s.resolve(e.ref) }
+
+func (e interpolated) eval(s *scope) (any, error) {
+	var b strings.Builder
+	if err := renderNodes(e.nodes, s, &b); err != nil {
+		return nil, err
+	}
+	return b.String(), nil
+}
+
+func (e listExpr) eval(s *scope) (any, error) {
+	list := make([]any, len(e))
+	for i, item := range e {
+		v, err := item.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (e rangeExpr) eval(s *scope) (any, error) {
+	from, err := e.from.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	to, err := e.to.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	f, fok := number(from)
+	t, tok := number(to)
+	if !fok || !tok {
+		return nil, fmt.Errorf("vtl: range bounds must be numbers")
+	}
+	list := make([]any, 0)
+	step := 1
+	if t < f {
+		step = -1
+	}
+	for i := int(f); ; i += step {
+		list = append(list, i)
+		if i == int(t) {
+			return list, nil
+		}
+	}
+}
+
+func (e mapExpr) eval(s *scope) (any, error) {
+	m := make(map[string]any, len(e.keys))
+	for i := range e.keys {
+		k, err := e.keys[i].eval(s)
+		if err != nil {
+			return nil, err
+		}
+		v, err := e.values[i].eval(s)
+		if err != nil {
+			return nil, err
+		}
+		m[String(k)] = v
+	}
+	return m, nil
+}
+
+func (e unaryExpr) eval(s *scope) (any, error) {
+	v, err := e.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "-" {
+		if n, ok := v.(int); ok {
+			return -n, nil
+		}
+		if f, ok := number(v); ok {
+			return -f, nil
+		}
+		return nil, fmt.Errorf("vtl: cannot negate %s", String(v))
+	}
+	return !truthy(v), nil
+}
+
+func (e binaryExpr) eval(s *scope) (any, error) {
+	l, err := e.l.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&&", "and":
+		if !truthy(l) {
+			return false, nil
+		}
+		r, err := e.r.eval(s)
+		return truthy(r), err
+	case "||", "or":
+		if truthy(l) {
+			return true, nil
+		}
+		r, err := e.r.eval(s)
+		return truthy(r), err
+	}
+	r, err := e.r.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==", "eq":
+		return equal(l, r), nil
+	case "!=", "ne":
+		return !equal(l, r), nil
+	}
+	lf, lok := number(l)
+	rf, rok := number(r)
+	if e.op == "+" && (!lok || !rok) {
+		return String(l) + String(r), nil
+	}
+	if !lok || !rok {
+		return nil, fmt.Errorf("vtl: operator %s requires numbers, got %s and %s", e.op, String(l), String(r))
+	}
+	switch e.op {
+	case "<", "lt":
+		return lf < rf, nil
+	case "<=", "le":
+		return lf <= rf, nil
+	case ">", "gt":
+		return lf > rf, nil
+	case ">=", "ge":
+		return lf >= rf, nil
+	}
+	_, lint := l.(int)
+	_, rint := r.(int)
+	var res float64
+	switch e.op {
+	case "+":
+		res = lf + rf
+	case "-":
+		res = lf - rf
+	case "*":
+		res = lf * rf
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("vtl: division by zero")
+		}
+		if lint && rint {
+			return l.(int) / r.(int), nil
+		}
+		res = lf / rf
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("vtl: division by zero")
+		}
+		res = math.Mod(lf, rf)
+	}
+	if lint && rint {
+		return int(res), nil
+	}
+	return res, nil
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
+
+func equal(l, r any) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	lf, lok := number(l)
+	rf, rok := number(r)
+	if lok && rok {
+		return lf == rf
+	}
+	return String(l) == String(r)
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func iterable(v any) []any {
+	switch v := v.(type) {
+	case []any:
+		return v
+	case []string:
+		items := make([]any, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items
+	case map[string]any:
+		items := make([]any, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			items = append(items, v[k])
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func index(v, idx any) any {
+	switch v := v.(type) {
+	case []any:
+		if i, ok := number(idx); ok && int(i) >= 0 && int(i) < len(v) {
+			return v[int(i)]
+		}
+	case map[string]any:
+		return v[String(idx)]
+	case Object:
+		p, _ := v.Property(String(idx))
+		return p
+	}
+	return nil
+}
+
+func property(v any, name string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return v[name]
+	case Object:
+		p, _ := v.Property(name)
+		return p
+	}
+	return nil
+}
+
+func call(v any, method string, args []any) (any, error) {
+	switch v := v.(type) {
+	case Object:
+		return v.Call(method, args)
+	case map[string]any:
+		return mapMethod(v, method, args)
+	case []any:
+		return listMethod(v, method, args)
+	case string:
+		return stringMethod(v, method, args)
+	}
+	if method == "toString" {
+		return String(v), nil
+	}
+	return nil, fmt.Errorf("vtl: unknown method %s on %T", method, v)
+}
+
+func arg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func intArg(args []any, i int) int {
+	f, _ := number(arg(args, i))
+	return int(f)
+}
+
+func mapMethod(m map[string]any, method string, args []any) (any, error) {
+	switch method {
+	case "get":
+		return m[String(arg(args, 0))], nil
+	case "containsKey":
+		_, ok := m[String(arg(args, 0))]
+		return ok, nil
+	case "keySet":
+		keys := make([]any, 0, len(m))
+		for _, k := range sortedKeys(m) {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	case "values", "entrySet":
+		return iterable(m), nil
+	case "size":
+		return len(m), nil
+	case "isEmpty":
+		return len(m) == 0, nil
+	case "put":
+		k := String(arg(args, 0))
+		prev := m[k]
+		m[k] = arg(args, 1)
+		return prev, nil
+	case "remove":
+		k := String(arg(args, 0))
+		prev := m[k]
+		delete(m, k)
+		return prev, nil
+	case "toString":
+		return String(m), nil
+	}
+	return nil, fmt.Errorf("vtl: unknown map method %s", method)
+}
+
+func listMethod(l []any, method string, args []any) (any, error) {
+	switch method {
+	case "size":
+		return len(l), nil
+	case "isEmpty":
+		return len(l) == 0, nil
+	case "get":
+		return index(l, arg(args, 0)), nil
+	case "contains":
+		for _, item := range l {
+			if equal(item, arg(args, 0)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "toString":
+		return String(l), nil
+	}
+	return nil, fmt.Errorf("vtl: unknown list method %s", method)
+}
+
+func stringMethod(s, method string, args []any) (any, error) {
+	switch method {
+	case "length", "size":
+		return len(s), nil
+	case "isEmpty":
+		return s == "", nil
+	case "contains":
+		return strings.Contains(s, String(arg(args, 0))), nil
+	case "startsWith":
+		return strings.HasPrefix(s, String(arg(args, 0))), nil
+	case "endsWith":
+		return strings.HasSuffix(s, String(arg(args, 0))), nil
+	case "equals":
+		return s == String(arg(args, 0)), nil
+	case "equalsIgnoreCase":
+		return strings.EqualFold(s, String(arg(args, 0))), nil
+	case "indexOf":
+		return strings.Index(s, String(arg(args, 0))), nil
+	case "toLowerCase":
+		return strings.ToLower(s), nil
+	case "toUpperCase":
+		return strings.ToUpper(s), nil
+	case "trim":
+		return strings.TrimSpace(s), nil
+	case "replace":
+		return strings.ReplaceAll(s, String(arg(args, 0)), String(arg(args, 1))), nil
+	case "replaceAll", "matches", "split":
+		pattern := String(arg(args, 0))
+		if method == "matches" {
+			// java matches the entire string
+			pattern = "^(?:" + pattern + ")$"
+		}
+		rx, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("vtl: invalid regular expression: %w", err)
+		}
+		switch method {
+		case "matches":
+			return rx.MatchString(s), nil
+		case "split":
+			parts := rx.Split(s, -1)
+			list := make([]any, len(parts))
+			for i, p := range parts {
+				list[i] = p
+			}
+			return list, nil
+		}
+		return rx.ReplaceAllString(s, String(arg(args, 1))), nil
+	case "substring":
+		start, end := intArg(args, 0), len(s)
+		if len(args) > 1 {
+			end = intArg(args, 1)
+		}
+		if start < 0 || end > len(s) || start > end {
+			return nil, fmt.Errorf("vtl: substring index out of range")
+		}
+		return s[start:end], nil
+	case "toString":
+		return s, nil
+	}
+	return nil, fmt.Errorf("vtl: unknown string method %s", method)
+}
+
+// String renders a value the way velocity would when it is written to the output.
+func String(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = String(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		items := make([]string, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			items = append(items, k+"="+String(v[k]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,644 @@
+package vtl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	render(s *scope, b *strings.Builder) error
+}
+
+type textNode string
+
+type refNode struct {
+	ref *reference
+}
+
+type setNode struct {
+	target *reference
+	value  expr
+}
+
+type ifNode struct {
+	conds     []expr
+	blocks    [][]node
+	elseBlock []node
+}
+
+type foreachNode struct {
+	name string
+	list expr
+	body []node
+}
+
+// reference is a variable with its chain of property, method and index accessors.
+type reference struct {
+	name  string
+	parts []accessor
+}
+
+type accessor struct {
+	name  string
+	call  bool
+	args  []expr
+	index expr
+}
+
+type expr interface {
+	eval(s *scope) (any, error)
+}
+
+type literal struct{ value any }
+
+type refExpr struct{ ref *reference }
+
+type interpolated struct{ nodes []node }
+
+type listExpr []expr
+
+type rangeExpr struct{ from, to expr }
+
+type mapExpr struct{ keys, values []expr }
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("vtl: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// parseNodes parses template content until the end of the source or one of the stop directives,
+// the directive that ended the block is returned.
+func (p *parser) parseNodes(stop ...string) ([]node, string, error) {
+	var nodes []node
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, textNode(text.String()))
+			text.Reset()
+		}
+	}
+	for !p.eof() {
+		c := p.src[p.pos]
+		switch {
+		case c == '$':
+			start := p.pos
+			ref, ok, err := p.parseReference()
+			if err != nil {
+				return nil, "", err
+			}
+			if !ok {
+				p.pos = start + 1
+				text.WriteByte('$')
+				continue
+			}
+			flush()
+			nodes = append(nodes, refNode{ref: ref})
+		case p.peek("##"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 1
+			}
+		case p.peek("#*"):
+			end := strings.Index(p.src[p.pos+2:], "*#")
+			if end < 0 {
+				return nil, "", p.errorf("unterminated comment")
+			}
+			p.pos += end + 4
+		case p.peek("#[["):
+			end := strings.Index(p.src[p.pos+3:], "]]#")
+			if end < 0 {
+				return nil, "", p.errorf("unterminated unparsed content")
+			}
+			text.WriteString(p.src[p.pos+3 : p.pos+3+end])
+			p.pos += end + 6
+		case c == '#':
+			start := p.pos
+			name, ok := p.directiveName()
+			if !ok {
+				p.pos = start + 1
+				text.WriteByte('#')
+				continue
+			}
+			gobbleLine(p.src, start, &text)
+			for _, s := range stop {
+				if name == s {
+					flush()
+					if name != "elseif" {
+						p.gobbleEOL()
+					}
+					return nodes, name, nil
+				}
+			}
+			flush()
+			n, err := p.parseDirective(name)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, n)
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	if len(stop) > 0 {
+		return nil, "", p.errorf("missing #end")
+	}
+	return nodes, "", nil
+}
+
+// gobbleLine removes the indentation before a directive that starts its own line.
+func gobbleLine(src string, start int, text *strings.Builder) {
+	lineStart := strings.LastIndexByte(src[:start], '\n') + 1
+	indent := src[lineStart:start]
+	if strings.TrimLeft(indent, " \t") != "" || !strings.HasSuffix(text.String(), indent) {
+		return
+	}
+	s := text.String()
+	text.Reset()
+	text.WriteString(s[:len(s)-len(indent)])
+}
+
+// gobbleEOL consumes the rest of the line after a directive when it only contains whitespace.
+func (p *parser) gobbleEOL() {
+	i := p.pos
+	for i < len(p.src) && (p.src[i] == ' ' || p.src[i] == '\t' || p.src[i] == '\r') {
+		i++
+	}
+	if i < len(p.src) && p.src[i] == '\n' {
+		p.pos = i + 1
+	}
+}
+
+func (p *parser) directiveName() (string, bool) {
+	p.pos++
+	braced := !p.eof() && p.src[p.pos] == '{'
+	if braced {
+		p.pos++
+	}
+	name := p.ident()
+	switch name {
+	case "set", "if", "elseif", "else", "end", "foreach":
+	default:
+		return "", false
+	}
+	if braced {
+		if !p.peek("}") {
+			return "", false
+		}
+		p.pos++
+	}
+	return name, true
+}
+
+func (p *parser) parseDirective(name string) (node, error) {
+	switch name {
+	case "set":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		ref, ok, err := p.parseReference()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("#set requires a reference")
+		}
+		if err = p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		p.gobbleEOL()
+		return setNode{target: ref, value: value}, nil
+	case "if":
+		n := ifNode{}
+		for {
+			cond, err := p.parseCondition()
+			if err != nil {
+				return nil, err
+			}
+			block, end, err := p.parseNodes("elseif", "else", "end")
+			if err != nil {
+				return nil, err
+			}
+			n.conds = append(n.conds, cond)
+			n.blocks = append(n.blocks, block)
+			if end == "elseif" {
+				continue
+			}
+			if end == "else" {
+				if n.elseBlock, _, err = p.parseNodes("end"); err != nil {
+					return nil, err
+				}
+			}
+			return n, nil
+		}
+	case "foreach":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.peek("$") {
+			return nil, p.errorf("#foreach requires a loop variable")
+		}
+		p.pos++
+		name := p.ident()
+		p.skipSpace()
+		if p.ident() != "in" {
+			return nil, p.errorf("#foreach requires 'in'")
+		}
+		list, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		p.gobbleEOL()
+		body, _, err := p.parseNodes("end")
+		if err != nil {
+			return nil, err
+		}
+		return foreachNode{name: name, list: list, body: body}, nil
+	}
+	return nil, p.errorf("unexpected #%s", name)
+}
+
+func (p *parser) parseCondition() (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	cond, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+	p.gobbleEOL()
+	return cond, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	if p.eof() || !isIdentStart(p.src[p.pos]) {
+		return ""
+	}
+	for !p.eof() && isIdent(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// parseReference parses $name, $!name, ${name} and $!{name} followed by any accessors, ok is
+// false when the $ does not start a reference.
+func (p *parser) parseReference() (*reference, bool, error) {
+	p.pos++
+	if p.peek("!") {
+		p.pos++
+	}
+	braced := p.peek("{")
+	if braced {
+		p.pos++
+	}
+	name := p.ident()
+	if name == "" {
+		return nil, false, nil
+	}
+	ref := &reference{name: name}
+	for !p.eof() {
+		if p.peek(".") && p.pos+1 < len(p.src) && isIdentStart(p.src[p.pos+1]) {
+			p.pos++
+			a := accessor{name: p.ident()}
+			if p.peek("(") {
+				p.pos++
+				args, err := p.parseList(")")
+				if err != nil {
+					return nil, false, err
+				}
+				a.call, a.args = true, args
+			}
+			ref.parts = append(ref.parts, a)
+			continue
+		}
+		if p.peek("[") {
+			p.pos++
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, false, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, false, err
+			}
+			ref.parts = append(ref.parts, accessor{index: index})
+			continue
+		}
+		break
+	}
+	if braced {
+		if !p.peek("}") {
+			return nil, false, p.errorf("missing } for reference $%s", name)
+		}
+		p.pos++
+	}
+	return ref, true, nil
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *parser) expect(tok string) error {
+	p.skipSpace()
+	if !p.peek(tok) {
+		return p.errorf("expected '%s'", tok)
+	}
+	p.pos += len(tok)
+	return nil
+}
+
+// accept consumes the operator if it is next, word operators must not be followed by an identifier.
+func (p *parser) accept(ops ...string) string {
+	p.skipSpace()
+	for _, op := range ops {
+		if !p.peek(op) {
+			continue
+		}
+		end := p.pos + len(op)
+		if isIdentStart(op[0]) && end < len(p.src) && isIdent(p.src[end]) {
+			continue
+		}
+		// don't mistake the start of a two character operator for a single one
+		if (op == "<" || op == ">" || op == "!") && end < len(p.src) && p.src[end] == '=' {
+			continue
+		}
+		p.pos = end
+		return op
+	}
+	return ""
+}
+
+func (p *parser) parseList(end string) ([]expr, error) {
+	var list []expr
+	p.skipSpace()
+	if p.peek(end) {
+		p.pos += len(end)
+		return list, nil
+	}
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if p.accept(",") != "" {
+			continue
+		}
+		if err = p.expect(end); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseBinary(0)
+}
+
+var precedence = [][]string{
+	{"||", "or"},
+	{"&&", "and"},
+	{"==", "!=", "eq", "ne"},
+	{"<=", ">=", "<", ">", "le", "ge", "lt", "gt"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.accept(precedence[level]...)
+		if op == "" {
+			return l, nil
+		}
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if op := p.accept("!", "not", "-"); op != "" {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case c == '$':
+		ref, ok, err := p.parseReference()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("invalid reference")
+		}
+		return refExpr{ref: ref}, nil
+	case c == '\'' || c == '"':
+		return p.parseString(c)
+	case c >= '0' && c <= '9':
+		return p.parseNumber()
+	case c == '[':
+		p.pos++
+		list, err := p.parseRangeOrList()
+		if err != nil {
+			return nil, err
+		}
+		return list, nil
+	case c == '{':
+		p.pos++
+		m := mapExpr{}
+		if p.accept("}") != "" {
+			return m, nil
+		}
+		for {
+			k, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			v, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			m.keys, m.values = append(m.keys, k), append(m.values, v)
+			if p.accept(",") != "" {
+				continue
+			}
+			return m, p.expect("}")
+		}
+	}
+	switch word := p.ident(); word {
+	case "true":
+		return literal{value: true}, nil
+	case "false":
+		return literal{value: false}, nil
+	case "null":
+		return literal{value: nil}, nil
+	default:
+		return nil, p.errorf("unexpected '%s'", word+string(c))
+	}
+}
+
+func (p *parser) parseRangeOrList() (expr, error) {
+	p.skipSpace()
+	if p.peek("]") {
+		p.pos++
+		return listExpr{}, nil
+	}
+	first, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("..") != "" {
+		to, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return rangeExpr{from: first, to: to}, p.expect("]")
+	}
+	list := listExpr{first}
+	if p.accept(",") != "" {
+		rest, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+		return append(list, rest...), nil
+	}
+	return list, p.expect("]")
+}
+
+func (p *parser) parseString(quote byte) (expr, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return nil, p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		if c == quote {
+			// a doubled quote is an escaped quote
+			if !p.eof() && p.src[p.pos] == quote {
+				b.WriteByte(quote)
+				p.pos++
+				continue
+			}
+			break
+		}
+		b.WriteByte(c)
+	}
+	if quote == '\'' || !strings.ContainsAny(b.String(), "$#") {
+		return literal{value: b.String()}, nil
+	}
+	inner := &parser{src: b.String()}
+	nodes, _, err := inner.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	return interpolated{nodes: nodes}, nil
+}
+
+func (p *parser) parseNumber() (expr, error) {
+	start := p.pos
+	for !p.eof() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	// a single dot followed by a digit is a decimal, two dots are a range
+	if p.peek(".") && !p.peek("..") && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+		p.pos++
+		for !p.eof() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number: %s", err)
+		}
+		return literal{value: f}, nil
+	}
+	i, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		return nil, p.errorf("invalid number: %s", err)
+	}
+	return literal{value: i}, nil
+}
@@ -0,0 +1,52 @@
+// Package vtl implements the subset of the Apache Velocity Template Language used by
+// API Gateway mapping templates.
+package vtl
+
+import (
+	"strings"
+)
+
+// Object is implemented by values that expose properties and methods to templates,
+// such as the $input and $util variables of a mapping template.
+type Object interface {
+	Property(name string) (any, bool)
+	Call(method string, args []any) (any, error)
+}
+
+// Template is a parsed template that can be executed multiple times.
+type Template struct {
+	nodes []node
+}
+
+// Parse parses the template source.
+func Parse(src string) (*Template, error) {
+	p := &parser{src: src}
+	nodes, _, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+// MustParse is like Parse but panics if the template cannot be parsed.
+func MustParse(src string) *Template {
+	t, err := Parse(src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Execute renders the template with the given variables, variables set by the template
+// are not written back to vars.
+func (t *Template) Execute(vars map[string]any) (string, error) {
+	s := &scope{vars: make(map[string]any, len(vars))}
+	for k, v := range vars {
+		s.vars[k] = v
+	}
+	var b strings.Builder
+	if err := renderNodes(t.nodes, s, &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,90 @@
+package vtl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type upper struct{}
+
+func (upper) Property(name string) (any, bool) {
+	return strings.ToUpper(name), true
+}
+
+func (upper) Call(method string, args []any) (any, error) {
+	if method != "join" {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	items := make([]string, len(args))
+	for i, a := range args {
+		items[i] = strings.ToUpper(String(a))
+	}
+	return strings.Join(items, "-"), nil
+}
+
+func TestTemplate_Execute(t *testing.T) {
+	vars := map[string]any{
+		"name":   "gostack",
+		"count":  3,
+		"price":  float64(10),
+		"items":  []any{"a", "b", "c"},
+		"params": map[string]any{"header": map[string]any{"X-Tenant": "unit-test"}},
+		"obj":    upper{},
+	}
+	tests := []struct {
+		name, tmpl, expected string
+	}{
+		{name: "plain text", tmpl: `{"foo": "bar"}`, expected: `{"foo": "bar"}`},
+		{name: "references", tmpl: `Hello $name, ${name}! $!missing$missing`, expected: `Hello gostack, gostack! `},
+		{name: "dollars that are not references", tmpl: `$ 10 $.foo $1`, expected: `$ 10 $.foo $1`},
+		{name: "properties", tmpl: `$params.header.X-Tenant`, expected: `-Tenant`},
+		{name: "map get", tmpl: `$params.header.get('X-Tenant')`, expected: `unit-test`},
+		{name: "index", tmpl: `$items[1] $params['header']['X-Tenant']`, expected: `b unit-test`},
+		{name: "string methods", tmpl: `$name.toUpperCase() $name.length() $name.replaceAll('s', 'z') $name.substring(2)`, expected: `GOSTACK 7 goztack stack`},
+		{name: "objects", tmpl: `$obj.foo $obj.join($name, 'x')`, expected: `FOO GOSTACK-X`},
+		{name: "integral floats", tmpl: `$price`, expected: `10`},
+		{name: "set", tmpl: "#set($x = $count * 2 + 1)\n$x", expected: `7`},
+		{name: "set string interpolation", tmpl: `#set($x = "hi $name")$x`, expected: `hi gostack`},
+		{name: "set null is ignored", tmpl: `#set($x = 'a')#set($x = $missing)$x`, expected: `a`},
+		{name: "set map key", tmpl: `#set($m = {})#set($m.k = 'v')$m.k`, expected: `v`},
+		{name: "if else", tmpl: `#if($count > 5)big#elseif($count == 3)three#{else}small#end`, expected: `three`},
+		{name: "if logical", tmpl: `#if($name == 'gostack' && !$missing)yes#end`, expected: `yes`},
+		{name: "if word operators", tmpl: `#if($count gt 1 and not $missing)yes#end`, expected: `yes`},
+		{name: "foreach", tmpl: `[#foreach($i in $items)"$i"#if($foreach.hasNext),#end#end]`, expected: `["a","b","c"]`},
+		{name: "foreach range", tmpl: `#foreach($i in [1..3])$i#end`, expected: `123`},
+		{name: "string concatenation", tmpl: `#set($x = $name + '-' + $count)$x`, expected: `gostack-3`},
+		{name: "comments", tmpl: "a## comment\nb#* block *#c", expected: `abc`},
+		{name: "directive lines are gobbled", tmpl: "{\n  #if($name)\n  \"name\": \"$name\"\n  #end\n}", expected: "{\n  \"name\": \"gostack\"\n}"},
+		{name: "unparsed content", tmpl: `#[[$name]]#`, expected: `$name`},
+		{name: "hash that is not a directive", tmpl: `#foo #`, expected: `#foo #`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.tmpl)
+			require.NoError(t, err)
+			out, err := tmpl.Execute(vars)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, tmpl := range []string{
+		`#if($a)`,
+		`#set($a = )`,
+		`#end`,
+		`${name`,
+		`#foreach($i $items)#end`,
+		`#* unterminated`,
+	} {
+		t.Run(tmpl, func(t *testing.T) {
+			_, err := Parse(tmpl)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package lambstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/internal/eventstream"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Host is the hostname of the lambda api, sdk clients use http://lambda.127.0.0.1.nip.io:{port} as their endpoint.
+const Host = "lambda.127.0.0.1.nip.io"
+
+const (
+	region            = "us-east-1"
+	accountID         = "123456789012"
+	functionARNPrefix = "arn:aws:lambda:" + region + ":" + accountID + ":function:"
+)
+
+// Register adds the Invoke and InvokeWithResponseStream apis of the functions to the router.
+func Register(router *mux.Router, lambs LambdaFactory) {
+	for _, host := range []string{Host, "lambda.{region}.amazonaws.com"} {
+		r := router.Host(host).Subrouter()
+		r.HandleFunc("/2015-03-31/functions/{function}/invocations", invoke(lambs)).Methods(http.MethodPost)
+		r.HandleFunc("/2021-11-15/functions/{function}/response-streaming-invocations", invokeWithResponseStream(lambs)).
+			Methods(http.MethodPost)
+	}
+}
+
+// FunctionARN is the arn of a function given its name, partial arn or arn.
+func FunctionARN(function string) string {
+	if strings.HasPrefix(function, "arn:") {
+		return function
+	}
+	return functionARNPrefix + strings.TrimPrefix(function, accountID+":function:")
+}
+
+// invoke invokes the function as the X-Amz-Invocation-Type of RequestResponse (the default), Event or DryRun,
+// errors of the function are returned as the body with the X-Amz-Function-Error header.
+func invoke(lambs LambdaFactory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "lambda-invoke").Logger()
+		arn := FunctionARN(mux.Vars(r)["function"])
+		payload, ok := readPayload(w, r, subl)
+		if !ok {
+			return
+		}
+		var b []byte
+		var err error
+		switch invocationType := r.Header.Get("X-Amz-Invocation-Type"); invocationType {
+		case "", "RequestResponse":
+			b, err = lambs.Invoke(arn, json.RawMessage(payload))
+		case "Event":
+			if err = lambs.InvokeAsync(arn, json.RawMessage(payload)); err == nil {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+		case "DryRun":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			writeAPIError(w, http.StatusBadRequest, "InvalidParameterValueException", fmt.Sprintf("Unsupported invocation type %s", invocationType))
+			return
+		}
+		var fnErr *FunctionError
+		var tooLarge *PayloadTooLargeError
+		switch {
+		case errors.Is(err, ErrFunctionNotFound):
+			writeAPIError(w, http.StatusNotFound, "ResourceNotFoundException", fmt.Sprintf("Function not found: %s", arn))
+			return
+		case errors.As(err, &tooLarge):
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "RequestEntityTooLargeException", tooLarge.Error())
+			return
+		case errors.As(err, &fnErr):
+			b, _ = json.Marshal(fnErr)
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+		case err != nil:
+			subl.Error().Err(err).Str("arn", arn).Msg("unable to invoke the function")
+			writeAPIError(w, http.StatusInternalServerError, "ServiceException", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amz-Executed-Version", "$LATEST")
+		_, _ = w.Write(b)
+	}
+}
+
+// readPayload reads the json payload of an invocation, an empty payload is an empty object.
+func readPayload(w http.ResponseWriter, r *http.Request, subl zerolog.Logger) ([]byte, bool) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to read body")
+		writeAPIError(w, http.StatusBadRequest, "InvalidRequestContentException", "Could not read the request body")
+		return nil, false
+	}
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	if !json.Valid(payload) {
+		writeAPIError(w, http.StatusBadRequest, "InvalidRequestContentException", "Could not parse request body into json")
+		return nil, false
+	}
+	return payload, true
+}
+
+// invokeWithResponseStream invokes the function, the response is sent as PayloadChunk events of an event stream
+// as the function streams it followed by an InvokeComplete event reporting any error of the function.
+func invokeWithResponseStream(lambs LambdaFactory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subl := log.With().Str("handler", "lambda-invoke-with-response-stream").Logger()
+		arn := FunctionARN(mux.Vars(r)["function"])
+		payload, ok := readPayload(w, r, subl)
+		if !ok {
+			return
+		}
+		stream := &eventStreamWriter{w: w, enc: eventstream.NewEncoder(NewFlushWriter(w))}
+		err := lambs.InvokeWithResponseStream(arn, json.RawMessage(payload), stream)
+		if errors.Is(err, ErrFunctionNotFound) {
+			writeAPIError(w, http.StatusNotFound, "ResourceNotFoundException", fmt.Sprintf("Function not found: %s", arn))
+			return
+		}
+		var tooLarge *PayloadTooLargeError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "RequestEntityTooLargeException", tooLarge.Error())
+			return
+		}
+		complete := map[string]string{}
+		var fnErr *FunctionError
+		if errors.As(err, &fnErr) {
+			details, _ := json.Marshal(fnErr)
+			complete["ErrorCode"] = fnErr.Type
+			complete["ErrorDetails"] = string(details)
+		} else if err != nil {
+			subl.Error().Err(err).Str("arn", arn).Msg("unable to invoke the function")
+			complete["ErrorCode"] = "ServiceException"
+			complete["ErrorDetails"] = err.Error()
+		}
+		b, _ := json.Marshal(complete)
+		if err = stream.event("InvokeComplete", "application/json", b); err != nil {
+			subl.Error().Err(err).Msg("unable to write the event stream")
+		}
+	}
+}
+
+// eventStreamWriter encodes each write as a PayloadChunk event, the headers of the response are written
+// with the first event.
+type eventStreamWriter struct {
+	w       http.ResponseWriter
+	enc     *eventstream.Encoder
+	started bool
+}
+
+func (s *eventStreamWriter) Write(p []byte) (int, error) {
+	if err := s.event("PayloadChunk", "application/octet-stream", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *eventStreamWriter) event(eventType, contentType string, payload []byte) error {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		s.w.Header().Set("X-Amz-Executed-Version", "$LATEST")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	return s.enc.Encode(eventstream.Message{
+		Headers: []eventstream.Header{
+			{Name: ":event-type", Value: eventType},
+			{Name: ":content-type", Value: contentType},
+			{Name: ":message-type", Value: "event"},
+		},
+		Payload: payload,
+	})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", errorType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"Type": "User", "message": message})
+}
@@ -0,0 +1,73 @@
+// streaming is a custom runtime that streams its response through the runtime api, writing each of the
+// chunks of the event with a delay between them and reporting the error of the event once they are written.
+// Responses cut short by the timeout are logged and the next event is polled.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+type event struct {
+	Chunks []string `json:"chunks"`
+	Delay  int      `json:"delay"`
+	Error  string   `json:"error"`
+}
+
+func main() {
+	api := "http://" + os.Getenv("AWS_LAMBDA_RUNTIME_API") + "/2018-06-01/runtime/invocation/"
+	for {
+		resp, err := http.Get(api + "next")
+		if err != nil {
+			panic(err)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		var e event
+		err = json.NewDecoder(resp.Body).Decode(&e)
+		_ = resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if err = stream(api+id+"/response", e); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+func stream(url string, e event) error {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, url, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Lambda-Runtime-Function-Response-Mode", "streaming")
+	req.Trailer = http.Header{"Lambda-Runtime-Function-Error-Type": nil, "Lambda-Runtime-Function-Error-Body": nil}
+	go func() {
+		for i, chunk := range e.Chunks {
+			if i > 0 {
+				time.Sleep(time.Duration(e.Delay) * time.Millisecond)
+			}
+			_, _ = io.WriteString(pw, chunk)
+		}
+		if e.Error != "" {
+			body, _ := json.Marshal(map[string]string{"errorMessage": e.Error, "errorType": "StreamError"})
+			req.Trailer.Set("Lambda-Runtime-Function-Error-Type", "StreamError")
+			req.Trailer.Set("Lambda-Runtime-Function-Error-Body", base64.StdEncoding.EncodeToString(body))
+		}
+		_ = pw.Close()
+	}()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
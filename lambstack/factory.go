@@ -3,6 +3,8 @@ package lambstack
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -19,20 +21,31 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrFunctionNotFound is returned when invoking a function that has not been added.
+var ErrFunctionNotFound = errors.New("no lambstack with arn")
+
 type LambdaFactory interface {
 	io.Closer
 	Invoke(arn string, payload any) ([]byte, error)
+	// InvokeWithResponseStream writes the response of the function to w as it is streamed by the function,
+	// the response of functions that do not stream is written once complete.
+	InvokeWithResponseStream(arn string, payload any, w io.Writer) error
+	// InvokeAsync queues the invocation of the function, the response is discarded.
+	InvokeAsync(arn string, payload any) error
 	Add(input lambda.CreateFunctionInput) (string, error)
 }
 
 type lambstack struct {
 	name        string
+	arn         string
 	timeout     int64
 	port        int
 	path        string
 	environment map[string]string
 	cmd         *exec.Cmd
 	mu          sync.Mutex
+	// runtime is the runtime api of functions using a custom runtime, other functions are invoked over rpc.
+	runtime *runtimeAPI
 }
 
 func (l *lambstack) Start() error {
@@ -40,7 +53,12 @@ func (l *lambstack) Start() error {
 	for key, val := range l.environment {
 		l.cmd.Env = append(l.cmd.Env, fmt.Sprintf("%s=%s", key, val))
 	}
-	l.cmd.Env = append(l.cmd.Env, fmt.Sprintf("_LAMBDA_SERVER_PORT=%d", l.port))
+	if l.runtime != nil {
+		l.cmd.Env = append(l.cmd.Env, fmt.Sprintf("AWS_LAMBDA_RUNTIME_API=%s", l.runtime.addr()))
+		l.cmd.Env = append(l.cmd.Env, fmt.Sprintf("AWS_LAMBDA_FUNCTION_NAME=%s", l.name))
+	} else {
+		l.cmd.Env = append(l.cmd.Env, fmt.Sprintf("_LAMBDA_SERVER_PORT=%d", l.port))
+	}
 	l.cmd.Env = append(l.cmd.Env, "_X_AMZN_TRACE_ID=Root=1-00000000-000000000000000000000000;Parent")
 	l.cmd.Dir = l.path
 	l.cmd.Stderr = log.With().Str("level", zerolog.InfoLevel.String()).Str("functionName", l.name).Logger()
@@ -50,10 +68,20 @@ func (l *lambstack) Start() error {
 
 func (l *lambstack) Stop() error {
 	log.Info().Str("functionName", l.name).Msg("stopping lambda")
+	if l.runtime != nil {
+		_ = l.runtime.Close()
+	}
 	return l.cmd.Process.Kill()
 }
 
 func (l *lambstack) Invoke(payload any) ([]byte, error) {
+	if l.runtime != nil {
+		var buf bytes.Buffer
+		if err := l.InvokeWithResponseStream(payload, &buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
 	t := time.Now().Add(time.Second * time.Duration(l.timeout))
 	l.mu.Lock()
 	b, err := Run(Input{
@@ -68,11 +96,31 @@ func (l *lambstack) Invoke(payload any) ([]byte, error) {
 	return b, err
 }
 
+func (l *lambstack) InvokeWithResponseStream(payload any, w io.Writer) error {
+	if l.runtime == nil {
+		b, err := l.Invoke(payload)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.runtime.invoke(l.arn, b, time.Now().Add(time.Second*time.Duration(l.timeout)), w)
+}
+
 type Factory struct {
-	lambdas map[string]*lambstack
+	// RelaxLimits disables the payload size limits of invocations.
+	RelaxLimits bool
+	lambdas     map[string]*lambstack
 }
 
-func New() LambdaFactory {
+func New() *Factory {
 	return &Factory{
 		lambdas: map[string]*lambstack{},
 	}
@@ -88,12 +136,71 @@ func (f *Factory) Close() error {
 	return nil
 }
 
+// Invoke invokes the function synchronously, the request and response payloads are limited to
+// MaxSyncPayload bytes.
 func (f *Factory) Invoke(arn string, payload any) ([]byte, error) {
-	if l, ok := f.lambdas[arn]; !ok {
-		return nil, fmt.Errorf("no lambstack with arn: %s", arn)
-	} else {
-		return l.Invoke(payload)
+	l, ok := f.lambdas[arn]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFunctionNotFound, arn)
 	}
+	if err := f.checkRequest(payload, MaxSyncPayload); err != nil {
+		return nil, err
+	}
+	b, err := l.Invoke(payload)
+	if err == nil && !f.RelaxLimits && len(b) > MaxSyncPayload {
+		return nil, responseTooLarge(MaxSyncPayload)
+	}
+	return b, err
+}
+
+// InvokeWithResponseStream invokes the function synchronously, streamed responses are limited to
+// MaxStreamedResponse bytes and the responses of functions that do not stream to MaxSyncPayload bytes.
+func (f *Factory) InvokeWithResponseStream(arn string, payload any, w io.Writer) error {
+	l, ok := f.lambdas[arn]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, arn)
+	}
+	if err := f.checkRequest(payload, MaxSyncPayload); err != nil {
+		return err
+	}
+	if !f.RelaxLimits {
+		limit := MaxStreamedResponse
+		if l.runtime == nil {
+			limit = MaxSyncPayload
+		}
+		w = &limitWriter{w: w, limit: limit}
+	}
+	return l.InvokeWithResponseStream(payload, w)
+}
+
+// InvokeAsync invokes the function in the background, the request payload is limited to MaxAsyncPayload
+// bytes. Errors of the function are logged.
+func (f *Factory) InvokeAsync(arn string, payload any) error {
+	l, ok := f.lambdas[arn]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, arn)
+	}
+	if err := f.checkRequest(payload, MaxAsyncPayload); err != nil {
+		return err
+	}
+	go func() {
+		if _, err := l.Invoke(payload); err != nil {
+			log.Error().Err(err).Str("arn", arn).Msg("asynchronous invocation failed")
+		}
+	}()
+	return nil
+}
+
+// checkRequest returns a PayloadTooLargeError when the json of the payload is over the limit.
+func (f *Factory) checkRequest(payload any, limit int) error {
+	if f.RelaxLimits {
+		return nil
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return checkPayload(b, limit)
 }
 
 func (f *Factory) Add(input lambda.CreateFunctionInput) (string, error) {
@@ -136,11 +243,17 @@ func (f *Factory) Add(input lambda.CreateFunctionInput) (string, error) {
 
 	lda := &lambstack{
 		name:        *input.FunctionName,
+		arn:         arn,
 		port:        l.Addr().(*net.TCPAddr).Port,
 		timeout:     *input.Timeout,
 		path:        dest,
 		environment: envs,
 	}
+	if input.Runtime != nil && usesRuntimeAPI(*input.Runtime) {
+		if lda.runtime, err = newRuntimeAPI(); err != nil {
+			return "", err
+		}
+	}
 	f.lambdas[arn] = lda
 
 	return arn, lda.Start()
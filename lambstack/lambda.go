@@ -2,7 +2,6 @@ package lambstack
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/rpc"
 	"time"
@@ -20,6 +19,17 @@ type Input = struct {
 	Deadline      *messages.InvokeRequest_Timestamp
 }
 
+// FunctionError is the error returned by the function handler, as opposed to an error
+// invoking the function.
+type FunctionError struct {
+	Message string `json:"errorMessage"`
+	Type    string `json:"errorType,omitempty"`
+}
+
+func (e *FunctionError) Error() string {
+	return e.Message
+}
+
 // Run a Go based lambstack, passing the configured payload
 // note that 'payload' can be anything that can be encoded by encoding/json
 func Run(input Input) ([]byte, error) {
@@ -43,7 +53,7 @@ func Run(input Input) ([]byte, error) {
 	}
 
 	if response.Error != nil {
-		return nil, errors.New(response.Error.Message)
+		return nil, &FunctionError{Message: response.Error.Message, Type: response.Error.Type}
 	}
 
 	return response.Payload, nil
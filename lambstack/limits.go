@@ -0,0 +1,63 @@
+package lambstack
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxSyncPayload is the size limit of the request and response payloads of synchronous invocations.
+	MaxSyncPayload = 6 * 1024 * 1024
+	// MaxAsyncPayload is the size limit of the request payload of asynchronous invocations.
+	MaxAsyncPayload = 256 * 1024
+	// MaxStreamedResponse is the size limit of the responses streamed by functions.
+	MaxStreamedResponse = 20 * 1024 * 1024
+)
+
+// PayloadTooLargeError is returned when the request payload of an invocation is over the size limit, the
+// function is not invoked.
+type PayloadTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("Request must be smaller than %d bytes for the InvokeFunction operation", e.Limit)
+}
+
+// checkPayload returns a PayloadTooLargeError when the payload is over the limit.
+func checkPayload(payload []byte, limit int) error {
+	if len(payload) > limit {
+		return &PayloadTooLargeError{Size: len(payload), Limit: limit}
+	}
+	return nil
+}
+
+// responseTooLarge is the error of functions returning a response over the limit.
+func responseTooLarge(limit int) *FunctionError {
+	return &FunctionError{
+		Message: fmt.Sprintf("Response payload size exceeded maximum allowed payload size (%d bytes).", limit),
+		Type:    "Function.ResponseSizeTooLarge",
+	}
+}
+
+// limitWriter fails the write that takes the response over the limit, the writes before it reach the client
+// as the start of the response.
+type limitWriter struct {
+	w       io.Writer
+	written int
+	limit   int
+}
+
+func (l *limitWriter) ReadPrelude() {
+	ReadPrelude(l.w)
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.written+len(p) > l.limit {
+		return 0, responseTooLarge(l.limit)
+	}
+	n, err := l.w.Write(p)
+	l.written += n
+	return n, err
+}
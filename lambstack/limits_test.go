@@ -0,0 +1,130 @@
+package lambstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addSimpleLambda(t *testing.T, f *Factory) string {
+	arn, err := f.Add(lambda.CreateFunctionInput{
+		FunctionName: aws.String("simple"),
+		Code: &lambda.FunctionCode{
+			ZipFile: zipTestBinary(t, "examples/simple/simple"),
+		},
+		Timeout:     aws.Int64(5),
+		Environment: &lambda.Environment{},
+	})
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+	return arn
+}
+
+// failingFactory invokes functions that fail.
+type failingFactory struct {
+	LambdaFactory
+}
+
+func (failingFactory) Invoke(string, any) ([]byte, error) {
+	return nil, &FunctionError{Message: "boom", Type: "Error"}
+}
+
+func Test_InvocationPayloadLimits(t *testing.T) {
+	f := New()
+	defer f.Close()
+	arn := addSimpleLambda(t, f)
+
+	large := map[string]string{"name": strings.Repeat("a", MaxSyncPayload)}
+	_, err := f.Invoke(arn, large)
+	var tooLarge *PayloadTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, MaxSyncPayload, tooLarge.Limit)
+	assert.EqualError(t, err, "Request must be smaller than 6291456 bytes for the InvokeFunction operation")
+	err = f.InvokeWithResponseStream(arn, large, &bytes.Buffer{})
+	require.ErrorAs(t, err, &tooLarge)
+
+	err = f.InvokeAsync(arn, map[string]string{"name": strings.Repeat("a", MaxAsyncPayload)})
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, MaxAsyncPayload, tooLarge.Limit)
+	assert.NoError(t, f.InvokeAsync(arn, map[string]string{"name": "async"}))
+
+	f.RelaxLimits = true
+	resp, err := f.Invoke(arn, large)
+	require.NoError(t, err)
+	assert.Len(t, resp, MaxSyncPayload+9)
+}
+
+func Test_LimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitWriter{w: &buf, limit: 8}
+	n, err := w.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = w.Write([]byte("6789"))
+	var fnErr *FunctionError
+	require.ErrorAs(t, err, &fnErr)
+	assert.Equal(t, "Function.ResponseSizeTooLarge", fnErr.Type)
+	assert.Equal(t, "Response payload size exceeded maximum allowed payload size (8 bytes).", fnErr.Message)
+	assert.Equal(t, "12345", buf.String())
+}
+
+func Test_InvokeAPI(t *testing.T) {
+	f := New()
+	defer f.Close()
+	addSimpleLambda(t, f)
+	router := mux.NewRouter()
+	Register(router, f)
+
+	tests := []struct {
+		name           string
+		function       string
+		invocationType string
+		body           string
+		status         int
+		errorType      string
+		expected       string
+	}{
+		{name: "request response", function: "simple", body: `{"name":"api"}`, status: http.StatusOK, expected: `"Hello api!"`},
+		{name: "event", function: "simple", invocationType: "Event", body: `{"name":"api"}`, status: http.StatusAccepted},
+		{name: "dry run", function: "simple", invocationType: "DryRun", status: http.StatusNoContent},
+		{name: "unknown function", function: "missing", status: http.StatusNotFound, errorType: "ResourceNotFoundException"},
+		{name: "request over the limit", function: "simple", body: `{"name":"` + strings.Repeat("a", MaxSyncPayload) + `"}`, status: http.StatusRequestEntityTooLarge, errorType: "RequestEntityTooLargeException"},
+		{name: "event over the limit", function: "simple", invocationType: "Event", body: `{"name":"` + strings.Repeat("a", MaxAsyncPayload) + `"}`, status: http.StatusRequestEntityTooLarge, errorType: "RequestEntityTooLargeException"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://"+Host+"/2015-03-31/functions/"+tt.function+"/invocations", strings.NewReader(tt.body))
+			if tt.invocationType != "" {
+				req.Header.Set("X-Amz-Invocation-Type", tt.invocationType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.errorType, w.Header().Get("X-Amzn-ErrorType"))
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, w.Body.String())
+			}
+		})
+	}
+
+	// errors of the function are returned as the response
+	router = mux.NewRouter()
+	Register(router, failingFactory{})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://"+Host+"/2015-03-31/functions/example/invocations", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Unhandled", w.Header().Get("X-Amz-Function-Error"))
+	var fnErr FunctionError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fnErr))
+	assert.Equal(t, FunctionError{Message: "boom", Type: "Error"}, fnErr)
+}
@@ -0,0 +1,241 @@
+package lambstack
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	runtimeAPIVersion = "2018-06-01"
+
+	// responseModeHeader is set to streaming by functions streaming their response to the runtime api.
+	responseModeHeader = "Lambda-Runtime-Function-Response-Mode"
+	// errorTypeTrailer and errorBodyTrailer report the errors of functions once part of the response is streamed.
+	errorTypeTrailer = "Lambda-Runtime-Function-Error-Type"
+	errorBodyTrailer = "Lambda-Runtime-Function-Error-Body"
+)
+
+// invocation is an event waiting for, or being handled by, the function.
+type invocation struct {
+	id       string
+	arn      string
+	payload  []byte
+	deadline time.Time
+	w        io.Writer
+	done     chan error
+}
+
+// runtimeAPI is the Lambda Runtime API of a function using a custom runtime, such as provided.al2. The
+// function polls for the next invocation and posts its response, which is copied to the writer of the
+// invocation as it arrives when the function streams it.
+type runtimeAPI struct {
+	invocations chan *invocation
+	mu          sync.Mutex
+	pending     map[string]*invocation
+	listener    net.Listener
+	srv         *http.Server
+}
+
+func newRuntimeAPI() (*runtimeAPI, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	rt := &runtimeAPI{
+		invocations: make(chan *invocation),
+		pending:     map[string]*invocation{},
+		listener:    l,
+	}
+	router := mux.NewRouter()
+	r := router.PathPrefix("/" + runtimeAPIVersion + "/runtime").Subrouter()
+	r.HandleFunc("/invocation/next", rt.next).Methods(http.MethodGet)
+	r.HandleFunc("/invocation/{id}/response", rt.response).Methods(http.MethodPost)
+	r.HandleFunc("/invocation/{id}/error", rt.error).Methods(http.MethodPost)
+	r.HandleFunc("/init/error", rt.initError).Methods(http.MethodPost)
+	rt.srv = &http.Server{Handler: router, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := rt.srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("runtime api stopped")
+		}
+	}()
+	return rt, nil
+}
+
+// addr is the host and port of the runtime api, given to the function as AWS_LAMBDA_RUNTIME_API.
+func (rt *runtimeAPI) addr() string {
+	return rt.listener.Addr().String()
+}
+
+func (rt *runtimeAPI) Close() error {
+	return rt.srv.Close()
+}
+
+// invoke queues the event for the function and waits for its response to be written to w.
+func (rt *runtimeAPI) invoke(arn string, payload []byte, deadline time.Time, w io.Writer) error {
+	inv := &invocation{
+		id:       newRequestID(),
+		arn:      arn,
+		payload:  payload,
+		deadline: deadline,
+		w:        w,
+		done:     make(chan error, 1),
+	}
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case rt.invocations <- inv:
+	case <-timeout.C:
+		return fmt.Errorf("the function did not poll for the invocation before the deadline")
+	}
+	select {
+	case err := <-inv.done:
+		return err
+	case <-timeout.C:
+		if _, ok := rt.take(inv.id); !ok {
+			// the response is already being written, it is cut short at the deadline before the writer is released
+			return <-inv.done
+		}
+		return timedOut(inv.id)
+	}
+}
+
+// timedOut is the error of invocations that have not finished by their deadline.
+func timedOut(id string) *FunctionError {
+	return &FunctionError{Message: fmt.Sprintf("%s Task timed out", id), Type: "Sandbox.Timedout"}
+}
+
+// finish releases the invoker waiting for the invocation with the error, the invoker is released even when
+// writing the response panics.
+func (inv *invocation) finish(err *error) {
+	if p := recover(); p != nil {
+		inv.done <- fmt.Errorf("unable to write the function response error: %v", p)
+		panic(p)
+	}
+	inv.done <- *err
+}
+
+// take removes the pending invocation, so only one response is accepted for it.
+func (rt *runtimeAPI) take(id string) (*invocation, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	inv, ok := rt.pending[id]
+	delete(rt.pending, id)
+	return inv, ok
+}
+
+func (rt *runtimeAPI) next(w http.ResponseWriter, r *http.Request) {
+	var inv *invocation
+	select {
+	case inv = <-rt.invocations:
+	case <-r.Context().Done():
+		return
+	}
+	rt.mu.Lock()
+	rt.pending[inv.id] = inv
+	rt.mu.Unlock()
+	w.Header().Set("Lambda-Runtime-Aws-Request-Id", inv.id)
+	w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(inv.deadline.UnixMilli(), 10))
+	w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", inv.arn)
+	w.Header().Set("Lambda-Runtime-Trace-Id", "Root=1-00000000-000000000000000000000000;Parent")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(inv.payload)
+}
+
+func (rt *runtimeAPI) response(w http.ResponseWriter, r *http.Request) {
+	inv, ok := rt.take(mux.Vars(r)["id"])
+	if !ok {
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidRequestID", "the invocation is not pending")
+		return
+	}
+	var err error
+	defer inv.finish(&err)
+	// reading the response stops at the deadline, so functions cannot stream past their timeout
+	if deadlineErr := http.NewResponseController(w).SetReadDeadline(inv.deadline); deadlineErr != nil {
+		log.Error().Err(deadlineErr).Msg("unable to set the deadline of the response")
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == HTTPIntegrationContentType {
+		ReadPrelude(inv.w)
+	}
+	if strings.EqualFold(r.Header.Get(responseModeHeader), "streaming") {
+		// the response is copied as it arrives, errors after the response started are sent as trailers
+		if _, err = io.Copy(inv.w, r.Body); err == nil && r.Trailer.Get(errorTypeTrailer) != "" {
+			err = streamError(r.Trailer.Get(errorTypeTrailer), r.Trailer.Get(errorBodyTrailer))
+		}
+	} else {
+		var b []byte
+		if b, err = io.ReadAll(r.Body); err == nil {
+			_, err = inv.w.Write(b)
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = timedOut(inv.id)
+		writeRuntimeError(w, http.StatusRequestTimeout, "Sandbox.Timedout", "the invocation timed out")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (rt *runtimeAPI) error(w http.ResponseWriter, r *http.Request) {
+	inv, ok := rt.take(mux.Vars(r)["id"])
+	if !ok {
+		writeRuntimeError(w, http.StatusBadRequest, "InvalidRequestID", "the invocation is not pending")
+		return
+	}
+	var err error
+	defer inv.finish(&err)
+	fnErr := &FunctionError{Type: r.Header.Get("Lambda-Runtime-Function-Error-Type")}
+	if decodeErr := json.NewDecoder(r.Body).Decode(fnErr); decodeErr != nil || fnErr.Message == "" {
+		fnErr.Message = "the function returned an error"
+	}
+	err = fnErr
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (rt *runtimeAPI) initError(w http.ResponseWriter, r *http.Request) {
+	var fnErr FunctionError
+	_ = json.NewDecoder(r.Body).Decode(&fnErr)
+	log.Error().Str("error_type", fnErr.Type).Str("error_message", fnErr.Message).Msg("the function failed to initialise")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// streamError decodes the error trailers of a streamed response, the body is base64 encoded json.
+func streamError(errorType, body string) error {
+	fnErr := &FunctionError{Type: errorType, Message: errorType}
+	if b, err := base64.StdEncoding.DecodeString(body); err == nil {
+		_ = json.Unmarshal(b, fnErr)
+	}
+	return fnErr
+}
+
+func writeRuntimeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(FunctionError{Message: message, Type: errorType})
+}
+
+// newRequestID is a random uuid for the invocation.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// usesRuntimeAPI is true for the custom runtimes, the go1.x runtime is invoked over rpc.
+func usesRuntimeAPI(runtime string) bool {
+	return strings.HasPrefix(runtime, "provided")
+}
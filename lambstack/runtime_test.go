@@ -0,0 +1,232 @@
+package lambstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/internal/eventstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkWriter records when each chunk of a streamed response is written.
+type chunkWriter struct {
+	chunks []string
+	times  []time.Time
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, string(p))
+	c.times = append(c.times, time.Now())
+	return len(p), nil
+}
+
+func addStreamingLambda(t *testing.T, f LambdaFactory) string {
+	arn, err := f.Add(lambda.CreateFunctionInput{
+		FunctionName: aws.String("streaming"),
+		Runtime:      aws.String("provided.al2"),
+		Code: &lambda.FunctionCode{
+			ZipFile: zipTestBinary(t, "examples/streaming/streaming"),
+		},
+		Timeout:     aws.Int64(5),
+		Environment: &lambda.Environment{},
+	})
+	require.NoError(t, err)
+	return arn
+}
+
+func Test_WeCanStreamALambdaResponse(t *testing.T) {
+	f := New()
+	defer f.Close()
+	arn := addStreamingLambda(t, f)
+
+	w := &chunkWriter{}
+	start := time.Now()
+	err := f.InvokeWithResponseStream(arn, map[string]any{"chunks": []string{"one ", "two ", "three"}, "delay": 200}, w)
+	require.NoError(t, err)
+	assert.Equal(t, "one two three", strings.Join(w.chunks, ""))
+	require.Len(t, w.chunks, 3)
+	// the first chunk is written before the function has finished
+	assert.Less(t, w.times[0].Sub(start), 350*time.Millisecond)
+	assert.Greater(t, w.times[2].Sub(w.times[0]), 350*time.Millisecond)
+
+	b, err := f.Invoke(arn, map[string]any{"chunks": []string{"buffered ", "response"}})
+	require.NoError(t, err)
+	assert.Equal(t, "buffered response", string(b))
+
+	w = &chunkWriter{}
+	err = f.InvokeWithResponseStream(arn, map[string]any{"chunks": []string{"partial"}, "error": "stream failed"}, w)
+	assert.Equal(t, &FunctionError{Message: "stream failed", Type: "StreamError"}, err)
+	assert.Equal(t, []string{"partial"}, w.chunks)
+}
+
+func Test_InvokeWithResponseStreamAPI(t *testing.T) {
+	f := New()
+	defer f.Close()
+	addStreamingLambda(t, f)
+	r := mux.NewRouter()
+	Register(r, f)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		name, function, payload string
+		status                  int
+		chunks                  []string
+		complete                map[string]string
+	}{
+		{
+			name:     "stream",
+			function: "streaming",
+			payload:  `{"chunks":["one ","two"],"delay":100}`,
+			status:   http.StatusOK,
+			chunks:   []string{"one ", "two"},
+			complete: map[string]string{},
+		},
+		{
+			name:     "function error",
+			function: functionARNPrefix + "streaming",
+			payload:  `{"chunks":["one"],"error":"failed"}`,
+			status:   http.StatusOK,
+			chunks:   []string{"one"},
+			complete: map[string]string{"ErrorCode": "StreamError", "ErrorDetails": `{"errorMessage":"failed","errorType":"StreamError"}`},
+		},
+		{name: "unknown function", function: "missing", payload: `{}`, status: http.StatusNotFound},
+		{name: "invalid payload", function: "streaming", payload: `{`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/2021-11-15/functions/"+tt.function+"/response-streaming-invocations", strings.NewReader(tt.payload))
+			require.NoError(t, err)
+			req.Host = Host
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.status, resp.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/vnd.amazon.eventstream", resp.Header.Get("Content-Type"))
+			dec := eventstream.NewDecoder(resp.Body)
+			var chunks []string
+			for {
+				msg, err := dec.Decode()
+				require.NoError(t, err)
+				eventType := msg.Header(":event-type")
+				if eventType == "PayloadChunk" {
+					chunks = append(chunks, string(msg.Payload))
+					continue
+				}
+				require.Equal(t, "InvokeComplete", eventType)
+				var complete map[string]string
+				require.NoError(t, json.NewDecoder(bytes.NewReader(msg.Payload)).Decode(&complete))
+				assert.Equal(t, tt.complete, complete)
+				break
+			}
+			assert.Equal(t, tt.chunks, chunks)
+		})
+	}
+}
+
+func Test_RuntimeAPIEmptyResponse(t *testing.T) {
+	rt, err := newRuntimeAPI()
+	require.NoError(t, err)
+	defer rt.Close()
+
+	w := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		done <- rt.invoke("arn", []byte(`{}`), time.Now().Add(5*time.Second), NewHTTPResponseWriter(w))
+	}()
+	resp, err := http.Get("http://" + rt.addr() + "/2018-06-01/runtime/invocation/next")
+	require.NoError(t, err)
+	resp.Body.Close()
+	id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	resp, err = http.Post("http://"+rt.addr()+"/2018-06-01/runtime/invocation/"+id+"/response", "application/json", http.NoBody)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the invoker was not released")
+	}
+}
+
+func Test_StreamedResponsesStopAtTheTimeout(t *testing.T) {
+	f := New()
+	defer f.Close()
+	arn, err := f.Add(lambda.CreateFunctionInput{
+		FunctionName: aws.String("streaming-timeout"),
+		Runtime:      aws.String("provided.al2"),
+		Code: &lambda.FunctionCode{
+			ZipFile: zipTestBinary(t, "examples/streaming/streaming"),
+		},
+		Timeout:     aws.Int64(1),
+		Environment: &lambda.Environment{},
+	})
+	require.NoError(t, err)
+
+	w := &chunkWriter{}
+	start := time.Now()
+	err = f.InvokeWithResponseStream(arn, map[string]any{"chunks": []string{"one ", "two ", "three"}, "delay": 800}, w)
+	var fnErr *FunctionError
+	require.ErrorAs(t, err, &fnErr)
+	assert.Equal(t, "Sandbox.Timedout", fnErr.Type)
+	assert.Equal(t, []string{"one ", "two "}, w.chunks)
+	assert.Less(t, time.Since(start), 1500*time.Millisecond)
+
+	// the function is released for the next invocation
+	w = &chunkWriter{}
+	require.NoError(t, f.InvokeWithResponseStream(arn, map[string]any{"chunks": []string{"again"}}, w))
+	assert.Equal(t, []string{"again"}, w.chunks)
+}
+
+func Test_RuntimeAPIHTTPIntegrationResponse(t *testing.T) {
+	rt, err := newRuntimeAPI()
+	require.NoError(t, err)
+	defer rt.Close()
+
+	for _, contentType := range []string{HTTPIntegrationContentType, "application/json"} {
+		t.Run(contentType, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			stream := NewHTTPResponseWriter(w)
+			done := make(chan error, 1)
+			go func() {
+				done <- rt.invoke("arn", []byte(`{}`), time.Now().Add(5*time.Second), stream)
+			}()
+			resp, err := http.Get("http://" + rt.addr() + "/2018-06-01/runtime/invocation/next")
+			require.NoError(t, err)
+			resp.Body.Close()
+			id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+			body := `{"statusCode":201}` + strings.Repeat("\x00", 8) + "created"
+			req, err := http.NewRequest(http.MethodPost, "http://"+rt.addr()+"/2018-06-01/runtime/invocation/"+id+"/response", strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set(responseModeHeader, "streaming")
+			resp, err = http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.NoError(t, <-done)
+			require.NoError(t, stream.Close())
+
+			if contentType == HTTPIntegrationContentType {
+				assert.Equal(t, http.StatusCreated, w.Code)
+				assert.Equal(t, "created", w.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, body, w.Body.String())
+		})
+	}
+}
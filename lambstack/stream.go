@@ -0,0 +1,145 @@
+package lambstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// preludeDelimiter separates the prelude of a streamed http response from its body.
+var preludeDelimiter = make([]byte, 8)
+
+// maxPreludeSize bounds the bytes buffered looking for the end of the prelude.
+const maxPreludeSize = 1 << 20
+
+// HTTPIntegrationContentType is the content type of the responses functions stream with a json prelude, as set by
+// awslambda.HttpResponseStream.
+const HTTPIntegrationContentType = "application/vnd.awslambda.http-integration-response"
+
+// preludeReader is implemented by writers parsing the prelude of http integration responses.
+type preludeReader interface {
+	ReadPrelude()
+}
+
+// ReadPrelude marks the response written to w as an http integration response, so its json prelude is parsed
+// rather than written as the body.
+func ReadPrelude(w io.Writer) {
+	if p, ok := w.(preludeReader); ok {
+		p.ReadPrelude()
+	}
+}
+
+// flushWriter flushes the response after every write, so streamed responses reach the client as they arrive.
+type flushWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// NewFlushWriter writes to the response, flushing after every write.
+func NewFlushWriter(w http.ResponseWriter) io.Writer {
+	return &flushWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err = f.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
+
+// HTTPResponsePrelude is the status code, headers and cookies sent by a function streaming an http response
+// before the body, as the awslambda.HttpResponseStream of the runtime does.
+type HTTPResponsePrelude struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Cookies    []string          `json:"cookies"`
+}
+
+// HTTPResponseWriter writes the http response streamed by a function to the response. The json prelude of http
+// integration responses is followed by 8 null bytes and then the body, any other response is the body of a 200
+// response. The body is flushed as it is written.
+type HTTPResponseWriter struct {
+	w       http.ResponseWriter
+	out     io.Writer
+	buf     []byte
+	prelude bool
+	started bool
+	// ContentType is the content type of responses without a content type header.
+	ContentType string
+}
+
+func NewHTTPResponseWriter(w http.ResponseWriter) *HTTPResponseWriter {
+	return &HTTPResponseWriter{w: w, out: NewFlushWriter(w), ContentType: "application/octet-stream"}
+}
+
+// ReadPrelude parses the json prelude of the response before the body.
+func (h *HTTPResponseWriter) ReadPrelude() {
+	h.prelude = true
+}
+
+// Started is true once the status and headers of the response have been written.
+func (h *HTTPResponseWriter) Started() bool {
+	return h.started
+}
+
+func (h *HTTPResponseWriter) Write(p []byte) (int, error) {
+	if h.started {
+		return h.out.Write(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !h.prelude {
+		return len(p), h.start(HTTPResponsePrelude{}, p)
+	}
+	h.buf = append(h.buf, p...)
+	i := bytes.Index(h.buf, preludeDelimiter)
+	if i < 0 {
+		if len(h.buf) > maxPreludeSize {
+			return len(p), h.start(HTTPResponsePrelude{}, h.buf)
+		}
+		return len(p), nil
+	}
+	var prelude HTTPResponsePrelude
+	if err := json.Unmarshal(h.buf[:i], &prelude); err != nil {
+		return len(p), h.start(HTTPResponsePrelude{}, h.buf)
+	}
+	return len(p), h.start(prelude, h.buf[i+len(preludeDelimiter):])
+}
+
+// Close writes the response buffered looking for a prelude that never ended.
+func (h *HTTPResponseWriter) Close() error {
+	if h.started {
+		return nil
+	}
+	return h.start(HTTPResponsePrelude{}, h.buf)
+}
+
+func (h *HTTPResponseWriter) start(prelude HTTPResponsePrelude, body []byte) error {
+	h.started = true
+	h.buf = nil
+	for k, v := range prelude.Headers {
+		h.w.Header().Set(k, v)
+	}
+	for _, c := range prelude.Cookies {
+		h.w.Header().Add("Set-Cookie", c)
+	}
+	if h.w.Header().Get("Content-Type") == "" && h.ContentType != "" {
+		h.w.Header().Set("Content-Type", h.ContentType)
+	}
+	if prelude.StatusCode == 0 {
+		prelude.StatusCode = http.StatusOK
+	}
+	h.w.WriteHeader(prelude.StatusCode)
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := h.out.Write(body)
+	return err
+}
@@ -0,0 +1,107 @@
+package lambstack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPResponseWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		prelude     bool
+		writes      []string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "prelude",
+			prelude:     true,
+			writes:      []string{`{"statusCode":404,"headers":{"Content-Type":"text/plain"}}` + strings.Repeat("\x00", 8) + "not found"},
+			status:      http.StatusNotFound,
+			contentType: "text/plain",
+			body:        "not found",
+		},
+		{
+			name:        "prelude split across writes",
+			prelude:     true,
+			writes:      []string{`{"statusCode":202}`, "\x00\x00\x00\x00", "\x00\x00\x00\x00", "first ", "second"},
+			status:      http.StatusAccepted,
+			contentType: "application/octet-stream",
+			body:        "first second",
+		},
+		{
+			name:        "without a prelude",
+			writes:      []string{"plain ", "body"},
+			status:      http.StatusOK,
+			contentType: "application/octet-stream",
+			body:        "plain body",
+		},
+		{
+			name:        "prelude that never ends",
+			prelude:     true,
+			writes:      []string{`{"statusCode":202}`, "body"},
+			status:      http.StatusOK,
+			contentType: "application/octet-stream",
+			body:        `{"statusCode":202}body`,
+		},
+		{
+			name:        "prelude of a response without one",
+			writes:      []string{`{"statusCode":404}` + strings.Repeat("\x00", 8) + "body"},
+			status:      http.StatusOK,
+			contentType: "application/octet-stream",
+			body:        `{"statusCode":404}` + strings.Repeat("\x00", 8) + "body",
+		},
+		{
+			name:        "empty writes",
+			writes:      []string{"", "body", ""},
+			status:      http.StatusOK,
+			contentType: "application/octet-stream",
+			body:        "body",
+		},
+		{
+			name:        "json without a prelude",
+			writes:      []string{`{"name":"rex"}`},
+			status:      http.StatusOK,
+			contentType: "application/octet-stream",
+			body:        `{"name":"rex"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			stream := NewHTTPResponseWriter(w)
+			if tt.prelude {
+				ReadPrelude(stream)
+			}
+			for _, s := range tt.writes {
+				_, err := stream.Write([]byte(s))
+				require.NoError(t, err)
+			}
+			require.NoError(t, stream.Close())
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.True(t, w.Flushed)
+		})
+	}
+}
+
+func TestHTTPResponseWriterStreamsJSONLines(t *testing.T) {
+	w := httptest.NewRecorder()
+	stream := NewHTTPResponseWriter(w)
+	for _, line := range []string{`{"n":1}` + "\n", `{"n":2}` + "\n"} {
+		before := w.Body.Len()
+		_, err := stream.Write([]byte(line))
+		require.NoError(t, err)
+		// each line reaches the client as it is written
+		assert.Equal(t, line, w.Body.String()[before:])
+	}
+	require.NoError(t, stream.Close())
+	assert.Equal(t, http.StatusOK, w.Code)
+}
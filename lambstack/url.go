@@ -0,0 +1,279 @@
+package lambstack
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/mw"
+	"github.com/iwarapter/gostack/internal/sigv4"
+	"github.com/rs/zerolog/log"
+)
+
+// FunctionURLHostSuffix follows the name of the function in the hostname of its function url.
+const FunctionURLHostSuffix = ".lambda-url.127.0.0.1.nip.io"
+
+const (
+	authTypeNone        = "NONE"
+	authTypeIAM         = "AWS_IAM"
+	invokeModeBuffered  = "BUFFERED"
+	invokeModeStreaming = "RESPONSE_STREAM"
+	requestTimeFormat   = "02/Jan/2006:15:04:05 -0700"
+)
+
+// functionURL invokes a function with the requests to its function url.
+type functionURL struct {
+	lambs       LambdaFactory
+	name        string
+	arn         string
+	conf        config.FunctionURL
+	credentials []config.Credential
+}
+
+// AddFunctionURL serves the function from {name}.lambda-url.127.0.0.1.nip.io, requests are passed to the function
+// as events.LambdaFunctionURLRequest. Requests must be signed by one of the credentials for the lambda service
+// when the auth type is AWS_IAM.
+func AddFunctionURL(router *mux.Router, lambs LambdaFactory, name string, conf config.FunctionURL, credentials []config.Credential) error {
+	if conf.AuthType == "" {
+		conf.AuthType = authTypeNone
+	}
+	if conf.InvokeMode == "" {
+		conf.InvokeMode = invokeModeBuffered
+	}
+	if conf.AuthType != authTypeNone && conf.AuthType != authTypeIAM {
+		return fmt.Errorf("unable to add function url for %s error: auth type %s is not one of NONE or AWS_IAM", name, conf.AuthType)
+	}
+	if conf.InvokeMode != invokeModeBuffered && conf.InvokeMode != invokeModeStreaming {
+		return fmt.Errorf("unable to add function url for %s error: invoke mode %s is not one of BUFFERED or RESPONSE_STREAM", name, conf.InvokeMode)
+	}
+	u := &functionURL{lambs: lambs, name: name, arn: FunctionARN(name), conf: conf, credentials: credentials}
+	var h http.Handler = u
+	if conf.CORS != nil {
+		h = mw.CORS(*conf.CORS)(h)
+	}
+	router.Host(name + FunctionURLHostSuffix).Handler(h)
+	return nil
+}
+
+func (u *functionURL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subl := log.With().Str("handler", "lambda-function-url").Str("arn", u.arn).Logger()
+	var authorizer *events.LambdaFunctionURLRequestContextAuthorizerDescription
+	if u.conf.AuthType == authTypeIAM {
+		if r.Header.Get("Authorization") == "" {
+			subl.Info().Msg("missing authorization header")
+			writeURLError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		accessKeyID, err := sigv4.Verify(r, region, "lambda", sigv4.Secrets(u.credentials), time.Now())
+		if err != nil {
+			subl.Info().Err(err).Msg("invalid signature")
+			writeURLError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		authorizer = &events.LambdaFunctionURLRequestContextAuthorizerDescription{IAM: u.callerIdentity(accessKeyID)}
+	}
+	payload, err := u.event(r)
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to read body")
+		writeURLError(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+	payload.RequestContext.Authorizer = authorizer
+
+	if u.conf.InvokeMode == invokeModeStreaming {
+		u.stream(w, payload)
+		return
+	}
+	b, err := u.lambs.Invoke(u.arn, payload)
+	var fnErr *FunctionError
+	var tooLarge *PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		subl.Info().Err(err).Msg("request payload too large")
+		writeURLError(w, http.StatusRequestEntityTooLarge, tooLarge.Error())
+		return
+	}
+	if errors.As(err, &fnErr) {
+		subl.Error().Err(err).Msg("lambda returned an error")
+		writeURLError(w, http.StatusBadGateway, "Internal Server Error")
+		return
+	}
+	if err != nil {
+		subl.Error().Err(err).Msg("unable to invoke lambda")
+		writeURLError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err = writeURLResponse(w, b); err != nil {
+		subl.Error().Err(err).Msg("unable to unmarshal lambda response")
+		writeURLError(w, http.StatusBadGateway, "Internal Server Error")
+	}
+}
+
+// stream writes the response of the function as it is streamed, http integration responses start with the
+// status code and headers as a json prelude followed by 8 null bytes before the body.
+func (u *functionURL) stream(w http.ResponseWriter, payload events.LambdaFunctionURLRequest) {
+	subl := log.With().Str("handler", "lambda-function-url-stream").Str("arn", u.arn).Logger()
+	stream := NewHTTPResponseWriter(w)
+	err := u.lambs.InvokeWithResponseStream(u.arn, payload, stream)
+	if err == nil {
+		err = stream.Close()
+	}
+	if err == nil {
+		return
+	}
+	if stream.Started() {
+		// the status has been sent, so the response is cut short
+		subl.Error().Err(err).Msg("lambda response stream failed")
+		return
+	}
+	var tooLarge *PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		subl.Info().Err(err).Msg("request payload too large")
+		writeURLError(w, http.StatusRequestEntityTooLarge, tooLarge.Error())
+		return
+	}
+	subl.Error().Err(err).Msg("unable to invoke lambda")
+	writeURLError(w, http.StatusBadGateway, "Internal Server Error")
+}
+
+// event is the request as the payload of a function url invocation, headers are lower case and cookies are
+// passed separately. Bodies that are not text are base64 encoded.
+func (u *functionURL) event(r *http.Request) (events.LambdaFunctionURLRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.LambdaFunctionURLRequest{}, err
+	}
+	defer r.Body.Close()
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	var cookies []string
+	if cookie, ok := headers["cookie"]; ok {
+		delete(headers, "cookie")
+		for _, c := range strings.Split(cookie, ";") {
+			if c = strings.TrimSpace(c); c != "" {
+				cookies = append(cookies, c)
+			}
+		}
+	}
+	var qParams map[string]string
+	if query := r.URL.Query(); len(query) > 0 {
+		qParams = map[string]string{}
+		for k, v := range query {
+			qParams[k] = strings.Join(v, ",")
+		}
+	}
+	now := time.Now()
+	payload := events.LambdaFunctionURLRequest{
+		Version:               "2.0",
+		RawPath:               r.URL.Path,
+		RawQueryString:        r.URL.RawQuery,
+		Cookies:               cookies,
+		Headers:               headers,
+		QueryStringParameters: qParams,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			AccountID:    accountID,
+			RequestID:    newRequestID(),
+			APIID:        u.name,
+			DomainName:   u.name + FunctionURLHostSuffix,
+			DomainPrefix: u.name,
+			Time:         now.Format(requestTimeFormat),
+			TimeEpoch:    now.UnixMilli(),
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  r.Proto,
+				SourceIP:  mw.SourceIP(r),
+				UserAgent: r.UserAgent(),
+			},
+		},
+		Body: string(body),
+	}
+	if len(body) > 0 && !isText(r.Header.Get("Content-Type")) {
+		payload.Body = base64.StdEncoding.EncodeToString(body)
+		payload.IsBase64Encoded = true
+	}
+	return payload, nil
+}
+
+// writeURLResponse writes the response of the function. Responses with a statusCode are an
+// events.LambdaFunctionURLResponse, any other response is the json body of a 200 response. A null status code
+// defaults to 200 and the content type defaults to application/json.
+func writeURLResponse(w http.ResponseWriter, b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil || fields["statusCode"] == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+		return nil
+	}
+	var resp events.LambdaFunctionURLResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			return fmt.Errorf("unable to decode the base64 body error: %w", err)
+		}
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	for _, c := range resp.Cookies {
+		w.Header().Add("Set-Cookie", c)
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(body)
+	return nil
+}
+
+// callerIdentity is the iam identity of the credentials of the access key.
+func (u *functionURL) callerIdentity(accessKeyID string) *events.LambdaFunctionURLRequestContextAuthorizerIAMDescription {
+	id := sigv4.CallerIdentity(u.credentials, accessKeyID, accountID)
+	return &events.LambdaFunctionURLRequestContextAuthorizerIAMDescription{
+		AccessKey: id.AccessKey,
+		AccountID: id.AccountID,
+		CallerID:  id.Caller,
+		UserARN:   id.UserARN,
+		UserID:    id.Caller,
+	}
+}
+
+// isText is true for the content types passed to functions as they are, rather than base64 encoded.
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+func writeURLError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"Message": message})
+}
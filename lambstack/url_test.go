@@ -0,0 +1,229 @@
+package lambstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/gorilla/mux"
+	"github.com/iwarapter/gostack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// urlFactory invokes the handler as the function, recording the last payload.
+type urlFactory struct {
+	LambdaFactory
+	handler func(req events.LambdaFunctionURLRequest, w io.Writer) error
+	payload events.LambdaFunctionURLRequest
+}
+
+func (f *urlFactory) Invoke(arn string, payload any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := f.InvokeWithResponseStream(arn, payload, &buf)
+	return buf.Bytes(), err
+}
+
+func (f *urlFactory) InvokeWithResponseStream(arn string, payload any, w io.Writer) error {
+	if arn != FunctionARN("example") {
+		return ErrFunctionNotFound
+	}
+	f.payload = payload.(events.LambdaFunctionURLRequest)
+	return f.handler(f.payload, w)
+}
+
+func respond(body string) func(events.LambdaFunctionURLRequest, io.Writer) error {
+	return func(_ events.LambdaFunctionURLRequest, w io.Writer) error {
+		_, err := io.WriteString(w, body)
+		return err
+	}
+}
+
+func urlRouter(t *testing.T, f LambdaFactory, conf config.FunctionURL) *mux.Router {
+	router := mux.NewRouter()
+	require.NoError(t, AddFunctionURL(router, f, "example", conf, []config.Credential{{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Caller: "AIDAEXAMPLE"}}))
+	return router
+}
+
+func Test_FunctionURLResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		err      error
+		status   int
+		headers  http.Header
+		expected string
+	}{
+		{name: "json without a status code", response: `{"name":"rex"}`, status: http.StatusOK, headers: http.Header{"Content-Type": {"application/json"}}, expected: `{"name":"rex"}`},
+		{name: "json string", response: `"rex"`, status: http.StatusOK, headers: http.Header{"Content-Type": {"application/json"}}, expected: `"rex"`},
+		{name: "response", response: `{"statusCode":201,"headers":{"Content-Type":"text/plain"},"body":"created","cookies":["a=1","b=2"]}`, status: http.StatusCreated, headers: http.Header{"Content-Type": {"text/plain"}, "Set-Cookie": {"a=1", "b=2"}}, expected: "created"},
+		{name: "base64 response", response: `{"statusCode":200,"body":"aGVsbG8=","isBase64Encoded":true}`, status: http.StatusOK, expected: "hello"},
+		{name: "null status code", response: `{"statusCode":null,"body":"hello"}`, status: http.StatusOK, headers: http.Header{"Content-Type": {"application/json"}}, expected: "hello"},
+		{name: "missing status code", response: `{"headers":{"Content-Type":"text/plain"},"body":"hello"}`, status: http.StatusOK, headers: http.Header{"Content-Type": {"application/json"}}, expected: `{"headers":{"Content-Type":"text/plain"},"body":"hello"}`},
+		{name: "response without a content type", response: `{"statusCode":202,"body":"{}"}`, status: http.StatusAccepted, headers: http.Header{"Content-Type": {"application/json"}}, expected: "{}"},
+		{name: "invalid response", response: `{"statusCode":"ok"}`, status: http.StatusBadGateway, expected: `{"Message":"Internal Server Error"}` + "\n"},
+		{name: "function error", err: &FunctionError{Message: "boom"}, status: http.StatusBadGateway, expected: `{"Message":"Internal Server Error"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &urlFactory{handler: respond(tt.response)}
+			if tt.err != nil {
+				f.handler = func(events.LambdaFunctionURLRequest, io.Writer) error { return tt.err }
+			}
+			w := httptest.NewRecorder()
+			urlRouter(t, f, config.FunctionURL{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.lambda-url.127.0.0.1.nip.io/", nil))
+			assert.Equal(t, tt.status, w.Code)
+			for k, v := range tt.headers {
+				assert.Equal(t, v, w.Header().Values(k), k)
+			}
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func Test_FunctionURLEvent(t *testing.T) {
+	f := &urlFactory{handler: respond(`{}`)}
+	router := urlRouter(t, f, config.FunctionURL{})
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.lambda-url.127.0.0.1.nip.io:8080/pets/rex?a=1&a=2&b=3", strings.NewReader(`{"name":"rex"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", "session=abc; theme=dark")
+	req.Header.Add("X-Multi", "one")
+	req.Header.Add("X-Multi", "two")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	p := f.payload
+	assert.Equal(t, "2.0", p.Version)
+	assert.Equal(t, "/pets/rex", p.RawPath)
+	assert.Equal(t, "a=1&a=2&b=3", p.RawQueryString)
+	assert.Equal(t, map[string]string{"a": "1,2", "b": "3"}, p.QueryStringParameters)
+	assert.Equal(t, []string{"session=abc", "theme=dark"}, p.Cookies)
+	assert.Equal(t, "one,two", p.Headers["x-multi"])
+	assert.NotContains(t, p.Headers, "cookie")
+	assert.Equal(t, `{"name":"rex"}`, p.Body)
+	assert.False(t, p.IsBase64Encoded)
+	assert.Equal(t, "example.lambda-url.127.0.0.1.nip.io", p.RequestContext.DomainName)
+	assert.Equal(t, "POST", p.RequestContext.HTTP.Method)
+	assert.Equal(t, "192.0.2.1", p.RequestContext.HTTP.SourceIP)
+	assert.NotEmpty(t, p.RequestContext.RequestID)
+	assert.Nil(t, p.RequestContext.Authorizer)
+
+	req = httptest.NewRequest(http.MethodPut, "http://example.lambda-url.127.0.0.1.nip.io/image", bytes.NewReader([]byte{0xff, 0xd8}))
+	req.Header.Set("Content-Type", "image/jpeg")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "/9g=", f.payload.Body)
+	assert.True(t, f.payload.IsBase64Encoded)
+	assert.Nil(t, f.payload.QueryStringParameters)
+}
+
+func Test_FunctionURLIAM(t *testing.T) {
+	f := &urlFactory{handler: respond(`{"ok":true}`)}
+	router := urlRouter(t, f, config.FunctionURL{AuthType: "AWS_IAM"})
+
+	request := func(accessKeyID, secret, service string) *http.Request {
+		body := `{"name":"rex"}`
+		req := httptest.NewRequest(http.MethodPost, "http://example.lambda-url.127.0.0.1.nip.io/pets", strings.NewReader(body))
+		if accessKeyID != "" {
+			signer := v4.NewSigner(credentials.NewStaticCredentials(accessKeyID, secret, ""))
+			_, err := signer.Sign(req, bytes.NewReader([]byte(body)), service, "us-east-1", time.Now())
+			require.NoError(t, err)
+		}
+		return req
+	}
+	tests := []struct {
+		name     string
+		req      *http.Request
+		status   int
+		expected string
+	}{
+		{name: "signed request", req: request("AKIDEXAMPLE", "secret", "lambda"), status: http.StatusOK, expected: `{"ok":true}`},
+		{name: "unsigned request", req: request("", "", ""), status: http.StatusForbidden, expected: `{"Message":"Forbidden"}` + "\n"},
+		{name: "unknown access key", req: request("AKIDUNKNOWN", "secret", "lambda"), status: http.StatusForbidden, expected: `{"Message":"The security token included in the request is invalid."}` + "\n"},
+		{name: "wrong service", req: request("AKIDEXAMPLE", "secret", "execute-api"), status: http.StatusForbidden, expected: `{"Message":"Credential should be scoped to correct service: 'lambda'. "}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+	require.NotNil(t, f.payload.RequestContext.Authorizer)
+	assert.Equal(t, &events.LambdaFunctionURLRequestContextAuthorizerIAMDescription{
+		AccessKey: "AKIDEXAMPLE",
+		AccountID: "123456789012",
+		CallerID:  "AIDAEXAMPLE",
+		UserARN:   "arn:aws:iam::123456789012:user/AKIDEXAMPLE",
+		UserID:    "AIDAEXAMPLE",
+	}, f.payload.RequestContext.Authorizer.IAM)
+}
+
+func Test_FunctionURLCORS(t *testing.T) {
+	f := &urlFactory{handler: respond(`{"statusCode":200,"headers":{"Access-Control-Allow-Origin":"*"},"body":"ok"}`)}
+	router := urlRouter(t, f, config.FunctionURL{AuthType: "AWS_IAM", CORS: &config.CORS{
+		AllowOrigins: []string{"http://localhost:*"},
+		AllowMethods: []string{"GET", "POST"},
+		MaxAge:       300,
+	}})
+
+	// preflight requests are answered without a signature
+	req := httptest.NewRequest(http.MethodOptions, "http://example.lambda-url.127.0.0.1.nip.io/pets", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "300", w.Header().Get("Access-Control-Max-Age"))
+
+	// the cors headers of the function are replaced
+	router = urlRouter(t, f, config.FunctionURL{CORS: &config.CORS{AllowOrigins: []string{"http://localhost:*"}}})
+	req = httptest.NewRequest(http.MethodGet, "http://example.lambda-url.127.0.0.1.nip.io/pets", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func Test_FunctionURLResponseStream(t *testing.T) {
+	prelude, _ := json.Marshal(HTTPResponsePrelude{StatusCode: http.StatusAccepted, Headers: map[string]string{"Content-Type": "text/plain"}})
+	f := &urlFactory{handler: func(_ events.LambdaFunctionURLRequest, w io.Writer) error {
+		ReadPrelude(w)
+		for _, chunk := range [][]byte{prelude, make([]byte, 8), []byte("one "), []byte("two")} {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	w := httptest.NewRecorder()
+	urlRouter(t, f, config.FunctionURL{InvokeMode: "RESPONSE_STREAM"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.lambda-url.127.0.0.1.nip.io/", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "one two", w.Body.String())
+	assert.True(t, w.Flushed)
+
+	f.handler = func(events.LambdaFunctionURLRequest, io.Writer) error { return &FunctionError{Message: "boom"} }
+	w = httptest.NewRecorder()
+	urlRouter(t, f, config.FunctionURL{InvokeMode: "RESPONSE_STREAM"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.lambda-url.127.0.0.1.nip.io/", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func Test_AddFunctionURLValidatesTheConfiguration(t *testing.T) {
+	router := mux.NewRouter()
+	err := AddFunctionURL(router, &urlFactory{}, "example", config.FunctionURL{AuthType: "COGNITO"}, nil)
+	assert.EqualError(t, err, "unable to add function url for example error: auth type COGNITO is not one of NONE or AWS_IAM")
+	err = AddFunctionURL(router, &urlFactory{}, "example", config.FunctionURL{InvokeMode: "STREAM"}, nil)
+	assert.EqualError(t, err, "unable to add function url for example error: invoke mode STREAM is not one of BUFFERED or RESPONSE_STREAM")
+}
@@ -1,24 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gorilla/handlers"
-
 	"github.com/iwarapter/gostack/internal/mw"
 
 	"github.com/jessevdk/go-flags"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
 	"github.com/iwarapter/gostack/alb"
 	"github.com/iwarapter/gostack/apigw"
 	"github.com/iwarapter/gostack/config"
+	"github.com/iwarapter/gostack/internal/cognito"
 	"github.com/iwarapter/gostack/lambstack"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -26,7 +28,7 @@ import (
 )
 
 type detailedResponseWriter struct {
-	http.ResponseWriter
+	mw.ResponseWriter
 	statusCode int
 }
 
@@ -39,10 +41,18 @@ func (dw *detailedResponseWriter) StatusCode() int {
 	return dw.statusCode
 }
 
+func (dw *detailedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := dw.ResponseWriter.Hijack()
+	if err == nil {
+		dw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		dw := &detailedResponseWriter{ResponseWriter: w}
+		dw := &detailedResponseWriter{ResponseWriter: mw.ResponseWriter{ResponseWriter: w}}
 		next.ServeHTTP(dw, r)
 		log.Info().
 			Str("method", r.Method).
@@ -57,11 +67,16 @@ func Logger(next http.Handler) http.Handler {
 type Opts struct {
 	Config string `short:"c" long:"config" description:"Path to the configuration file" default:"gostack.yml"`
 	Port   int    `short:"p" long:"port" description:"Listener port" default:"8080"`
+	Strict bool   `long:"strict" description:"Fail when a spec has constructs that are skipped or only partially supported"`
+
+	Export ExportCommand `command:"export" description:"Export the effective configuration of an api as OpenAPI 3"`
 }
 
 func main() {
 	opts := Opts{}
-	_, err := flags.Parse(&opts)
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.Parse()
 	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
@@ -71,40 +86,67 @@ func main() {
 	}
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	b, err := os.ReadFile("gostack.yml")
+	stack, err := loadConfig(opts.Config)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to load gostack file")
 	}
-	var stack config.GoStack
-	err = yaml.Unmarshal([]byte(os.ExpandEnv(string(b))), &stack)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to load gostack file")
+	if parser.Active != nil && parser.Active.Name == "export" {
+		if err = runExport(stack, opts.Export, opts.Strict); err != nil {
+			log.Fatal().Err(err).Msg("unable to export api")
+		}
+		return
 	}
 
 	lambs := lambstack.New()
+	lambs.RelaxLimits = stack.RelaxLimits
 	defer lambs.Close()
-	router, err := setupStack(stack, lambs, opts.Port)
+	router, err := setupStack(stack, lambs, opts.Port, opts.Strict)
 	if err != nil {
 		log.Error().Err(err).Msg("unable to setup stack")
-		return
+		_ = lambs.Close()
+		os.Exit(1)
 	}
-	headersOk := handlers.AllowedHeaders([]string{"Authorization", "Content-Type"})
-	originsOk := handlers.AllowedOrigins([]string{"http://alb.127.0.0.1.nip.io:8080"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	// there is no write timeout, streamed responses last as long as the function is streaming
 	srv := &http.Server{
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		Handler:      handlers.CORS(originsOk, headersOk, methodsOk, handlers.AllowCredentials())(router),
-		Addr:         ":" + strconv.Itoa(opts.Port),
+		ReadTimeout: 5 * time.Second,
+		Handler:     router,
+		Addr:        ":" + strconv.Itoa(opts.Port),
 	}
 	log.Error().Err(srv.ListenAndServe()).Send()
 }
 
-func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int) (http.Handler, error) {
+func loadConfig(path string) (config.GoStack, error) {
+	var stack config.GoStack
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return stack, err
+	}
+	err = yaml.Unmarshal([]byte(os.ExpandEnv(string(b))), &stack)
+	return stack, err
+}
+
+// checkDiagnostics fails in strict mode when the import of the api reported any diagnostics.
+func checkDiagnostics(api *apigw.API, apicfg config.APIGW, strict bool) error {
+	diagnostics := api.Diagnostics()
+	if !strict || len(diagnostics) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		messages = append(messages, d.String())
+	}
+	return fmt.Errorf("spec %s of api %s has %d unsupported constructs: %s", apicfg.OA3path, apicfg.ID, len(diagnostics), strings.Join(messages, "; "))
+}
+
+func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int, strict bool) (http.Handler, error) {
 	router := mux.NewRouter()
 	router.Use(Logger)
 	router.Use(mw.XForwardedFor)
 
+	apis := map[string]*apigw.API{}
+	// the export endpoint is registered before the api routers, which serve everything under their prefix
+	router.Host("api.127.0.0.1.nip.io").Path("/restapis/{id}/stages/{stage}/exports/{type}").
+		Methods(http.MethodGet).Handler(apigw.ExportHandler(apis))
 	apiRouter := router.Host("api.127.0.0.1.nip.io").Subrouter()
 	apiRouter = apiRouter.PathPrefix("/restapis").Subrouter()
 
@@ -115,9 +157,14 @@ func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int) (
 			log.Error().Err(err).Str("lambda", l.Name).Str("path", l.Zip).Msg("unable to load lambda zip")
 			return nil, err
 		}
+		timeout := int64(5)
+		if l.Timeout > 0 {
+			timeout = int64(l.Timeout)
+		}
 		arn, err := lambs.Add(lambda.CreateFunctionInput{
-			Timeout:      aws.Int64(5),
+			Timeout:      aws.Int64(timeout),
 			FunctionName: aws.String(l.Name),
+			Runtime:      aws.String(l.Runtime),
 			Code: &lambda.FunctionCode{
 				ZipFile: contents,
 			},
@@ -130,12 +177,37 @@ func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int) (
 			return nil, err
 		}
 		log.Info().Str("arn", arn).Msg("lambda started successfully")
+		if l.FunctionURL != nil {
+			if err = lambstack.AddFunctionURL(router, lambs, l.Name, *l.FunctionURL, stack.Credentials); err != nil {
+				log.Error().Err(err).Str("lambda", l.Name).Msg("unable to add function url")
+				return nil, err
+			}
+		}
 	}
 
+	lambstack.Register(router, lambs)
+
+	idp := cognito.New(stack.UserPools, stack.MockData)
+	idp.Register(router)
+	plans := apigw.NewUsagePlans(stack.APIKeys, stack.UsagePlans)
+
 	for _, apicfg := range stack.APIs {
-		api := apigw.New(apiRouter, lambs, apicfg.ID)
-		loader := openapi3.NewLoader()
-		doc, err := loader.LoadFromFile(apicfg.OA3path)
+		if strings.EqualFold(apicfg.Type, "websocket") {
+			ws := apigw.NewWebSocket(apiRouter, lambs, apicfg)
+			ws.MountExecuteAPI(router)
+			continue
+		}
+		api := apigw.New(apiRouter, lambs, apicfg)
+		api.IdentityProvider = idp
+		api.UsagePlans = plans
+		api.Credentials = stack.Credentials
+		api.RelaxLimits = stack.RelaxLimits
+		if err := api.MountExecuteAPI(router); err != nil {
+			log.Error().Err(err).Str("apigw", apicfg.ID).Msg("unable to mount execute-api hostnames")
+			return nil, err
+		}
+		apis[apicfg.ID] = api
+		doc, err := apigw.LoadSpec(apicfg.OA3path)
 		if err != nil {
 			log.Error().Err(err).Str("apigw", apicfg.ID).Str("path", apicfg.OA3path).Msg("unable to load openapi3 spec from file")
 			return nil, err
@@ -145,6 +217,16 @@ func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int) (
 			log.Error().Err(err).Str("apigw", apicfg.ID).Str("path", apicfg.OA3path).Msg("unable to import openapi3 spec into router")
 			return nil, err
 		}
+		if err = checkDiagnostics(api, apicfg, strict); err != nil {
+			log.Error().Err(err).Str("apigw", apicfg.ID).Str("path", apicfg.OA3path).Msg("unable to import openapi3 spec in strict mode")
+			return nil, err
+		}
+	}
+	for _, domain := range stack.Domains {
+		if err := apigw.AddDomain(router, domain, apis); err != nil {
+			log.Error().Err(err).Str("domain", domain.Name).Msg("unable to add custom domain")
+			return nil, err
+		}
 	}
 	for _, a := range stack.ALBs {
 		lb := alb.New(router, lambs, a, stack, port)
@@ -166,5 +248,9 @@ func setupStack(stack config.GoStack, lambs lambstack.LambdaFactory, port int) (
 		log.Error().Err(err).Msg("unable to walk the router")
 		return nil, err
 	}
+	if stack.CORS != nil {
+		// the top level cors configuration overrides the apis and albs for the allowed origins
+		return mw.CORS(*stack.CORS)(router), nil
+	}
 	return router, nil
 }